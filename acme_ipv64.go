@@ -1,9 +1,8 @@
 package caddyipv64
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"strings"
@@ -14,6 +13,8 @@ import (
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"go.uber.org/zap"
+
+	"github.com/Sickjuicy/caddy-ipv64/internal/ipv64api"
 )
 
 // ModuleName is the module name for Caddy.
@@ -26,21 +27,62 @@ type AcmeIPv64Module struct {
 	// Token is the ipv64 DynDNS key (Bearer-like token) used for the update endpoint.
 	Token string `json:"token,omitempty"`
 
+	// APIToken is the account API token, used instead if Token is not set.
+	APIToken string `json:"api_token,omitempty"`
+
 	// Domain is the hostname managed at ipv64 that should resolve to this server (A/AAAA via DynDNS API).
 	Domain string `json:"domain,omitempty"`
 
+	// Endpoint overrides the base URL of the ipv64 API (e.g. for a fakeserver).
+	Endpoint string `json:"endpoint,omitempty"`
+
 	// UpdateOnStart triggers a DynDNS update during provisioning/startup.
 	UpdateOnStart bool `json:"update_on_start,omitempty"`
 
+	DynUpdateOptions
+
+	// StatusPath, if set, is the request path at which a JSON status document
+	// is served: last update time and result, current IP, whether updates
+	// are halted and the recent errors.
+	StatusPath string `json:"status_path,omitempty"`
+
+	// HeartbeatURL, if set, is an ipv64 healthcheck URL that is pinged after
+	// every successful update, so ipv64 alerts when the updates stop.
+	HeartbeatURL string `json:"heartbeat_url,omitempty"`
+
+	// Freshness skips the update on start if the domain was updated within
+	// this window to the current address, as remembered in Caddy storage
+	// across restarts (default 0: always update on start).
+	Freshness caddy.Duration `json:"freshness,omitempty"`
+
+	// Webhook, if set, receives a POST with a JSON object (domain, old_ip,
+	// new_ip, timestamp) whenever an update changes the address.
+	Webhook string `json:"webhook,omitempty"`
+
 	// IntervalSeconds triggers periodic updates (set to 0 to disable).
 	IntervalSeconds int `json:"interval_seconds,omitempty"`
 
+	// IPSources determine the address sent with the updates, tried in order.
+	// Without sources, ipv64 uses the address the update comes from, which
+	// is wrong behind a proxy.
+	IPSources []IPSource `json:"ip_sources,omitempty"`
+
+	// Interface, if set, is the network interface whose public addresses
+	// are sent with the updates, ahead of any IPSources.
+	Interface string `json:"interface,omitempty"`
+
 	// UpdateOnChallenge updates right before serving an ACME HTTP-01 request path.
 	// Note: DNS propagation is not instantaneous; prefer UpdateOnStart and/or intervals.
 	UpdateOnChallenge bool `json:"update_on_challenge,omitempty"`
 
 	// internal ticker control
 	stopPeriodic chan struct{}
+
+	events eventEmitter
+	logger *zap.Logger
+	health *dynHealth
+	ips    *ipHistory
+	status *dynStatus
 }
 
 // CaddyModule returns the Caddy module information.
@@ -58,9 +100,24 @@ func (m *AcmeIPv64Module) Provision(ctx caddy.Context) error {
 		return err
 	}
 
-	lg := ctx.Logger(m)
+	lg := redactLogger(ctx.Logger(m), ipv64api.NewRedactor(m.Token, m.APIToken))
+	m.logger = lg
+	events, err := newEventEmitter(ctx)
+	if err != nil {
+		return fmt.Errorf("getting events app: %v", err)
+	}
+	m.events = events
+	initMetrics(ctx.GetMetricsRegistry())
+	m.health = new(dynHealth)
+	m.ips = new(ipHistory)
+	m.status = new(dynStatus)
+	m.IPSources = withInterface(m.Interface, m.IPSources)
 
-	if m.UpdateOnStart {
+	m.ips.restore(ctx, ctx.Storage(), lg, []string{m.Domain})
+
+	if m.UpdateOnStart && startupFresh(ctx, m.ips, m.IPSources, []string{m.Domain}, time.Duration(m.Freshness)) {
+		lg.Info("ipv64 dynDNS: address is fresh, skipping the update on start")
+	} else if m.UpdateOnStart {
 		if err := m.ipv64Update(""); err != nil {
 			lg.Warn("ipv64 dynDNS update on start failed", zap.Error(err))
 		} else {
@@ -72,16 +129,22 @@ func (m *AcmeIPv64Module) Provision(ctx caddy.Context) error {
 		m.stopPeriodic = make(chan struct{})
 		interval := time.Duration(m.IntervalSeconds) * time.Second
 		go func() {
-			ticker := time.NewTicker(interval)
-			defer ticker.Stop()
+			sched := newDynSchedule(interval)
+			timer := time.NewTimer(sched.next(true))
+			defer timer.Stop()
 			for {
 				select {
-				case <-ticker.C:
-					if err := m.ipv64Update(""); err != nil {
+				case <-timer.C:
+					err := m.ipv64Update("")
+					if _, halted := m.health.degraded(); halted {
+						return
+					}
+					if err != nil {
 						lg.Warn("ipv64 dynDNS periodic update failed", zap.Error(err))
 					} else {
 						lg.Debug("ipv64 dynDNS periodic update succeeded")
 					}
+					timer.Reset(sched.next(err == nil))
 				case <-m.stopPeriodic:
 					return
 				}
@@ -89,24 +152,34 @@ func (m *AcmeIPv64Module) Provision(ctx caddy.Context) error {
 		}()
 	}
 
+	trackLive(live.modules, m, true)
 	return nil
 }
 
 // Validate validates the module config.
 func (m *AcmeIPv64Module) Validate() error {
-	if m.Token == "" || m.Domain == "" {
-		return fmt.Errorf("token and domain must be set")
+	if (m.Token == "" && m.APIToken == "") || m.Domain == "" {
+		return fmt.Errorf("token or api_token, and domain must be set")
 	}
-	return nil
+	for _, s := range m.IPSources {
+		if err := s.validate(); err != nil {
+			return err
+		}
+	}
+	return m.DynUpdateOptions.validate()
 }
 
 // ServeHTTP handles HTTP-01 ACME challenges by updating ipv64.net.
 func (m *AcmeIPv64Module) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if m.StatusPath != "" && r.URL.Path == m.StatusPath {
+		return serveStatus(w, m.status.doc(m.Domain, m.health))
+	}
 	// Optionally trigger a DynDNS update when we detect an ACME HTTP-01 request.
 	if m.UpdateOnChallenge && strings.HasPrefix(r.URL.Path, "/.well-known/acme-challenge/") {
 		// Fire-and-forget; do not block the response path.
 		go m.ipv64Update("")
 	}
+	addIPPlaceholders(r)
 	return next.ServeHTTP(w, r)
 }
 
@@ -116,6 +189,7 @@ var _ caddy.CleanerUpper = (*AcmeIPv64Module)(nil)
 
 // Cleanup stops background routines.
 func (m *AcmeIPv64Module) Cleanup() error {
+	trackLive(live.modules, m, false)
 	if m.stopPeriodic != nil {
 		close(m.stopPeriodic)
 	}
@@ -132,13 +206,47 @@ func (m *AcmeIPv64Module) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.ArgErr()
 				}
 				m.Token = d.Val()
+			case "api_token":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.APIToken = d.Val()
 			case "domain":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				m.Domain = d.Val()
+			case "endpoint":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.Endpoint = d.Val()
+			case "webhook":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.Webhook = d.Val()
+			case "heartbeat":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.HeartbeatURL = d.Val()
+			case "status_path":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.StatusPath = d.Val()
 			case "update_on_start":
 				m.UpdateOnStart = true
+			case "freshness":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid freshness: %v", err)
+				}
+				m.Freshness = caddy.Duration(dur)
 			case "interval_seconds":
 				if !d.NextArg() {
 					return d.ArgErr()
@@ -150,6 +258,21 @@ func (m *AcmeIPv64Module) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				m.IntervalSeconds = v
 			case "update_on_challenge":
 				m.UpdateOnChallenge = true
+			case "wildcard", "mx", "backup_mx", "ttl":
+				if err := m.DynUpdateOptions.unmarshalOption(d); err != nil {
+					return err
+				}
+			case "interface":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.Interface = d.Val()
+			case "ip_source":
+				src, err := unmarshalIPSource(d)
+				if err != nil {
+					return err
+				}
+				m.IPSources = append(m.IPSources, src)
 			}
 		}
 	}
@@ -157,26 +280,50 @@ func (m *AcmeIPv64Module) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 }
 
 // ipv64Update calls the ipv64.net DynDNS2 API to update the challenge record.
-func (m *AcmeIPv64Module) ipv64Update(ip string) error {
-	apiURL := "https://ipv64.net/nic/update"
+func (m *AcmeIPv64Module) ipv64Update(ip string) (err error) {
+	if _, halted := m.health.degraded(); halted {
+		return errDynHalted
+	}
+	var result string
+	var res *ipv64api.DynResult
+	defer func() {
+		m.status.record(result, err)
+		observeDynUpdate(m.Domain, res, err)
+	}()
 	params := url.Values{}
-	params.Set("key", m.Token)
 	params.Set("domain", m.Domain)
+	if ip == "" && len(m.IPSources) > 0 {
+		ip4, ip6, err := lookupPublicIP(context.Background(), m.IPSources)
+		if err != nil {
+			return err
+		}
+		if ip4.IsValid() {
+			params.Set("ip", ip4.String())
+		}
+		if ip6.IsValid() {
+			params.Set("ip6", ip6.String())
+		}
+	}
 	if ip != "" {
 		params.Set("ip", ip)
 	}
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(apiURL + "?" + params.Encode())
+	m.DynUpdateOptions.apply(params)
+	res, err = dynDNSUpdate(context.Background(), m.Endpoint, dynAuth{key: m.Token, accountToken: m.APIToken}, params)
 	if err != nil {
+		if m.health.fail(err) {
+			haltDynDNS(m.logger, m.events, m.Domain, err)
+		}
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("ipv64.net API error: %s", resp.Status)
+	result = res.Raw
+	logDynResult(m.logger, m.Domain, res)
+	emitIPChanged(m.events, m.Domain, res)
+	notifyIPChange(context.Background(), m.logger, m.ips, m.Webhook, m.Domain, res, params)
+	if m.HeartbeatURL != "" {
+		if err := pingHeartbeat(context.Background(), m.HeartbeatURL); err != nil {
+			m.logger.Warn("ipv64 dynDNS: heartbeat failed", zap.Error(err))
+		}
 	}
-	body, _ := io.ReadAll(resp.Body)
-	var result map[string]interface{}
-	_ = json.Unmarshal(body, &result)
 	return nil
 }
 
@@ -197,13 +344,47 @@ func parseAcmeIPv64Caddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandle
 					return nil, h.ArgErr()
 				}
 				m.Token = h.Val()
+			case "api_token":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.APIToken = h.Val()
 			case "domain":
 				if !h.NextArg() {
 					return nil, h.ArgErr()
 				}
 				m.Domain = h.Val()
+			case "endpoint":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.Endpoint = h.Val()
+			case "webhook":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.Webhook = h.Val()
+			case "heartbeat":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.HeartbeatURL = h.Val()
+			case "status_path":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.StatusPath = h.Val()
 			case "update_on_start":
 				m.UpdateOnStart = true
+			case "freshness":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid freshness: %v", err)
+				}
+				m.Freshness = caddy.Duration(dur)
 			case "interval_seconds":
 				if !h.NextArg() {
 					return nil, h.ArgErr()
@@ -215,6 +396,21 @@ func parseAcmeIPv64Caddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandle
 				m.IntervalSeconds = v
 			case "update_on_challenge":
 				m.UpdateOnChallenge = true
+			case "wildcard", "mx", "backup_mx", "ttl":
+				if err := m.DynUpdateOptions.unmarshalOption(h.Dispenser); err != nil {
+					return nil, err
+				}
+			case "interface":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.Interface = h.Val()
+			case "ip_source":
+				src, err := unmarshalIPSource(h.Dispenser)
+				if err != nil {
+					return nil, err
+				}
+				m.IPSources = append(m.IPSources, src)
 			default:
 				return nil, h.Errf("unrecognized option: %s", h.Val())
 			}
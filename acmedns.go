@@ -0,0 +1,295 @@
+package caddyipv64
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/certmagic"
+	"github.com/libdns/libdns"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(ACMEDNS{})
+	httpcaddyfile.RegisterHandlerDirective("ipv64_acmedns", parseACMEDNSCaddyfile)
+}
+
+// acmeDNSTXT is the format of the TXT values acme-dns accepts.
+var acmeDNSTXT = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+
+// ACMEDNS implements the register and update API of acme-dns on top of the
+// ipv64 account, so ACME clients that support acme-dns (routers, NAS boxes)
+// can do DNS-01 without the account token. Each registered account gets a
+// subdomain below Zone, to which the client's _acme-challenge name is CNAMEd;
+// like acme-dns, the two latest TXT values of a subdomain are kept.
+//
+//	acme.example.com {
+//	    ipv64_acmedns {
+//	        api_token {env.IPV64_API_TOKEN}
+//	        zone acme.example.ipv64.de
+//	        allow_registration
+//	    }
+//	}
+type ACMEDNS struct {
+	// Provider is the account that holds Zone; it takes the options of the
+	// ipv64 DNS provider, of which api_token is required.
+	Provider *Provider `json:"provider,omitempty"`
+
+	// Zone is the ipv64 domain below which the subdomains are created.
+	Zone string `json:"zone,omitempty"`
+
+	// AllowRegistration enables /register. Without it, only accounts
+	// registered earlier can update.
+	AllowRegistration bool `json:"allow_registration,omitempty"`
+
+	storage certmagic.Storage
+	logger  *zap.Logger
+	mu      *sync.Mutex // serializes updates of stored accounts
+}
+
+// acmeDNSAccount is a registered acme-dns account as kept in Caddy storage.
+type acmeDNSAccount struct {
+	Username     string   `json:"username"`
+	PasswordHash string   `json:"password_hash"` // hex SHA-256 of the password
+	Subdomain    string   `json:"subdomain"`
+	TXT          []string `json:"txt,omitempty"` // current values, oldest first
+}
+
+// CaddyModule returns the Caddy module information.
+func (ACMEDNS) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ipv64_acmedns",
+		New: func() caddy.Module { return new(ACMEDNS) },
+	}
+}
+
+// Provision sets up the account's provider.
+func (a *ACMEDNS) Provision(ctx caddy.Context) error {
+	a.logger = ctx.Logger(a)
+	a.storage = ctx.Storage()
+	a.mu = new(sync.Mutex)
+	if a.Zone == "" {
+		return fmt.Errorf("zone is required")
+	}
+	a.Zone = strings.ToLower(strings.TrimSuffix(a.Zone, "."))
+	if a.Provider == nil {
+		a.Provider = new(Provider)
+	}
+	if err := a.Provider.Provision(ctx); err != nil {
+		return err
+	}
+	return a.Provider.Validate()
+}
+
+// Cleanup releases the provider's resources.
+func (a *ACMEDNS) Cleanup() error {
+	if a.Provider == nil {
+		return nil
+	}
+	return a.Provider.Cleanup()
+}
+
+// ServeHTTP serves POST /register, POST /update and GET /health; other
+// requests are passed on.
+func (a *ACMEDNS) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/register"):
+		return a.serveRegister(w, r)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/update"):
+		return a.serveUpdate(w, r)
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/health"):
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	return next.ServeHTTP(w, r)
+}
+
+func (a *ACMEDNS) serveRegister(w http.ResponseWriter, r *http.Request) error {
+	if !a.AllowRegistration {
+		return writeACMEDNSError(w, http.StatusForbidden, "registration_disabled")
+	}
+	username, password, subdomain := randomToken(16), randomToken(20), randomToken(16)
+	sum := sha256.Sum256([]byte(password))
+	acct := acmeDNSAccount{Username: username, PasswordHash: hex.EncodeToString(sum[:]), Subdomain: subdomain}
+	if err := a.storeAccount(r, acct); err != nil {
+		a.logger.Error("ipv64 acme-dns: storing account failed", zap.Error(err))
+		return writeACMEDNSError(w, http.StatusInternalServerError, "db_error")
+	}
+	a.logger.Info("ipv64 acme-dns: account registered", zap.String("username", username), zap.String("subdomain", subdomain))
+	return writeACMEDNSJSON(w, http.StatusCreated, map[string]any{
+		"username":   username,
+		"password":   password,
+		"fulldomain": subdomain + "." + a.Zone,
+		"subdomain":  subdomain,
+		"allowfrom":  []string{},
+	})
+}
+
+func (a *ACMEDNS) serveUpdate(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		Subdomain string `json:"subdomain"`
+		TXT       string `json:"txt"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+		return writeACMEDNSError(w, http.StatusBadRequest, "malformed_json_payload")
+	}
+	if !acmeDNSTXT.MatchString(req.TXT) {
+		return writeACMEDNSError(w, http.StatusBadRequest, "bad_txt")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	acct, err := a.loadAccount(r, r.Header.Get("X-Api-User"))
+	if err != nil {
+		return writeACMEDNSError(w, http.StatusUnauthorized, "forbidden")
+	}
+	sum := sha256.Sum256([]byte(r.Header.Get("X-Api-Key")))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(acct.PasswordHash)) != 1 {
+		return writeACMEDNSError(w, http.StatusUnauthorized, "forbidden")
+	}
+	if req.Subdomain != acct.Subdomain {
+		return writeACMEDNSError(w, http.StatusUnauthorized, "forbidden")
+	}
+
+	zone := a.Zone + "."
+	rec := libdns.TXT{Name: acct.Subdomain, Text: req.TXT}
+	if _, err := a.Provider.AppendRecords(r.Context(), zone, []libdns.Record{rec}); err != nil {
+		a.logger.Error("ipv64 acme-dns: update failed", zap.String("subdomain", acct.Subdomain), zap.Error(err))
+		return writeACMEDNSError(w, http.StatusInternalServerError, "db_error")
+	}
+	acct.TXT = append(acct.TXT, req.TXT)
+	if len(acct.TXT) > 2 {
+		// like acme-dns, keep the two latest values for certificates with
+		// both a name and its wildcard
+		var stale []libdns.Record
+		for _, txt := range acct.TXT[:len(acct.TXT)-2] {
+			stale = append(stale, libdns.TXT{Name: acct.Subdomain, Text: txt})
+		}
+		if _, err := a.Provider.DeleteRecords(r.Context(), zone, stale); err != nil {
+			a.logger.Warn("ipv64 acme-dns: deleting old TXT records failed", zap.String("subdomain", acct.Subdomain), zap.Error(err))
+		}
+		acct.TXT = acct.TXT[len(acct.TXT)-2:]
+	}
+	if err := a.storeAccount(r, acct); err != nil {
+		a.logger.Error("ipv64 acme-dns: storing account failed", zap.Error(err))
+	}
+	return writeACMEDNSJSON(w, http.StatusOK, map[string]string{"txt": req.TXT})
+}
+
+func acmeDNSStorageKey(username string) string {
+	return path.Join("ipv64", "acmedns", username+".json")
+}
+
+func (a *ACMEDNS) loadAccount(r *http.Request, username string) (acmeDNSAccount, error) {
+	var acct acmeDNSAccount
+	if username == "" || strings.ContainsAny(username, "/\\.") {
+		return acct, fs.ErrNotExist
+	}
+	data, err := a.storage.Load(r.Context(), acmeDNSStorageKey(username))
+	if err != nil {
+		return acct, err
+	}
+	if err := json.Unmarshal(data, &acct); err != nil {
+		return acct, err
+	}
+	if acct.Username != username {
+		return acct, errors.New("account does not match its storage key")
+	}
+	return acct, nil
+}
+
+func (a *ACMEDNS) storeAccount(r *http.Request, acct acmeDNSAccount) error {
+	data, err := json.Marshal(acct)
+	if err != nil {
+		return err
+	}
+	return a.storage.Store(r.Context(), acmeDNSStorageKey(acct.Username), data)
+}
+
+// randomToken returns n random bytes as lowercase hex.
+func randomToken(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeACMEDNSJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+func writeACMEDNSError(w http.ResponseWriter, status int, msg string) error {
+	return writeACMEDNSJSON(w, status, map[string]string{"error": msg})
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens:
+//
+//	ipv64_acmedns {
+//	    zone <domain>
+//	    allow_registration
+//	    api_token <token>
+//	    provider {
+//	        <ipv64 DNS provider options>
+//	    }
+//	}
+func (a *ACMEDNS) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	a.Provider = new(Provider)
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "zone":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			a.Zone = d.Val()
+		case "allow_registration":
+			a.AllowRegistration = true
+		case "api_token":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			a.Provider.Token = d.Val()
+		case "provider":
+			if err := a.Provider.UnmarshalCaddyfile(d.NewFromNextSegment()); err != nil {
+				return err
+			}
+		default:
+			return d.Errf("unrecognized option: %s", d.Val())
+		}
+	}
+	return nil
+}
+
+func parseACMEDNSCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	a := new(ACMEDNS)
+	err := a.UnmarshalCaddyfile(h.Dispenser)
+	return a, err
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner           = (*ACMEDNS)(nil)
+	_ caddy.CleanerUpper          = (*ACMEDNS)(nil)
+	_ caddyhttp.MiddlewareHandler = (*ACMEDNS)(nil)
+	_ caddyfile.Unmarshaler       = (*ACMEDNS)(nil)
+)
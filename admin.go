@@ -0,0 +1,583 @@
+package caddyipv64
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/caddy/v2/modules/caddytls"
+	"go.uber.org/zap"
+
+	"github.com/Sickjuicy/caddy-ipv64/internal/ipv64api"
+	"github.com/Sickjuicy/caddy-ipv64/ipv64"
+)
+
+func init() {
+	caddy.RegisterModule(AdminAPI{})
+}
+
+// AdminAPI adds routes for debugging to Caddy's admin API:
+//
+// GET /ipv64/status reports the state of all ipv64 providers and DynDNS
+// updaters of the running config: maintenance backoff and token health,
+// cached domains, the latest API calls, challenge records awaiting deletion
+// and the last pushed addresses. This makes it possible to debug issuance
+// problems without debug logging.
+//
+// GET /ipv64/domains lists the domains of each provider's account and the
+// managed zone that each site of the config maps to. With ?refresh=1, the
+// domain list is fetched from the API; ?name=<host> (repeatable) maps
+// further names.
+//
+// /ipv64/records manages the records of an account domain, e.g. to remove
+// stray _acme-challenge records: GET ?domain=<domain> lists them, POST
+// creates the record of a JSON body {"domain", "prefix", "type", "content"},
+// and DELETE removes the record given by the same body or by {"domain",
+// "id"}. The request goes through the provider whose account has the domain,
+// and changes are written to its audit log.
+//
+// POST /ipv64/cache/flush drops the cached domain lists and records of all
+// providers, so that a domain just added to the account can be used without
+// restarting Caddy.
+//
+// Like all admin.api modules, it is loaded automatically.
+type AdminAPI struct{}
+
+// CaddyModule returns the Caddy module information.
+func (AdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.ipv64",
+		New: func() caddy.Module { return new(AdminAPI) },
+	}
+}
+
+// Routes returns the admin routes of the module.
+func (AdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/ipv64/status",
+			Handler: caddy.AdminHandlerFunc(handleStatus),
+		},
+		{
+			Pattern: "/ipv64/domains",
+			Handler: caddy.AdminHandlerFunc(handleDomains),
+		},
+		{
+			Pattern: "/ipv64/records",
+			Handler: caddy.AdminHandlerFunc(handleRecords),
+		},
+		{
+			Pattern: "/ipv64/cache/flush",
+			Handler: caddy.AdminHandlerFunc(handleFlush),
+		},
+	}
+}
+
+var _ caddy.AdminRouter = AdminAPI{}
+
+// live tracks the provisioned providers and DynDNS updaters, so the admin
+// API can report on them. Modules register when they are provisioned or
+// started and unregister when they are cleaned up or stopped.
+var live = struct {
+	mu        sync.Mutex
+	providers map[*Provider]struct{}
+	apps      map[*DynDNS]struct{}
+	modules   map[*AcmeIPv64Module]struct{}
+}{
+	providers: make(map[*Provider]struct{}),
+	apps:      make(map[*DynDNS]struct{}),
+	modules:   make(map[*AcmeIPv64Module]struct{}),
+}
+
+func trackLive[T comparable](set map[T]struct{}, v T, add bool) {
+	live.mu.Lock()
+	defer live.mu.Unlock()
+	if add {
+		set[v] = struct{}{}
+	} else {
+		delete(set, v)
+	}
+}
+
+// maxRecentCalls is how many API calls of a provider are kept for the admin API.
+const maxRecentCalls = 20
+
+// apiCall is the outcome of a single request to the ipv64 API.
+type apiCall struct {
+	Time     time.Time `json:"time"`
+	Endpoint string    `json:"endpoint"`
+	Status   int       `json:"status,omitempty"` // HTTP status, 0 on network errors
+	Error    string    `json:"error,omitempty"`
+	Duration string    `json:"duration"`
+}
+
+// apiCallLog keeps the latest API calls of a provider.
+type apiCallLog struct {
+	mu    sync.Mutex
+	calls []apiCall
+}
+
+func (l *apiCallLog) record(endpoint string, status int, err error, d time.Duration) {
+	if l == nil {
+		return
+	}
+	c := apiCall{Time: time.Now().UTC(), Endpoint: endpoint, Status: status, Duration: d.Round(time.Millisecond).String()}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, c)
+	if len(l.calls) > maxRecentCalls {
+		l.calls = l.calls[len(l.calls)-maxRecentCalls:]
+	}
+}
+
+// recent returns the latest calls, newest last.
+func (l *apiCallLog) recent() []apiCall {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]apiCall{}, l.calls...)
+}
+
+// Circuit states of a provider.
+const (
+	circuitClosed = "closed" // requests are sent
+	circuitOpen   = "open"   // requests wait for maintenance or a usable token
+)
+
+type circuitStatus struct {
+	State  string     `json:"state"`
+	Reason string     `json:"reason,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+type pendingStatus struct {
+	Zone    string    `json:"zone"`
+	Prefix  string    `json:"prefix"`
+	Type    string    `json:"type"`
+	Value   string    `json:"value"`
+	ID      int       `json:"id,omitempty"`
+	Refs    int       `json:"refs"`
+	Created time.Time `json:"created"`
+}
+
+type domainsStatus struct {
+	Cached     []string   `json:"cached"`
+	Fetched    *time.Time `json:"fetched,omitempty"`
+	Refreshing bool       `json:"refreshing,omitempty"` // the list is being fetched right now
+}
+
+type providerStatus struct {
+	Mode             string                 `json:"mode"`
+	Endpoint         string                 `json:"endpoint"`
+	Circuit          circuitStatus          `json:"circuit"`
+	Tokens           []ipv64api.TokenStatus `json:"tokens"`
+	Domains          domainsStatus          `json:"domains"`
+	RecentCalls      []apiCall              `json:"recent_calls"`
+	PendingDeletions []pendingStatus        `json:"pending_deletions"`
+}
+
+type dynDomainStatus struct {
+	Domain  string     `json:"domain"`
+	IP      string     `json:"ip,omitempty"`
+	Updated *time.Time `json:"updated,omitempty"`
+}
+
+type dynAppStatus struct {
+	Domains []dynDomainStatus `json:"domains"`
+	Halted  string            `json:"halted,omitempty"`
+	Leader  *bool             `json:"leader,omitempty"` // only with leader election
+}
+
+type adminStatus struct {
+	Providers []providerStatus `json:"providers"`
+	DynDNS    []dynAppStatus   `json:"dyndns"`
+	AcmeIPv64 []dynStatusDoc   `json:"acme_ipv64"`
+}
+
+// liveProviders returns the provisioned providers.
+func liveProviders() []*Provider {
+	live.mu.Lock()
+	defer live.mu.Unlock()
+	providers := make([]*Provider, 0, len(live.providers))
+	for p := range live.providers {
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+func requireGet(r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+	return nil
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) error {
+	if err := requireGet(r); err != nil {
+		return err
+	}
+	providers := liveProviders()
+	live.mu.Lock()
+	apps := make([]*DynDNS, 0, len(live.apps))
+	for a := range live.apps {
+		apps = append(apps, a)
+	}
+	modules := make([]*AcmeIPv64Module, 0, len(live.modules))
+	for m := range live.modules {
+		modules = append(modules, m)
+	}
+	live.mu.Unlock()
+
+	st := adminStatus{
+		Providers: make([]providerStatus, 0, len(providers)),
+		DynDNS:    make([]dynAppStatus, 0, len(apps)),
+		AcmeIPv64: make([]dynStatusDoc, 0, len(modules)),
+	}
+	for _, p := range providers {
+		st.Providers = append(st.Providers, p.status())
+	}
+	for _, a := range apps {
+		st.DynDNS = append(st.DynDNS, a.status())
+	}
+	for _, m := range modules {
+		st.AcmeIPv64 = append(st.AcmeIPv64, m.status.doc(m.Domain, m.health))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(st)
+}
+
+// status reports the state of the provider for the admin API.
+func (p *Provider) status() providerStatus {
+	s := providerStatus{
+		Mode:        p.Mode,
+		Endpoint:    p.Endpoint,
+		Circuit:     circuitStatus{State: circuitClosed},
+		Tokens:      p.tokens.Status(),
+		RecentCalls: p.calls.recent(),
+		Domains:     domainsStatus{Cached: []string{}},
+	}
+	p.maintenanceMu.Lock()
+	until := p.maintenanceUntil
+	p.maintenanceMu.Unlock()
+	if time.Now().Before(until) {
+		s.Circuit = circuitStatus{State: circuitOpen, Reason: "maintenance", Until: &until}
+	} else if len(s.Tokens) > 0 && countUsable(s.Tokens) == 0 {
+		s.Circuit = circuitStatus{State: circuitOpen, Reason: "all tokens revoked or rate limited"}
+	}
+
+	// domainsMu is held while the list is fetched; don't wait for the API
+	if p.domainsMu.TryLock() {
+		if p.cachedDomains != nil {
+			s.Domains.Cached = p.cachedDomains
+		}
+		if p.domainsCached {
+			t := p.domainsFetched
+			s.Domains.Fetched = &t
+		}
+		p.domainsMu.Unlock()
+	} else {
+		s.Domains.Refreshing = true
+	}
+
+	s.PendingDeletions = []pendingStatus{}
+	for _, rec := range p.pending.List() {
+		s.PendingDeletions = append(s.PendingDeletions, pendingStatus{
+			Zone:    rec.Managed,
+			Prefix:  rec.Prefix,
+			Type:    rec.Type,
+			Value:   rec.Value,
+			ID:      rec.ID,
+			Refs:    rec.Refs,
+			Created: rec.Created,
+		})
+	}
+	return s
+}
+
+// countUsable returns how many tokens are neither revoked nor blocked.
+func countUsable(tokens []ipv64api.TokenStatus) int {
+	var n int
+	for _, t := range tokens {
+		if !t.Revoked && t.BlockedUntil == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// status reports the state of the DynDNS app for the admin API.
+func (a *DynDNS) status() dynAppStatus {
+	s := dynAppStatus{Domains: make([]dynDomainStatus, 0, len(a.Domains))}
+	last := a.ips.snapshot()
+	for _, domain := range a.Domains {
+		ds := dynDomainStatus{Domain: domain}
+		if st, ok := last[domain]; ok {
+			ds.IP = st.IP
+			t := st.Updated
+			ds.Updated = &t
+		}
+		s.Domains = append(s.Domains, ds)
+	}
+	if status, halted := a.health.degraded(); halted {
+		s.Halted = string(status)
+	}
+	if a.lease != nil {
+		leader := a.lease.isLeader()
+		s.Leader = &leader
+	}
+	return s
+}
+
+type siteZone struct {
+	Site  string `json:"site"`
+	Zone  string `json:"zone,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type providerDomains struct {
+	Token   string     `json:"token,omitempty"` // masked
+	Domains []string   `json:"domains"`
+	Fetched *time.Time `json:"fetched,omitempty"`
+	Error   string     `json:"error,omitempty"`
+	Sites   []siteZone `json:"sites"`
+}
+
+func handleDomains(w http.ResponseWriter, r *http.Request) error {
+	if err := requireGet(r); err != nil {
+		return err
+	}
+	sites := configuredSites()
+	for _, name := range r.URL.Query()["name"] {
+		if name = strings.ToLower(strings.TrimSuffix(name, ".")); name != "" && !slices.Contains(sites, name) {
+			sites = append(sites, name)
+		}
+	}
+	refresh := r.URL.Query().Get("refresh") == "1"
+
+	list := []providerDomains{}
+	for _, p := range liveProviders() {
+		list = append(list, p.domainMapping(r.Context(), sites, refresh))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(list)
+}
+
+// domainMapping returns the account domains of the provider and the managed
+// zone of each site, as managedZone derives it for the site's challenge record.
+func (p *Provider) domainMapping(ctx context.Context, sites []string, refresh bool) providerDomains {
+	pd := providerDomains{Domains: []string{}, Sites: make([]siteZone, 0, len(sites))}
+	if p.Token != "" {
+		pd.Token = ipv64api.MaskSecret(p.Token)
+	}
+	var domains []string
+	var err error
+	if p.Mode == modeLive {
+		if refresh {
+			domains, err = p.RefreshDomains(ctx)
+		} else {
+			domains, err = p.domains(ctx)
+		}
+	}
+	if err != nil {
+		pd.Error = err.Error()
+	}
+	if domains != nil {
+		pd.Domains = domains
+	}
+	p.domainsMu.Lock()
+	if p.domainsCached {
+		t := p.domainsFetched
+		pd.Fetched = &t
+	}
+	p.domainsMu.Unlock()
+
+	for _, site := range sites {
+		fqdn := "_acme-challenge." + strings.TrimPrefix(site, "*.") + "."
+		sz := siteZone{Site: site}
+		if zone, err := p.managedZone(ctx, fqdn, ""); err != nil {
+			sz.Error = err.Error()
+		} else {
+			sz.Zone = zone
+		}
+		pd.Sites = append(pd.Sites, sz)
+	}
+	return pd
+}
+
+// configuredSites returns the host names of the running config: the host
+// matchers of the top-level HTTP routes, which is where Caddyfile site
+// addresses end up, and the subjects of TLS automation policies.
+func configuredSites() []string {
+	ctx := caddy.ActiveContext()
+	var sites []string
+	add := func(host string) {
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		if host == "" || strings.Contains(host, "{") || slices.Contains(sites, host) {
+			return
+		}
+		sites = append(sites, host)
+	}
+	if app, err := ctx.AppIfConfigured("http"); err == nil {
+		for _, srv := range app.(*caddyhttp.App).Servers {
+			for _, route := range srv.Routes {
+				for _, set := range route.MatcherSets {
+					for _, m := range set {
+						if hosts, ok := m.(*caddyhttp.MatchHost); ok {
+							for _, h := range *hosts {
+								add(h)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	if app, err := ctx.AppIfConfigured("tls"); err == nil {
+		if tlsApp := app.(*caddytls.TLS); tlsApp.Automation != nil {
+			for _, ap := range tlsApp.Automation.Policies {
+				for _, s := range ap.SubjectsRaw {
+					add(s)
+				}
+			}
+		}
+	}
+	slices.Sort(sites)
+	return sites
+}
+
+// adminRecord is the body of record changes through the admin API.
+type adminRecord struct {
+	Domain  string `json:"domain"`
+	Prefix  string `json:"prefix,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Content string `json:"content,omitempty"`
+	ID      int    `json:"id,omitempty"`
+}
+
+func handleRecords(w http.ResponseWriter, r *http.Request) error {
+	var rec adminRecord
+	switch r.Method {
+	case http.MethodGet:
+		rec.Domain = r.URL.Query().Get("domain")
+	case http.MethodPost, http.MethodDelete:
+		if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("decoding record: %v", err)}
+		}
+	default:
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method not allowed")}
+	}
+	rec.Domain = strings.ToLower(strings.TrimSuffix(rec.Domain, "."))
+	if rec.Domain == "" {
+		return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("domain is required")}
+	}
+	p, err := providerFor(r.Context(), rec.Domain)
+	if err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusNotFound, Err: err}
+	}
+
+	if r.Method != http.MethodGet && rec.Prefix != "" {
+		if err := p.checkScope(prefixedName(rec.Prefix, rec.Domain)); err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusForbidden, Err: err}
+		}
+	}
+
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		records, err := p.api.ListRecords(ctx, rec.Domain)
+		if err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadGateway, Err: err}
+		}
+		if records == nil {
+			records = []ipv64.Record{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(records)
+	case http.MethodPost:
+		if rec.Prefix == "" || rec.Type == "" || rec.Content == "" {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("prefix, type and content are required")}
+		}
+		err = p.api.AddRecord(ctx, rec.Domain, rec.Prefix, strings.ToUpper(rec.Type), rec.Content)
+		p.audit.record("admin_api", "add", rec.Domain, rec.Prefix, strings.ToUpper(rec.Type), rec.Content, err)
+	case http.MethodDelete:
+		switch {
+		case rec.ID > 0:
+			err = p.api.DelRecordByID(ctx, rec.Domain, rec.ID)
+			p.audit.record("admin_api", "delete", rec.Domain, rec.Prefix, rec.Type, strconv.Itoa(rec.ID), err)
+		case rec.Prefix != "" && rec.Type != "" && rec.Content != "":
+			err = p.api.DelRecord(ctx, rec.Domain, rec.Prefix, strings.ToUpper(rec.Type), rec.Content)
+			p.audit.record("admin_api", "delete", rec.Domain, rec.Prefix, strings.ToUpper(rec.Type), rec.Content, err)
+		default:
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("id, or prefix, type and content are required")}
+		}
+	}
+	p.records.invalidate(rec.Domain)
+	if err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusBadGateway, Err: err}
+	}
+	if p.logger != nil {
+		p.logger.Info("ipv64: record changed through the admin API",
+			zap.String("method", r.Method),
+			zap.String("domain", rec.Domain),
+			zap.String("prefix", rec.Prefix),
+			zap.String("type", rec.Type))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// providerFor returns the provider whose account has domain and whose scope
+// includes it. Providers in mock mode accept any domain in scope.
+func providerFor(ctx context.Context, domain string) (*Provider, error) {
+	for _, p := range liveProviders() {
+		if p.checkZoneScope(domain) != nil {
+			continue
+		}
+		if p.Mode == modeMock {
+			return p, nil
+		}
+		domains, err := p.domains(ctx)
+		if err == nil && slices.Contains(domains, domain) {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("no ipv64 provider manages the domain %s", domain)
+}
+
+func handleFlush(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method not allowed")}
+	}
+	var errs []error
+	for _, p := range liveProviders() {
+		p.records.flush()
+		if err := p.flushDomains(r.Context()); err != nil {
+			errs = append(errs, err)
+		}
+		if p.logger != nil {
+			p.logger.Info("ipv64: caches flushed through the admin API")
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: fmt.Errorf("removing stored domain list: %v", err)}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,55 @@
+package caddyipv64
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// operationIDKey is the context key of the ID that correlates the API
+// calls of one provider operation in the debug_api logs.
+type operationIDKey struct{}
+
+// debugContext returns ctx with a new operation ID if DebugAPI is enabled
+// and ctx doesn't carry one yet.
+func (p *Provider) debugContext(ctx context.Context) context.Context {
+	if !p.DebugAPI || operationID(ctx) != "" {
+		return ctx
+	}
+	var b [6]byte
+	_, _ = rand.Read(b[:])
+	return context.WithValue(ctx, operationIDKey{}, hex.EncodeToString(b[:]))
+}
+
+// operationID returns the operation ID of ctx, if any.
+func operationID(ctx context.Context) string {
+	id, _ := ctx.Value(operationIDKey{}).(string)
+	return id
+}
+
+// debugAPICall logs one attempt of an API call with its form parameters
+// and response at debug level if DebugAPI is enabled. Tokens are masked by
+// the provider's logger.
+func (p *Provider) debugAPICall(ctx context.Context, method, apiURL string, form url.Values, attempt, status int, body []byte, took time.Duration, err error) {
+	if !p.DebugAPI || p.logger == nil {
+		return
+	}
+	fields := []zap.Field{
+		zap.String("operation_id", operationID(ctx)),
+		zap.String("method", method),
+		zap.String("url", apiURL),
+		zap.String("form", form.Encode()),
+		zap.Int("attempt", attempt),
+		zap.Duration("duration", took),
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	} else {
+		fields = append(fields, zap.Int("status", status), zap.ByteString("response", body))
+	}
+	p.logger.Debug("ipv64: API call", fields...)
+}
@@ -0,0 +1,147 @@
+package caddyipv64
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/Sickjuicy/caddy-ipv64/internal/ipv64api"
+)
+
+// AuditLog configures an append-only JSONL file that records every record
+// mutation, independent of Caddy's regular logs.
+type AuditLog struct {
+	// Path of the audit file. Required.
+	Path string `json:"path,omitempty"`
+
+	// Rotation settings; rotation is enabled by default (100 MB, 10 files).
+	RollDisabled bool `json:"roll_disabled,omitempty"`
+	RollSizeMB   int  `json:"roll_size_mb,omitempty"`
+	RollKeep     int  `json:"roll_keep,omitempty"`
+	RollKeepDays int  `json:"roll_keep_days,omitempty"`
+}
+
+// auditEntry is a single line of the audit file.
+type auditEntry struct {
+	Time      time.Time `json:"ts"`
+	Subsystem string    `json:"subsystem"`
+	Action    string    `json:"action"`
+	Zone      string    `json:"zone"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Value     string    `json:"value,omitempty"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// auditLogger writes audit entries; a nil *auditLogger discards everything.
+type auditLogger struct {
+	mu     sync.Mutex
+	out    *lumberjack.Logger
+	redact *ipv64api.Redactor // masks tokens in error messages
+}
+
+func (a *AuditLog) open() (*auditLogger, error) {
+	if a.Path == "" {
+		return nil, fmt.Errorf("audit_log: path is required")
+	}
+	out := &lumberjack.Logger{Filename: a.Path}
+	if a.RollDisabled {
+		// lumberjack always rotates; make the threshold unreachable
+		out.MaxSize = 1 << 30
+	} else {
+		out.MaxSize = a.RollSizeMB
+		if out.MaxSize <= 0 {
+			out.MaxSize = 100
+		}
+		out.MaxBackups = a.RollKeep
+		if out.MaxBackups <= 0 {
+			out.MaxBackups = 10
+		}
+		out.MaxAge = a.RollKeepDays
+	}
+	return &auditLogger{out: out}, nil
+}
+
+// record appends an entry for a mutation; err decides the result field.
+func (l *auditLogger) record(subsystem, action, zone, name, rtype, value string, err error) {
+	if l == nil {
+		return
+	}
+	e := auditEntry{
+		Time:      time.Now().UTC(),
+		Subsystem: subsystem,
+		Action:    action,
+		Zone:      zone,
+		Name:      name,
+		Type:      rtype,
+		Value:     value,
+		Result:    "ok",
+	}
+	if err != nil {
+		e.Result = "error"
+		e.Error = l.redact.String(err.Error())
+	}
+	line, mErr := json.Marshal(e)
+	if mErr != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.out.Write(append(line, '\n'))
+}
+
+func (l *auditLogger) close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.out.Close()
+}
+
+// unmarshalAuditLog parses:
+//
+//	audit_log <path> {
+//	    roll_disabled
+//	    roll_size_mb <n>
+//	    roll_keep <n>
+//	    roll_keep_days <n>
+//	}
+func unmarshalAuditLog(d *caddyfile.Dispenser) (*AuditLog, error) {
+	a := new(AuditLog)
+	if !d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	a.Path = d.Val()
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "roll_disabled":
+			a.RollDisabled = true
+		case "roll_size_mb", "roll_keep", "roll_keep_days":
+			opt := d.Val()
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			var v int
+			if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+				return nil, d.Errf("invalid %s: %s", opt, d.Val())
+			}
+			switch opt {
+			case "roll_size_mb":
+				a.RollSizeMB = v
+			case "roll_keep":
+				a.RollKeep = v
+			case "roll_keep_days":
+				a.RollKeepDays = v
+			}
+		default:
+			return nil, d.Errf("unrecognized audit_log option: %s", d.Val())
+		}
+	}
+	return a, nil
+}
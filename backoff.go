@@ -0,0 +1,150 @@
+package caddyipv64
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Retry jitter strategies.
+const (
+	jitterOff          = "off"
+	jitterFull         = "full"
+	jitterDecorrelated = "decorrelated"
+)
+
+// retryBackoff computes the sleeps between the attempts of one API operation.
+// Jitter spreads out retries of concurrent operations so they don't hit the
+// API in lockstep after an outage.
+type retryBackoff struct {
+	base     time.Duration
+	max      time.Duration // upper bound of a single sleep
+	cur      time.Duration // next sleep without jitter, doubled after each retry
+	prev     time.Duration // last sleep, for decorrelated jitter
+	jitter   string
+	deadline time.Time     // no retries are started after this; zero means none
+	floor    time.Duration // minimum of the next sleep, e.g. from Retry-After
+}
+
+func newRetryBackoff(base, limit time.Duration, jitter string, budget time.Duration) *retryBackoff {
+	b := &retryBackoff{base: base, max: limit, cur: base, prev: base, jitter: jitter}
+	if budget > 0 {
+		b.deadline = time.Now().Add(budget)
+	}
+	return b
+}
+
+// next returns how long to sleep before the next attempt.
+func (b *retryBackoff) next() time.Duration {
+	var d time.Duration
+	switch b.jitter {
+	case jitterFull:
+		// uniformly in [0, cur]
+		d = rand.N(b.cur + 1)
+		b.cur = min(2*b.cur, b.max)
+	case jitterDecorrelated:
+		// uniformly in [base, 3*prev], capped at max
+		d = min(b.base+rand.N(3*b.prev-b.base+1), b.max)
+		b.prev = d
+	default:
+		d = b.cur
+		b.cur = min(2*b.cur, b.max)
+	}
+	if b.floor > 0 {
+		d = max(d, b.floor)
+		// the server is slower than our backoff assumed; don't go below it again
+		b.cur = max(b.cur, min(b.floor, b.max))
+		b.prev = max(b.prev, min(b.floor, b.max))
+		b.floor = 0
+	}
+	return d
+}
+
+// atLeast makes the next sleep last at least d, as requested by the server.
+func (b *retryBackoff) atLeast(d time.Duration) {
+	b.floor = max(b.floor, d)
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date, into the time to wait from now.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
+}
+
+// retryAfter returns the Retry-After of resp, capped at MaxRetryAfter.
+func (p *Provider) retryAfter(resp *http.Response) (time.Duration, bool) {
+	d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return 0, false
+	}
+	return min(d, time.Duration(p.MaxRetryAfter)), true
+}
+
+// exhausted reports whether sleeping for d would end past the retry deadline.
+func (b *retryBackoff) exhausted(d time.Duration) bool {
+	return !b.deadline.IsZero() && time.Now().Add(d).After(b.deadline)
+}
+
+// sleepContext sleeps for d unless ctx is done first, so cancelled
+// operations stop retrying right away.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isRetryableNetError reports whether err from the HTTP client is a transient
+// network failure worth retrying: a timeout, a refused or reset connection,
+// a temporary DNS failure or a connection closed mid-response. Nothing is
+// retryable once ctx itself is done.
+func isRetryableNetError(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true // client timeout
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "read" || opErr.Op == "write") {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
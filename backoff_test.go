@@ -0,0 +1,194 @@
+package caddyipv64
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 5 ", 5 * time.Second, true},
+		{"0", 0, true},
+		{"-5", 0, false},
+		{"", 0, false},
+		{"soon", 0, false},
+		{"Fri, 16 Oct 2026 12:01:30 GMT", 90 * time.Second, true},
+		{"Fri, 16 Oct 2026 11:59:00 GMT", 0, true},
+		{"Friday, 16-Oct-26 12:00:10 GMT", 10 * time.Second, true},
+	} {
+		got, ok := parseRetryAfter(tc.value, now)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tc.value, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestRetryAfterCapped(t *testing.T) {
+	p := &Provider{MaxRetryAfter: caddy.Duration(time.Minute)}
+	for _, tc := range []struct {
+		header string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"30", 30 * time.Second, true},
+		{"3600", time.Minute, true},
+		{"", 0, false},
+	} {
+		resp := &http.Response{Header: http.Header{}}
+		if tc.header != "" {
+			resp.Header.Set("Retry-After", tc.header)
+		}
+		got, ok := p.retryAfter(resp)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("retryAfter(%q) = %v, %v; want %v, %v", tc.header, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+// timeoutError is a net.Error that timed out, like the error of a deadline
+// set on a connection.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryableNetError(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Post", URL: "https://ipv64.net/api", Err: err}
+	}
+	opErr := func(op string, errno syscall.Errno) error {
+		return &net.OpError{Op: op, Net: "tcp", Err: os.NewSyscallError("syscall", errno)}
+	}
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"client timeout", urlErr(context.DeadlineExceeded), true},
+		{"wrapped client timeout", fmt.Errorf("list_records: %w", urlErr(context.DeadlineExceeded)), true},
+		{"connection timeout", urlErr(&net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}), true},
+		{"connection refused", urlErr(opErr("dial", syscall.ECONNREFUSED)), true},
+		{"connection reset", urlErr(opErr("read", syscall.ECONNRESET)), true},
+		{"broken pipe", urlErr(opErr("write", syscall.EPIPE)), true},
+		{"temporary DNS failure", urlErr(&net.DNSError{Err: "server misbehaving", Name: "ipv64.net", IsTemporary: true}), true},
+		{"DNS timeout", urlErr(&net.DNSError{Err: "i/o timeout", Name: "ipv64.net", IsTimeout: true}), true},
+		{"unknown host", urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "ipv64.invalid", IsNotFound: true}}), false},
+		{"connection closed mid-response", urlErr(io.ErrUnexpectedEOF), true},
+		{"server closed idle connection", urlErr(io.EOF), true},
+		{"canceled request", urlErr(context.Canceled), false},
+		{"TLS failure", urlErr(errors.New("tls: failed to verify certificate: x509: certificate signed by unknown authority")), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isRetryableNetError(context.Background(), tc.err); got != tc.want {
+				t.Errorf("isRetryableNetError(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIsRetryableNetErrorContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if isRetryableNetError(ctx, &url.Error{Op: "Get", URL: "https://ipv64.net/api", Err: context.DeadlineExceeded}) {
+		t.Error("errors after the operation was canceled must not be retried")
+	}
+}
+
+func TestIsRetryableNetErrorRealDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	_, err = http.Get("http://" + addr)
+	if err == nil {
+		t.Skip("closed port accepted a connection")
+	}
+	if !isRetryableNetError(context.Background(), err) {
+		t.Errorf("refused connection %v is not retryable", err)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	const base, limit = 100 * time.Millisecond, time.Second
+
+	t.Run("exponential growth and cap", func(t *testing.T) {
+		b := newRetryBackoff(base, limit, jitterOff, 0)
+		for i, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+			if got := b.next(); got != want*time.Millisecond {
+				t.Errorf("sleep %d = %v, want %v", i+1, got, want*time.Millisecond)
+			}
+		}
+	})
+
+	t.Run("full jitter bounds", func(t *testing.T) {
+		for range 200 {
+			b := newRetryBackoff(base, limit, jitterFull, 0)
+			bound := base
+			for i := range 8 {
+				if got := b.next(); got < 0 || got > bound {
+					t.Fatalf("sleep %d = %v, want within [0, %v]", i+1, got, bound)
+				}
+				bound = min(2*bound, limit)
+			}
+		}
+	})
+
+	t.Run("decorrelated jitter bounds", func(t *testing.T) {
+		for range 200 {
+			b := newRetryBackoff(base, limit, jitterDecorrelated, 0)
+			prev := base
+			for i := range 8 {
+				got := b.next()
+				if got < base || got > min(3*prev, limit) {
+					t.Fatalf("sleep %d = %v, want within [%v, %v]", i+1, got, base, min(3*prev, limit))
+				}
+				prev = got
+			}
+		}
+	})
+
+	t.Run("server floor", func(t *testing.T) {
+		b := newRetryBackoff(base, limit, jitterOff, 0)
+		b.atLeast(500 * time.Millisecond)
+		if got := b.next(); got != 500*time.Millisecond {
+			t.Errorf("sleep with floor = %v, want 500ms", got)
+		}
+		if got := b.next(); got < 500*time.Millisecond {
+			t.Errorf("sleep after floor = %v, want at least 500ms", got)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		if newRetryBackoff(base, limit, jitterOff, 0).exhausted(time.Hour) {
+			t.Error("backoff without budget is exhausted")
+		}
+		b := newRetryBackoff(base, limit, jitterOff, time.Second)
+		if b.exhausted(100 * time.Millisecond) {
+			t.Error("short sleep within the budget is exhausted")
+		}
+		if !b.exhausted(2 * time.Second) {
+			t.Error("sleep past the budget is not exhausted")
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package caddyipv64
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/libdns/libdns"
+	"go.uber.org/zap"
+
+	"github.com/Sickjuicy/caddy-ipv64/ipv64"
+)
+
+// CAAConfig makes the provider create CAA records at the apex of every
+// managed zone it touches, so that only the configured CA may issue.
+type CAAConfig struct {
+	// Issuers are the CA domains allowed to issue (default: letsencrypt.org).
+	Issuers []string `json:"issuers,omitempty"`
+
+	// AccountURI optionally binds issuance to a single ACME account (RFC 8657).
+	AccountURI string `json:"account_uri,omitempty"`
+
+	// IssueWild also restricts wildcard issuance via "issuewild" records.
+	IssueWild bool `json:"issue_wild,omitempty"`
+
+	// IODEF is an optional mailto: or https: URL for violation reports.
+	IODEF string `json:"iodef,omitempty"`
+}
+
+// caaState remembers which zones already had their CAA records checked in this process.
+type caaState struct {
+	mu      sync.Mutex
+	ensured map[string]bool
+}
+
+// records returns the CAA record contents to maintain.
+func (c *CAAConfig) records() []string {
+	issuers := c.Issuers
+	if len(issuers) == 0 {
+		issuers = []string{"letsencrypt.org"}
+	}
+	var recs []string
+	tags := []string{"issue"}
+	if c.IssueWild {
+		tags = append(tags, "issuewild")
+	}
+	for _, tag := range tags {
+		for _, issuer := range issuers {
+			value := issuer
+			if c.AccountURI != "" {
+				value += "; accounturi=" + c.AccountURI
+			}
+			recs = append(recs, fmt.Sprintf("0 %s %q", tag, value))
+		}
+	}
+	if c.IODEF != "" {
+		recs = append(recs, fmt.Sprintf("0 iodef %q", c.IODEF))
+	}
+	return recs
+}
+
+// ensureCAA brings the CAA records at the apex of a managed zone in line with
+// the configuration once per process: missing records are added, outdated
+// and duplicate ones deleted. Failures are logged only.
+func (p *Provider) ensureCAA(ctx context.Context, managed string) {
+	if p.CAA == nil {
+		return
+	}
+	key := strings.ToLower(strings.TrimSuffix(managed, "."))
+	p.caa.mu.Lock()
+	done := p.caa.ensured[key]
+	p.caa.ensured[key] = true
+	p.caa.mu.Unlock()
+	if done {
+		return
+	}
+
+	p.records.invalidate(managed)
+	existing, err := p.listRecords(ctx, managed)
+	if err != nil {
+		if p.logger != nil {
+			p.logger.Warn("ipv64: could not list CAA records", zap.String("zone", managed), zap.Error(err))
+		}
+		// try again with the next record change
+		p.caa.mu.Lock()
+		delete(p.caa.ensured, key)
+		p.caa.mu.Unlock()
+		return
+	}
+	want := p.CAA.records()
+	have := make(map[string]bool)
+	for _, rec := range existing {
+		if !ipv64.Matches(rec, "@", libdns.RR{Type: "CAA"}) {
+			continue
+		}
+		k := caaKey(rec.Content)
+		if !have[k] && slices.ContainsFunc(want, func(c string) bool { return caaKey(c) == k }) {
+			have[k] = true
+			continue
+		}
+		if rec.ID != 0 {
+			err = p.api.DelRecordByID(ctx, managed, rec.ID)
+		} else {
+			err = p.api.DelRecord(ctx, managed, "@", "CAA", rec.Content)
+		}
+		p.audit.record("caa", "delete", managed, "@", "CAA", rec.Content, err)
+		if p.logger != nil {
+			if err != nil {
+				p.logger.Warn("ipv64: could not delete outdated CAA record",
+					zap.String("zone", managed), zap.String("content", rec.Content), zap.Error(err))
+			} else {
+				p.logger.Info("ipv64: deleted outdated CAA record", zap.String("zone", managed), zap.String("content", rec.Content))
+			}
+		}
+	}
+
+	for _, content := range want {
+		if have[caaKey(content)] {
+			continue
+		}
+		err := p.api.AddRecord(ctx, managed, "@", "CAA", content)
+		p.audit.record("caa", "add", managed, "@", "CAA", content, err)
+		if err != nil {
+			if p.logger != nil {
+				p.logger.Warn("ipv64: could not create CAA record",
+					zap.String("zone", managed),
+					zap.String("content", content),
+					zap.Error(err))
+			}
+			continue
+		}
+		if p.logger != nil {
+			p.logger.Info("ipv64: created CAA record", zap.String("zone", managed), zap.String("content", content))
+		}
+	}
+	p.records.invalidate(managed)
+}
+
+// caaKey normalizes CAA record content for comparison, since the API may
+// return it with different quoting or tag case.
+func caaKey(content string) string {
+	f := strings.Fields(content)
+	if len(f) < 3 {
+		return content
+	}
+	return f[0] + " " + strings.ToLower(f[1]) + " " + strings.Trim(strings.Join(f[2:], " "), `"`)
+}
+
+// unmarshalCAA parses:
+//
+//	caa [<issuer...>] {
+//	    account_uri <uri>
+//	    issue_wild
+//	    iodef <url>
+//	}
+func unmarshalCAA(d *caddyfile.Dispenser) (*CAAConfig, error) {
+	c := &CAAConfig{Issuers: d.RemainingArgs()}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "account_uri":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			c.AccountURI = d.Val()
+		case "issue_wild":
+			c.IssueWild = true
+		case "iodef":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			c.IODEF = d.Val()
+		default:
+			return nil, d.Errf("unrecognized caa option: %s", d.Val())
+		}
+	}
+	return c, nil
+}
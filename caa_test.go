@@ -0,0 +1,72 @@
+package caddyipv64
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/Sickjuicy/caddy-ipv64/internal/ipv64api"
+)
+
+func TestEnsureCAAIdempotent(t *testing.T) {
+	ctx := context.Background()
+	const zone = "example.ipv64.de"
+	srv := newTestFakeServer(t, new(FakeServer), zone)
+	client := &ipv64api.Client{Endpoint: srv.URL, APIPath: "/"}
+
+	// each provider stands for a provisioning after a reload or restart
+	ensure := func(cfg *CAAConfig) []string {
+		t.Helper()
+		p := &Provider{
+			CAA:     cfg,
+			caa:     &caaState{ensured: make(map[string]bool)},
+			records: &recordsCache{entries: make(map[string]recordsCacheEntry)},
+		}
+		p.SetAPIClient(client)
+		p.ensureCAA(ctx, zone)
+		recs, err := client.ListRecords(ctx, zone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var caa []string
+		for _, rec := range recs {
+			if rec.Type == "CAA" {
+				caa = append(caa, rec.Content)
+			}
+		}
+		slices.Sort(caa)
+		return caa
+	}
+
+	cfg := &CAAConfig{IssueWild: true}
+	want := []string{`0 issue "letsencrypt.org"`, `0 issuewild "letsencrypt.org"`}
+	for i := range 2 {
+		if got := ensure(cfg); !slices.Equal(got, want) {
+			t.Fatalf("provisioning %d: CAA records %q, want %q", i+1, got, want)
+		}
+	}
+
+	// a changed configuration replaces the outdated records
+	got := ensure(&CAAConfig{Issuers: []string{"sectigo.com"}})
+	if want := []string{`0 issue "sectigo.com"`}; !slices.Equal(got, want) {
+		t.Fatalf("after config change: CAA records %q, want %q", got, want)
+	}
+
+	// duplicates left behind by earlier versions are removed
+	if err := client.AddRecord(ctx, zone, "@", "CAA", `0 issue "sectigo.com"`); err != nil {
+		t.Fatal(err)
+	}
+	got = ensure(&CAAConfig{Issuers: []string{"sectigo.com"}})
+	if want := []string{`0 issue "sectigo.com"`}; !slices.Equal(got, want) {
+		t.Fatalf("with duplicate: CAA records %q, want %q", got, want)
+	}
+}
+
+func TestCAAKey(t *testing.T) {
+	if caaKey(`0 issue "letsencrypt.org"`) != caaKey(`0 ISSUE letsencrypt.org`) {
+		t.Error("quoting and tag case must not matter")
+	}
+	if caaKey(`0 issue "letsencrypt.org"`) == caaKey(`0 issuewild "letsencrypt.org"`) {
+		t.Error("tags must be told apart")
+	}
+}
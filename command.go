@@ -0,0 +1,848 @@
+package caddyipv64
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+
+	"github.com/Sickjuicy/caddy-ipv64/internal/ipv64api"
+	"github.com/Sickjuicy/caddy-ipv64/ipv64"
+)
+
+func init() {
+	caddycmd.RegisterCommand(caddycmd.Command{
+		Name:  "ipv64",
+		Usage: "<command> [--token <token>] [--endpoint <url>]",
+		Short: "Manages ipv64.net domains and records",
+		Long: `
+Talks to the ipv64.net API directly, without a running Caddy, e.g. to verify
+a token before writing the config.
+
+The API token is taken from --token or the IPV64_API_TOKEN environment
+variable. Record changes are appended to the audit file given with
+--audit-log or IPV64_AUDIT_LOG, with subsystem "cli".
+`,
+		CobraFunc: func(cmd *cobra.Command) {
+			cmd.PersistentFlags().String("token", "", "ipv64 API token (default $IPV64_API_TOKEN)")
+			cmd.PersistentFlags().String("endpoint", "", "Base URL of the ipv64 API")
+			cmd.PersistentFlags().Int("retries", 3, "How often failed requests are retried")
+			cmd.PersistentFlags().String("audit-log", "", "Audit file that record changes are appended to (default $IPV64_AUDIT_LOG)")
+			cmd.AddCommand(&cobra.Command{
+				Use:   "list-domains",
+				Short: "Lists the domains of the account with their record counts",
+				Args:  cobra.NoArgs,
+				RunE:  cmdListDomains,
+			})
+			records := &cobra.Command{
+				Use:   "records <domain>",
+				Short: "Lists the records of a domain",
+				Args:  cobra.ExactArgs(1),
+				RunE:  cmdRecords,
+			}
+			records.Flags().Bool("json", false, "Print the records as JSON")
+			cmd.AddCommand(records)
+			cmd.AddCommand(&cobra.Command{
+				Use:   "add-record <domain> <prefix> <type> <content>",
+				Short: "Adds a record to a domain",
+				Args:  cobra.ExactArgs(4),
+				RunE:  cmdAddRecord,
+			})
+			delRecord := &cobra.Command{
+				Use:   "del-record <domain> [<prefix> <type> <content>]",
+				Short: "Deletes a record of a domain, by value or by --id",
+				Args:  cobra.MatchAll(cobra.MinimumNArgs(1), cobra.MaximumNArgs(4)),
+				RunE:  cmdDelRecord,
+			}
+			delRecord.Flags().Int("id", 0, "ID of the record to delete, as listed by the records command")
+			cmd.AddCommand(delRecord)
+			checkPropagation := &cobra.Command{
+				Use:   "check-propagation <name> <value>",
+				Short: "Polls resolvers and the ipv64 nameservers for a TXT record",
+				Long: `
+Polls the resolvers recursively and the authoritative ipv64 nameservers
+directly until all of them serve the TXT value at name, or the timeout
+passes, and reports when each server first returned it.
+`,
+				Args: cobra.ExactArgs(2),
+				RunE: cmdCheckPropagation,
+			}
+			addPropagationFlags(checkPropagation)
+			cmd.AddCommand(checkPropagation)
+			cleanup := &cobra.Command{
+				Use:   "cleanup-challenges [<domain>]",
+				Short: "Deletes stale _acme-challenge TXT records",
+				Long: `
+Deletes the _acme-challenge TXT records that were last updated before
+--older-than, e.g. leftovers of failed renewals. Without a domain, all
+domains of the account are scanned. Records without a valid update time are
+kept.
+`,
+				Args: cobra.MaximumNArgs(1),
+				RunE: cmdCleanupChallenges,
+			}
+			cleanup.Flags().Duration("older-than", 24*time.Hour, "Minimum age of the records to delete")
+			cleanup.Flags().Bool("dry-run", false, "Only list the records that would be deleted")
+			cmd.AddCommand(cleanup)
+			dynUpdate := &cobra.Command{
+				Use:   "dyndns-update <domain>",
+				Short: "Sends a single DynDNS update",
+				Long: `
+Sends one DynDNS update for domain, e.g. from cron or a network dispatcher
+script. It is authenticated with the domain's update key (--key or
+IPV64_UPDATE_KEY) or, without one, with the account API token.
+
+The addresses are taken from --ip and --ip6, else from the --ip-source
+lookups (given as <type> or <type>=<value>, as for the ip_source option);
+without either, ipv64 uses the address the update comes from.
+`,
+				Args: cobra.ExactArgs(1),
+				RunE: cmdDynDNSUpdate,
+			}
+			dynUpdate.Flags().String("key", "", "DynDNS update key of the domain (default $IPV64_UPDATE_KEY)")
+			dynUpdate.Flags().String("ip", "", "IPv4 address to set")
+			dynUpdate.Flags().String("ip6", "", "IPv6 address to set")
+			dynUpdate.Flags().StringSlice("ip-source", nil, "Public IP lookups, tried in order")
+			cmd.AddCommand(dynUpdate)
+			preflight := &cobra.Command{
+				Use:   "preflight <name>",
+				Short: "Tests DNS-01 challenges for a name end to end",
+				Long: `
+Runs the steps of a DNS-01 challenge for name without a CA: verifies the
+token, resolves the managed zone, creates a throwaway _acme-challenge TXT
+record, waits for it on the resolvers and ipv64 nameservers and deletes it
+again. Prints a pass/fail report with the time each step took.
+`,
+				Args: cobra.ExactArgs(1),
+				RunE: cmdPreflight,
+			}
+			addPropagationFlags(preflight)
+			cmd.AddCommand(preflight)
+			gen := &cobra.Command{
+				Use:   "caddyfile-gen <domain>...",
+				Short: "Prints a Caddyfile for the given domains",
+				Long: `
+Prints a Caddyfile that gets certificates for the domains through ipv64
+DNS-01 challenges, with the acme_defaults issuer and the recommended
+resolvers and propagation settings. The API token is read from the
+environment variable named by --token-env when Caddy runs.
+`,
+				Args: cobra.MinimumNArgs(1),
+				RunE: cmdCaddyfileGen,
+			}
+			gen.Flags().String("email", "", "ACME account email")
+			gen.Flags().Bool("staging", false, "Use the Let's Encrypt staging CA")
+			gen.Flags().String("upstream", "", "Backend to reverse_proxy to (default: a placeholder response)")
+			gen.Flags().String("token-env", "IPV64_API_TOKEN", "Environment variable holding the API token")
+			cmd.AddCommand(gen)
+			bundle := &cobra.Command{
+				Use:   "debug-bundle",
+				Short: "Collects debugging information for bug reports",
+				Long: `
+Collects the running config with secrets redacted, the ipv64 provider
+status and domains from the admin API, resolver checks and version
+information into one JSON document, or a tarball if --output ends in
+.tar.gz or .tgz. Parts that cannot be collected are listed with their error.
+`,
+				Args: cobra.NoArgs,
+				RunE: cmdDebugBundle,
+			}
+			bundle.Flags().String("address", "", "Address of the admin API of the running Caddy")
+			bundle.Flags().StringP("config", "c", "", "Configuration file, to find the admin address")
+			bundle.Flags().StringP("adapter", "a", "", "Name of the config adapter")
+			bundle.Flags().StringP("output", "o", "", "File to write the bundle to (default stdout)")
+			bundle.Flags().StringSlice("resolver", []string{"ns1.ipv64.net:53", "ns2.ipv64.net:53", "1.1.1.1:53", "8.8.8.8:53", "9.9.9.9:53"}, "Resolvers to check")
+			cmd.AddCommand(bundle)
+		},
+	})
+}
+
+// cliClient returns the API client for the --token and --endpoint flags.
+func cliClient(cmd *cobra.Command) (*ipv64api.Client, error) {
+	token, _ := cmd.Flags().GetString("token")
+	endpoint, _ := cmd.Flags().GetString("endpoint")
+	retries, _ := cmd.Flags().GetInt("retries")
+	if token == "" {
+		token = os.Getenv("IPV64_API_TOKEN")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("no API token: use --token or set IPV64_API_TOKEN")
+	}
+	return &ipv64api.Client{Token: token, Endpoint: endpoint, MaxRetries: retries}, nil
+}
+
+// cliAudit opens the audit file of the --audit-log flag or IPV64_AUDIT_LOG,
+// masking the token of client. Without either it returns nil, which records
+// nothing.
+func cliAudit(cmd *cobra.Command, client *ipv64api.Client) (*auditLogger, error) {
+	path, _ := cmd.Flags().GetString("audit-log")
+	if path == "" {
+		path = os.Getenv("IPV64_AUDIT_LOG")
+	}
+	if path == "" {
+		return nil, nil
+	}
+	audit, err := (&AuditLog{Path: path}).open()
+	if err != nil {
+		return nil, err
+	}
+	audit.redact = ipv64api.NewRedactor(client.Token)
+	return audit, nil
+}
+
+func cmdListDomains(cmd *cobra.Command, _ []string) error {
+	client, err := cliClient(cmd)
+	if err != nil {
+		return err
+	}
+	domains, err := client.GetDomainDetails(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("get_domains: %v", err)
+	}
+	names := make([]string, 0, len(domains))
+	for name := range domains {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOMAIN\tRECORDS\tUPDATES\tWILDCARD")
+	for _, name := range names {
+		d := domains[name]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%t\n", name, len(d.Records), d.Updates, d.Wildcard != 0)
+	}
+	return tw.Flush()
+}
+
+func cmdRecords(cmd *cobra.Command, args []string) error {
+	client, err := cliClient(cmd)
+	if err != nil {
+		return err
+	}
+	records, err := client.ListRecords(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(records, func(a, b ipv64api.Record) int {
+		if c := strings.Compare(a.Praefix, b.Praefix); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Type, b.Type)
+	})
+
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		if records == nil {
+			records = []ipv64api.Record{}
+		}
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+	tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tPREFIX\tTYPE\tTTL\tCONTENT\tLAST UPDATE")
+	for _, r := range records {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\n", r.ID, r.Praefix, r.Type, r.TTL, r.Content, r.LastUpdate)
+	}
+	return tw.Flush()
+}
+
+func cmdAddRecord(cmd *cobra.Command, args []string) error {
+	client, err := cliClient(cmd)
+	if err != nil {
+		return err
+	}
+	audit, err := cliAudit(cmd, client)
+	if err != nil {
+		return err
+	}
+	defer audit.close()
+	domain, prefix, rtype, content := args[0], args[1], strings.ToUpper(args[2]), args[3]
+	err = client.AddRecord(cmd.Context(), domain, prefix, rtype, content)
+	audit.record("cli", "add", domain, prefix, rtype, content, err)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "added %s record %s to %s\n", rtype, prefix, domain)
+	return nil
+}
+
+func cmdDelRecord(cmd *cobra.Command, args []string) error {
+	client, err := cliClient(cmd)
+	if err != nil {
+		return err
+	}
+	audit, err := cliAudit(cmd, client)
+	if err != nil {
+		return err
+	}
+	defer audit.close()
+	domain := args[0]
+	if id, _ := cmd.Flags().GetInt("id"); id > 0 {
+		if len(args) > 1 {
+			return fmt.Errorf("--id cannot be combined with prefix, type and content")
+		}
+		// look the record up, so the audit entry says what was deleted
+		rec := ipv64api.Record{ID: id}
+		if audit != nil {
+			if recs, err := client.ListRecords(cmd.Context(), domain); err == nil {
+				if i := slices.IndexFunc(recs, func(r ipv64api.Record) bool { return r.ID == id }); i >= 0 {
+					rec = recs[i]
+				}
+			}
+		}
+		err := client.DelRecordByID(cmd.Context(), domain, id)
+		audit.record("cli", "delete", domain, rec.Praefix, rec.Type, rec.Content, err)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "deleted record %d of %s\n", id, domain)
+		return nil
+	}
+	if len(args) != 4 {
+		return fmt.Errorf("prefix, type and content, or --id are required")
+	}
+	prefix, rtype, content := args[1], strings.ToUpper(args[2]), args[3]
+	err = client.DelRecord(cmd.Context(), domain, prefix, rtype, content)
+	audit.record("cli", "delete", domain, prefix, rtype, content, err)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "deleted %s record %s of %s\n", rtype, prefix, domain)
+	return nil
+}
+
+// propagationServer is the state of one server polled by check-propagation.
+type propagationServer struct {
+	addr          string
+	authoritative bool
+	visible       time.Duration // time until the value was seen; 0 while not
+	lastErr       error
+}
+
+// addPropagationFlags adds the flags of the propagation checks to c.
+func addPropagationFlags(c *cobra.Command) {
+	c.Flags().StringSlice("resolver", []string{"1.1.1.1:53", "8.8.8.8:53", "9.9.9.9:53"}, "Recursive resolvers to poll")
+	c.Flags().StringSlice("authoritative", []string{"ns1.ipv64.net:53", "ns2.ipv64.net:53"}, "Authoritative nameservers to poll")
+	c.Flags().Duration("timeout", 2*time.Minute, "How long to poll")
+	c.Flags().Duration("interval", 5*time.Second, "Time between polls")
+}
+
+// pollPropagation polls the servers of the --resolver and --authoritative
+// flags until all of them serve the TXT value at name or the --timeout
+// passes. It returns the servers with their state and how many of them
+// never returned the value.
+func pollPropagation(cmd *cobra.Command, name, value string) ([]*propagationServer, int, error) {
+	resolvers, _ := cmd.Flags().GetStringSlice("resolver")
+	authoritative, _ := cmd.Flags().GetStringSlice("authoritative")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	interval, _ := cmd.Flags().GetDuration("interval")
+
+	var servers []*propagationServer
+	for _, r := range resolvers {
+		servers = append(servers, &propagationServer{addr: normalizeResolver(r)})
+	}
+	for _, ns := range authoritative {
+		servers = append(servers, &propagationServer{addr: normalizeResolver(ns), authoritative: true})
+	}
+
+	ctx := cmd.Context()
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for {
+		pending := 0
+		for _, s := range servers {
+			if s.visible > 0 {
+				continue
+			}
+			values, err := lookupTXT(ctx, s.addr, name, !s.authoritative)
+			s.lastErr = err
+			if slices.Contains(values, value) {
+				s.visible = max(time.Since(start), time.Millisecond)
+				continue
+			}
+			pending++
+		}
+		if pending == 0 || time.Now().After(deadline) {
+			return servers, pending, nil
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return servers, pending, ctx.Err()
+		}
+	}
+}
+
+func cmdCheckPropagation(cmd *cobra.Command, args []string) error {
+	name, value := args[0], args[1]
+	servers, missing, err := pollPropagation(cmd, name, value)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVER\tKIND\tVISIBLE AFTER\tLAST ERROR")
+	for _, s := range servers {
+		kind := "resolver"
+		if s.authoritative {
+			kind = "authoritative"
+		}
+		visible := "not visible"
+		if s.visible > 0 {
+			visible = s.visible.Round(time.Millisecond).String()
+		}
+		lastErr := "-"
+		if s.lastErr != nil {
+			lastErr = s.lastErr.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.addr, kind, visible, lastErr)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if missing > 0 {
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		return fmt.Errorf("TXT record %s not visible on %d of %d servers after %s", name, missing, len(servers), timeout)
+	}
+	return nil
+}
+
+func cmdCleanupChallenges(cmd *cobra.Command, args []string) error {
+	client, err := cliClient(cmd)
+	if err != nil {
+		return err
+	}
+	audit, err := cliAudit(cmd, client)
+	if err != nil {
+		return err
+	}
+	defer audit.close()
+	olderThan, _ := cmd.Flags().GetDuration("older-than")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	ctx := cmd.Context()
+
+	domains := args
+	if len(domains) == 0 {
+		if domains, err = client.GetDomains(ctx); err != nil {
+			return fmt.Errorf("get_domains: %v", err)
+		}
+	}
+	out := cmd.OutOrStdout()
+	var deleted, failed int
+	for _, domain := range domains {
+		records, err := client.ListRecords(ctx, domain)
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			if r.Type != "TXT" || (r.Praefix != "_acme-challenge" && !strings.HasPrefix(r.Praefix, "_acme-challenge.")) {
+				continue
+			}
+			updated, ok := r.Updated()
+			if !ok || time.Since(updated) < olderThan {
+				continue
+			}
+			name := prefixedName(r.Praefix, domain)
+			if dryRun {
+				fmt.Fprintf(out, "would delete %s (id %d, updated %s)\n", name, r.ID, r.LastUpdate)
+				deleted++
+				continue
+			}
+			err := client.DelRecordByID(ctx, domain, r.ID)
+			audit.record("cli", "delete", domain, r.Praefix, r.Type, r.Content, err)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "deleting %s (id %d): %v\n", name, r.ID, err)
+				failed++
+				continue
+			}
+			fmt.Fprintf(out, "deleted %s (id %d, updated %s)\n", name, r.ID, r.LastUpdate)
+			deleted++
+		}
+	}
+	if dryRun {
+		fmt.Fprintf(out, "%d record(s) would be deleted\n", deleted)
+		return nil
+	}
+	fmt.Fprintf(out, "%d record(s) deleted\n", deleted)
+	if failed > 0 {
+		return fmt.Errorf("%d record(s) could not be deleted", failed)
+	}
+	return nil
+}
+
+func cmdDynDNSUpdate(cmd *cobra.Command, args []string) error {
+	key, _ := cmd.Flags().GetString("key")
+	token, _ := cmd.Flags().GetString("token")
+	endpoint, _ := cmd.Flags().GetString("endpoint")
+	ip, _ := cmd.Flags().GetString("ip")
+	ip6, _ := cmd.Flags().GetString("ip6")
+	sourceFlags, _ := cmd.Flags().GetStringSlice("ip-source")
+	if key == "" {
+		key = os.Getenv("IPV64_UPDATE_KEY")
+	}
+	if key == "" && token == "" {
+		token = os.Getenv("IPV64_API_TOKEN")
+	}
+	if key == "" && token == "" {
+		return fmt.Errorf("no credentials: use --key, --token, IPV64_UPDATE_KEY or IPV64_API_TOKEN")
+	}
+	ctx := cmd.Context()
+
+	params := url.Values{}
+	params.Set("domain", args[0])
+	if ip == "" && ip6 == "" && len(sourceFlags) > 0 {
+		var sources []IPSource
+		for _, f := range sourceFlags {
+			typ, value, _ := strings.Cut(f, "=")
+			s := IPSource{Type: typ, Value: value}
+			if err := s.validate(); err != nil {
+				return err
+			}
+			sources = append(sources, s)
+		}
+		ip4, ipv6, err := lookupPublicIP(ctx, sources)
+		if err != nil {
+			return fmt.Errorf("determining public IP: %v", err)
+		}
+		if ip4.IsValid() {
+			ip = ip4.String()
+		}
+		if ipv6.IsValid() {
+			ip6 = ipv6.String()
+		}
+	}
+	if ip != "" {
+		params.Set("ip", ip)
+	}
+	if ip6 != "" {
+		params.Set("ip6", ip6)
+	}
+
+	res, err := dynDNSUpdate(ctx, endpoint, dynAuth{key: key, accountToken: token}, params)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), res.Raw)
+	return nil
+}
+
+// preflightStep is a line of the preflight report.
+type preflightStep struct {
+	name    string
+	err     error
+	elapsed time.Duration
+	detail  string
+}
+
+func cmdPreflight(cmd *cobra.Command, args []string) error {
+	client, err := cliClient(cmd)
+	if err != nil {
+		return err
+	}
+	audit, err := cliAudit(cmd, client)
+	if err != nil {
+		return err
+	}
+	defer audit.close()
+	ctx := cmd.Context()
+	name := strings.ToLower(strings.TrimSuffix(args[0], "."))
+	name = strings.TrimPrefix(name, "*.")
+
+	var steps []*preflightStep
+	run := func(step string, fn func() (string, error)) bool {
+		start := time.Now()
+		detail, err := fn()
+		steps = append(steps, &preflightStep{name: step, err: err, elapsed: time.Since(start), detail: detail})
+		return err == nil
+	}
+
+	var domains []string
+	var managed, prefix string
+	value := "ipv64-preflight-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	ok := run("token", func() (string, error) {
+		domains, err = client.GetDomains(ctx)
+		return fmt.Sprintf("%d domain(s) in the account", len(domains)), err
+	})
+	ok = ok && run("zone", func() (string, error) {
+		managed = ipv64.ManagedZone(name, domains)
+		if managed == "" {
+			return "", fmt.Errorf("%s is not in any domain of the account", name)
+		}
+		prefix = "_acme-challenge"
+		if rel := strings.TrimSuffix(name, managed); rel != "" {
+			prefix += "." + strings.TrimSuffix(rel, ".")
+		}
+		return managed, nil
+	})
+	ok = ok && run("create", func() (string, error) {
+		err := client.AddRecord(ctx, managed, prefix, "TXT", value)
+		audit.record("cli", "add", managed, prefix, "TXT", value, err)
+		return prefix + " TXT " + value, err
+	})
+	if ok {
+		run("propagation", func() (string, error) {
+			servers, missing, err := pollPropagation(cmd, prefixedName(prefix, managed), value)
+			if err == nil && missing > 0 {
+				err = fmt.Errorf("not visible on %d of %d servers", missing, len(servers))
+			}
+			var slowest time.Duration
+			for _, s := range servers {
+				slowest = max(slowest, s.visible)
+			}
+			return fmt.Sprintf("slowest server after %s", slowest.Round(time.Millisecond)), err
+		})
+		// clean up even if the record did not propagate
+		run("delete", func() (string, error) {
+			err := client.DelRecord(context.WithoutCancel(ctx), managed, prefix, "TXT", value)
+			audit.record("cli", "delete", managed, prefix, "TXT", value, err)
+			return "", err
+		})
+	}
+
+	tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tRESULT\tTIME\tDETAIL")
+	var failed bool
+	for _, s := range steps {
+		result, detail := "pass", s.detail
+		if s.err != nil {
+			result, detail, failed = "FAIL", s.err.Error(), true
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.name, result, s.elapsed.Round(time.Millisecond), detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if failed {
+		return fmt.Errorf("preflight for %s failed", name)
+	}
+	return nil
+}
+
+// letsEncryptStaging is the directory of the Let's Encrypt staging CA.
+const letsEncryptStaging = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
+func cmdCaddyfileGen(cmd *cobra.Command, args []string) error {
+	email, _ := cmd.Flags().GetString("email")
+	staging, _ := cmd.Flags().GetBool("staging")
+	upstream, _ := cmd.Flags().GetString("upstream")
+	tokenEnv, _ := cmd.Flags().GetString("token-env")
+
+	var b strings.Builder
+	b.WriteString("# Generated by caddy ipv64 caddyfile-gen\n")
+	if email != "" || staging {
+		b.WriteString("{\n")
+		if email != "" {
+			fmt.Fprintf(&b, "email %s\n", email)
+		}
+		if staging {
+			fmt.Fprintf(&b, "acme_ca %s\n", letsEncryptStaging)
+		}
+		b.WriteString("}\n\n")
+	}
+	fmt.Fprintf(&b, `(ipv64_tls) {
+tls {
+issuer acme_defaults {
+dns ipv64 {
+api_token {env.%s}
+}
+resolvers ns1.ipv64.net ns2.ipv64.net
+propagation_delay 30s
+propagation_timeout 4m
+}
+}
+}
+`, tokenEnv)
+	for _, domain := range args {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		if domain == "" || strings.ContainsAny(domain, " \t{}") {
+			return fmt.Errorf("invalid domain %q", domain)
+		}
+		fmt.Fprintf(&b, "\n%s {\nimport ipv64_tls\n", domain)
+		if upstream != "" {
+			fmt.Fprintf(&b, "reverse_proxy %s\n", upstream)
+		} else {
+			fmt.Fprintf(&b, "respond %q\n", domain)
+		}
+		b.WriteString("}\n")
+	}
+	_, err := cmd.OutOrStdout().Write(caddyfile.Format([]byte(b.String())))
+	return err
+}
+
+// bundleError is a part of the debug bundle that could not be collected.
+type bundleError struct {
+	Error string `json:"error"`
+}
+
+// resolverCheck is the result of querying a resolver for the debug bundle.
+type resolverCheck struct {
+	Resolver string `json:"resolver"`
+	Time     string `json:"time"`
+	Error    string `json:"error,omitempty"`
+}
+
+func cmdDebugBundle(cmd *cobra.Command, _ []string) error {
+	address, _ := cmd.Flags().GetString("address")
+	configFile, _ := cmd.Flags().GetString("config")
+	adapter, _ := cmd.Flags().GetString("adapter")
+	output, _ := cmd.Flags().GetString("output")
+	resolvers, _ := cmd.Flags().GetStringSlice("resolver")
+
+	adminAddr, err := caddycmd.DetermineAdminAPIAddress(address, nil, configFile, adapter)
+	if err != nil {
+		return fmt.Errorf("determining admin address: %v", err)
+	}
+	parts := map[string]any{
+		"config":    adminJSON(adminAddr, "/config/", true),
+		"status":    adminJSON(adminAddr, "/ipv64/status", false),
+		"domains":   adminJSON(adminAddr, "/ipv64/domains", false),
+		"resolvers": checkResolvers(cmd.Context(), resolvers),
+		"versions":  bundleVersions(),
+	}
+
+	if strings.HasSuffix(output, ".tar.gz") || strings.HasSuffix(output, ".tgz") {
+		return writeBundleTarball(output, parts)
+	}
+	out := cmd.OutOrStdout()
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(parts)
+}
+
+// adminJSON fetches uri from the admin API. Failures are returned as
+// bundleError, so the rest of the bundle can still be collected.
+func adminJSON(adminAddr, uri string, redact bool) any {
+	resp, err := caddycmd.AdminAPIRequest(adminAddr, http.MethodGet, uri, nil, nil)
+	if err != nil {
+		return bundleError{Error: err.Error()}
+	}
+	defer resp.Body.Close()
+	var v any
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return bundleError{Error: fmt.Sprintf("decoding %s: %v", uri, err)}
+	}
+	if redact {
+		v = redactSecrets(v, false)
+	}
+	return v
+}
+
+// redactSecrets replaces the values of config keys that hold credentials,
+// such as api_token, domain_tokens or the EAB mac_key. Below such a key, all
+// values are redacted.
+func redactSecrets(v any, secret bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			v[k] = redactSecrets(val, secret || isSecretKey(k))
+		}
+	case []any:
+		for i, val := range v {
+			v[i] = redactSecrets(val, secret)
+		}
+	case string:
+		if secret && v != "" {
+			return "REDACTED"
+		}
+	}
+	return v
+}
+
+func isSecretKey(k string) bool {
+	k = strings.ToLower(k)
+	return strings.Contains(k, "token") || strings.Contains(k, "password") ||
+		strings.Contains(k, "secret") || k == "key" || strings.HasSuffix(k, "_key")
+}
+
+// checkResolvers asks each resolver for ipv64.net and reports how long it took.
+func checkResolvers(ctx context.Context, resolvers []string) []resolverCheck {
+	checks := make([]resolverCheck, 0, len(resolvers))
+	for _, r := range resolvers {
+		r = normalizeResolver(r)
+		start := time.Now()
+		_, err := lookupTXT(ctx, r, "ipv64.net", true)
+		c := resolverCheck{Resolver: r, Time: time.Since(start).Round(time.Millisecond).String()}
+		if err != nil {
+			c.Error = err.Error()
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// bundleVersions returns the versions of Go, Caddy and the modules that
+// matter for DNS-01 issuance.
+func bundleVersions() map[string]string {
+	_, caddyVersion := caddy.Version()
+	versions := map[string]string{
+		"go":    runtime.Version(),
+		"os":    runtime.GOOS + "/" + runtime.GOARCH,
+		"caddy": caddyVersion,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			switch dep.Path {
+			case "github.com/caddyserver/certmagic", "github.com/libdns/libdns", "github.com/Sickjuicy/caddy-ipv64":
+				versions[dep.Path] = dep.Version
+			}
+		}
+	}
+	return versions
+}
+
+// writeBundleTarball writes each part of the bundle as a JSON file into a
+// gzipped tarball at path.
+func writeBundleTarball(path string, parts map[string]any) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for name, part := range parts {
+		data, err := json.MarshalIndent(part, "", "  ")
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: name + ".json", Mode: 0o600, Size: int64(len(data)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,60 @@
+package caddyipv64
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestCLIAudit(t *testing.T) {
+	srv := newTestFakeServer(t, new(FakeServer), "example.ipv64.de")
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	cmd := new(cobra.Command)
+	cmd.Flags().String("token", "secret-token", "")
+	cmd.Flags().String("endpoint", srv.URL, "")
+	cmd.Flags().Int("retries", 0, "")
+	cmd.Flags().String("audit-log", path, "")
+	cmd.Flags().Int("id", 0, "")
+	cmd.SetContext(context.Background())
+	cmd.SetOut(io.Discard)
+
+	if err := cmdAddRecord(cmd, []string{"example.ipv64.de", "www", "a", "192.0.2.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdDelRecord(cmd, []string{"example.ipv64.de", "www", "a", "192.0.2.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdDelRecord(cmd, []string{"example.ipv64.de", "www", "a", "192.0.2.1"}); err == nil {
+		t.Fatal("deleting a missing record succeeded")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for line := range strings.Lines(string(data)) {
+		var e auditEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, strings.Join([]string{e.Subsystem, e.Action, e.Zone, e.Name, e.Type, e.Value, e.Result}, " "))
+	}
+	want := []string{
+		"cli add example.ipv64.de www A 192.0.2.1 ok",
+		"cli delete example.ipv64.de www A 192.0.2.1 ok",
+		"cli delete example.ipv64.de www A 192.0.2.1 error",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("audit entries:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if strings.Contains(string(data), "secret-token") {
+		t.Error("audit file contains the API token")
+	}
+}
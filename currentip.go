@@ -0,0 +1,90 @@
+package caddyipv64
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// currentIP is the public address most recently detected by an IP source or
+// pushed with a DynDNS update, by any of the modules.
+var currentIP atomic.Value // string
+
+func setCurrentIP(ip string) {
+	if ip != "" {
+		currentIP.Store(ip)
+	}
+}
+
+// addIPPlaceholders makes {ipv64.current_ip} available in the request's replacer.
+func addIPPlaceholders(r *http.Request) {
+	repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return
+	}
+	repl.Map(func(key string) (any, bool) {
+		if key != "ipv64.current_ip" {
+			return nil, false
+		}
+		ip, _ := currentIP.Load().(string)
+		return ip, true
+	})
+}
+
+func init() {
+	caddy.RegisterModule(Placeholders{})
+	httpcaddyfile.RegisterHandlerDirective("ipv64_placeholders", parsePlaceholdersCaddyfile)
+}
+
+// Placeholders is an HTTP handler that makes {ipv64.current_ip}, the public
+// address last detected or pushed by the DynDNS updaters, available to the
+// handlers after it. The acme_ipv64 handler does the same.
+//
+//	{
+//	    order ipv64_placeholders first
+//	}
+//
+//	example.com {
+//	    ipv64_placeholders
+//	    header X-Public-IP {ipv64.current_ip}
+//	}
+type Placeholders struct{}
+
+// CaddyModule returns the Caddy module information.
+func (Placeholders) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ipv64_placeholders",
+		New: func() caddy.Module { return new(Placeholders) },
+	}
+}
+
+// ServeHTTP adds the placeholders and calls the next handler.
+func (Placeholders) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	addIPPlaceholders(r)
+	return next.ServeHTTP(w, r)
+}
+
+// UnmarshalCaddyfile configures the handler; it takes no options.
+func (p *Placeholders) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	return nil
+}
+
+func parsePlaceholdersCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	p := new(Placeholders)
+	err := p.UnmarshalCaddyfile(h.Dispenser)
+	return p, err
+}
+
+// Interface guards
+var (
+	_ caddyhttp.MiddlewareHandler = (*Placeholders)(nil)
+	_ caddyfile.Unmarshaler       = (*Placeholders)(nil)
+)
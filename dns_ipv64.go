@@ -4,37 +4,212 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
+	"maps"
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/certmagic"
 	"github.com/libdns/libdns"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/Sickjuicy/caddy-ipv64/internal/ipv64api"
+	"github.com/Sickjuicy/caddy-ipv64/ipv64"
 )
 
 // Provider implements libdns for ipv64.net and a Caddy DNS provider module.
+// It shares the record conversion, zone matching, token failover and challenge
+// bookkeeping with ipv64.Provider, and adds rate limiting, maintenance
+// handling, read-back verification and the other Caddy-specific options.
 type Provider struct {
+	// Mode is "live" (default) or "mock"; mock keeps all records in memory
+	// and never talks to ipv64.net, which is useful for CI.
+	Mode string `json:"mode,omitempty"`
+
 	Token                string   `json:"api_token,omitempty" caddy:"namespace=dns.providers.ipv64"`
+	Tokens               []string `json:"api_tokens,omitempty"`
+	TokenBudgetPerMinute int      `json:"token_budget_per_minute,omitempty"`
 	Domain               string   `json:"domain,omitempty"`
+	Endpoint             string   `json:"endpoint,omitempty"`      // base URL of the ipv64 API, e.g. a fakeserver
+	APIPath              string   `json:"api_path,omitempty"`      // "/api" or "/api.php"; detected if empty
+	RequestStyle         string   `json:"request_style,omitempty"` // "form" (default) or "query"
+	DeleteMethod         string   `json:"delete_method,omitempty"` // "POST" (default) or "DELETE"
 	Resolvers            []string `json:"resolvers,omitempty"`
 	TimeoutSeconds       int      `json:"timeout_seconds,omitempty"`
+	MaxIdleConns         int      `json:"max_idle_conns,omitempty"`
 	MaxRetries           int      `json:"max_retries,omitempty"`
 	InitialBackoffMillis int      `json:"initial_backoff_ms,omitempty"`
 	CreateDelaySeconds   int      `json:"create_delay_seconds,omitempty"`
 	DeleteDelaySeconds   int      `json:"delete_delay_seconds,omitempty"`
 
-	logger        *zap.Logger
-	cachedDomains []string // Cache for available domains
-	domainsCached bool     // Flag whether domains have been retrieved
+	// RetryJitter randomizes the backoff between retries: "full" (default),
+	// "decorrelated" or "off".
+	RetryJitter string `json:"retry_jitter,omitempty"`
+
+	// MaxBackoffMillis caps a single backoff sleep (default 10000) and
+	// RetryDeadline bounds the total time one API operation may spend
+	// retrying (default 1m).
+	MaxBackoffMillis int            `json:"max_backoff_ms,omitempty"`
+	RetryDeadline    caddy.Duration `json:"retry_deadline,omitempty"`
+
+	// MaxRetryAfter caps how long a Retry-After of the API is honored
+	// (default 5m).
+	MaxRetryAfter caddy.Duration `json:"max_retry_after,omitempty"`
+
+	// AdaptiveTimeout derives the timeout of each API action from its recent
+	// response times (three times the 95th percentile), bounded by MinTimeout
+	// (default 2s) and MaxTimeout (default 30s), instead of timeout_seconds.
+	AdaptiveTimeout bool           `json:"adaptive_timeout,omitempty"`
+	MinTimeout      caddy.Duration `json:"min_timeout,omitempty"`
+	MaxTimeout      caddy.Duration `json:"max_timeout,omitempty"`
+
+	// Maintenance handling: how long to back off when ipv64 announces maintenance,
+	// and how long a single operation may wait for the maintenance to end in total.
+	MaintenanceBackoffSeconds int `json:"maintenance_backoff_seconds,omitempty"`
+	MaxMaintenanceWaitSeconds int `json:"max_maintenance_wait_seconds,omitempty"`
+
+	// RecordsCacheSeconds is how long list_records results are reused (default 30).
+	RecordsCacheSeconds int `json:"records_cache_seconds,omitempty"`
+
+	// Scoping: restrict the provider to (or exclude) zones and their subdomains.
+	// AllowedDomains and DeniedDomains are aliases that are merged into
+	// OnlyDomains and IgnoreDomains.
+	OnlyDomains    []string `json:"only_domains,omitempty"`
+	IgnoreDomains  []string `json:"ignore_domains,omitempty"`
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+	DeniedDomains  []string `json:"denied_domains,omitempty"`
+
+	AuditLog *AuditLog `json:"audit_log,omitempty"`
+
+	// FailureHook, if set, is notified when a record create or delete
+	// ultimately fails.
+	FailureHook *FailureHook `json:"failure_hook,omitempty"`
+
+	// FailureThreshold is how many record changes must fail in a row before
+	// ipv64.provider_failing is emitted (default 3).
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+
+	// ChallengeLabel replaces the "_acme-challenge" label in the praefix and
+	// ChallengeSuffix is appended to it, for setups that delegate validation
+	// to a dedicated label inside the ipv64 zone.
+	ChallengeLabel  string `json:"challenge_label,omitempty"`
+	ChallengeSuffix string `json:"challenge_suffix,omitempty"`
+
+	// ZoneDepth, if set, makes the managed zone the last ZoneDepth labels of
+	// the record name instead of looking it up. PraefixStyle is "full"
+	// (default: all labels below the managed zone) or "first_label".
+	ZoneDepth    int    `json:"zone_depth,omitempty"`
+	PraefixStyle string `json:"praefix_style,omitempty"`
+
+	// DomainsCacheTTL is how long the account's domain list is reused before
+	// get_domains is called again (default 1h).
+	DomainsCacheTTL caddy.Duration `json:"domains_cache_ttl,omitempty"`
+
+	// AllowAnyZone restores the permissive behavior of writing records for
+	// names outside of the account's domains with a best-effort praefix, and
+	// of guessing the managed zone when get_domains fails.
+	AllowAnyZone bool `json:"allow_any_zone,omitempty"`
+
+	// BaseDomains are the domains ipv64.net offers to register names below
+	// (e.g. "ipv64.de", "any64.de"). They replace the built-in *64.de/*64.net
+	// pattern used to guess the managed zone when the domain list is unavailable.
+	BaseDomains []string `json:"base_domains,omitempty"`
+
+	// VerifyPropagation makes AppendRecords poll the resolvers until created
+	// TXT records are visible, instead of waiting create_delay_seconds.
+	// PropagationTimeout (default 2m) bounds the wait and PropagationInterval
+	// (default 5s) is the time between polls.
+	VerifyPropagation   bool           `json:"verify_propagation,omitempty"`
+	PropagationTimeout  caddy.Duration `json:"propagation_timeout,omitempty"`
+	PropagationInterval caddy.Duration `json:"propagation_interval,omitempty"`
+
+	// PropagationQuorum is how many of the resolvers must see a record when
+	// verifying propagation (default: all of them).
+	PropagationQuorum int `json:"propagation_quorum,omitempty"`
+
+	// CheckAuthoritative makes AppendRecords query AuthoritativeNameservers
+	// (default ns1/ns2.ipv64.net) directly until all of them serve the
+	// created TXT records.
+	CheckAuthoritative       bool     `json:"check_authoritative,omitempty"`
+	AuthoritativeNameservers []string `json:"authoritative_nameservers,omitempty"`
+
+	// WaitForZoneSync makes record changes wait until the SOA serial of the
+	// managed zone is the same on all AuthoritativeNameservers.
+	WaitForZoneSync bool `json:"wait_for_zone_sync,omitempty"`
+
+	// MinRecordLifetime keeps records created by this provider for at least
+	// this long before deleting them, for CA validators that query late.
+	MinRecordLifetime caddy.Duration `json:"min_record_lifetime,omitempty"`
+
+	// PropagationModes overrides how AppendRecords waits for records below
+	// the given domains: "off", "delay" (create_delay_seconds) or "verify"
+	// (resolver and/or authoritative checks). The most specific domain wins.
+	PropagationModes map[string]string `json:"propagation_modes,omitempty"`
+
+	// CAA, if set, maintains CAA records for every managed zone.
+	CAA *CAAConfig `json:"caa,omitempty"`
+
+	// MailPresets maintain the standard mail record set of the given domains.
+	MailPresets []*MailPreset `json:"mail_presets,omitempty"`
+
+	// StaggerRequestsPerMinute spaces out record changes of the account over
+	// time (0 disables staggering).
+	StaggerRequestsPerMinute int `json:"stagger_requests_per_minute,omitempty"`
+
+	// MaxRequestsPerMinute limits all API requests of the account with a
+	// token bucket (0 means unlimited). SerializeWrites makes record changes
+	// of concurrent challenges run one at a time, in the order they arrive.
+	MaxRequestsPerMinute int  `json:"max_requests_per_minute,omitempty"`
+	SerializeWrites      bool `json:"serialize_writes,omitempty"`
+
+	// MaxConcurrentRequests is how many records of one AppendRecords or
+	// DeleteRecords call are processed at the same time (default 4).
+	MaxConcurrentRequests int `json:"max_concurrent_requests,omitempty"`
+
+	// DomainTokens maps domains to their domain-scoped DynDNS update keys.
+	// TXT records below such a domain are set and removed through the
+	// DynDNS API with that key, so no account token is needed for them.
+	DomainTokens map[string]string `json:"domain_tokens,omitempty"`
+
+	// DebugAPI logs every API call with its form parameters, status and
+	// response at debug level. The calls of one AppendRecords, DeleteRecords,
+	// GetRecords or ListZones call share an operation_id.
+	DebugAPI bool `json:"debug_api,omitempty"`
+
+	logger         *zap.Logger
+	events         eventEmitter
+	audit          *auditLogger
+	caa            *caaState
+	records        *recordsCache
+	tokens         *ipv64api.TokenSet // failover order: api_token, then api_tokens
+	pending        *pendingRegistry   // challenge records not yet deleted, shared across reloads
+	domainsMu      *sync.Mutex
+	cachedDomains  []string  // Cache for available domains
+	domainsCached  bool      // Flag whether domains have been retrieved
+	domainsFetched time.Time // when cachedDomains was fetched from the API
+	storage        certmagic.Storage
+	client         *http.Client // shared by all API calls of this provider
+	latency        *latencyTracker
+	calls          *apiCallLog        // latest API calls, for the admin API
+	failures       *failureStreak     // consecutive failed record changes
+	redact         *ipv64api.Redactor // masks tokens in logs and errors
+	api            ipv64.APIClient    // by default sends through doWithRetryFormBody
+
+	maintenanceMu    *sync.Mutex
+	maintenanceUntil time.Time // ipv64 announced maintenance; no requests before this
 }
 
 // Note: We implement AppendRecords/DeleteRecords required by Caddy's libdns bridge.
-// GetRecords/SetRecords are optional and implemented as stubs below.
+// GetRecords uses list_records and ListZones get_domains; SetRecords is only
+// available in mock mode.
 
 // Caddy module registration
 func (Provider) CaddyModule() caddy.ModuleInfo {
@@ -47,24 +222,177 @@ func (Provider) CaddyModule() caddy.ModuleInfo {
 // Provision sets defaults and environment fallbacks.
 func (p *Provider) Provision(ctx caddy.Context) error {
 	p.logger = ctx.Logger(p)
+	events, err := newEventEmitter(ctx)
+	if err != nil {
+		return fmt.Errorf("getting events app: %v", err)
+	}
+	p.events = events
+	initMetrics(ctx.GetMetricsRegistry())
+	p.maintenanceMu = new(sync.Mutex)
+	p.domainsMu = new(sync.Mutex)
+	p.caa = &caaState{ensured: make(map[string]bool)}
+	p.records = &recordsCache{entries: make(map[string]recordsCacheEntry)}
+	p.calls = new(apiCallLog)
+	p.failures = new(failureStreak)
+	if p.FailureThreshold <= 0 {
+		p.FailureThreshold = 3
+	}
+	if p.AuditLog != nil {
+		audit, err := p.AuditLog.open()
+		if err != nil {
+			return err
+		}
+		p.audit = audit
+	}
+	if p.Mode == "" {
+		p.Mode = modeLive
+	}
+	if p.Mode != modeLive && p.Mode != modeMock {
+		return fmt.Errorf("invalid mode %q (must be %q or %q)", p.Mode, modeLive, modeMock)
+	}
+	if p.Mode == modeMock {
+		p.logger.Warn("ipv64 provider running in mock mode; no records are published to ipv64.net")
+	}
+	p.OnlyDomains = append(p.OnlyDomains, p.AllowedDomains...)
+	p.IgnoreDomains = append(p.IgnoreDomains, p.DeniedDomains...)
+	if p.PraefixStyle == "" {
+		p.PraefixStyle = praefixFull
+	}
+	if p.PraefixStyle != praefixFull && p.PraefixStyle != praefixFirstLabel {
+		return fmt.Errorf("invalid praefix_style %q (must be %q or %q)", p.PraefixStyle, praefixFull, praefixFirstLabel)
+	}
+	if p.RequestStyle == "" {
+		p.RequestStyle = ipv64api.StyleForm
+	}
+	if p.RequestStyle != ipv64api.StyleForm && p.RequestStyle != ipv64api.StyleQuery {
+		return fmt.Errorf("invalid request_style %q (must be %q or %q)", p.RequestStyle, ipv64api.StyleForm, ipv64api.StyleQuery)
+	}
+	p.DeleteMethod = strings.ToUpper(p.DeleteMethod)
+	if p.DeleteMethod == "" {
+		p.DeleteMethod = http.MethodPost
+	}
+	if p.DeleteMethod != http.MethodPost && p.DeleteMethod != http.MethodDelete {
+		return fmt.Errorf("invalid delete_method %q (must be POST or DELETE)", p.DeleteMethod)
+	}
+	if p.ZoneDepth < 0 {
+		return fmt.Errorf("invalid zone_depth %d", p.ZoneDepth)
+	}
 	if p.Token == "" {
 		p.Token = os.Getenv("IPV64_API_TOKEN")
 	}
+	if p.Token == "" && len(p.Tokens) > 0 {
+		p.Token = p.Tokens[0]
+	}
+	if p.MaxConcurrentRequests <= 0 {
+		p.MaxConcurrentRequests = 4
+	}
+	p.tokens = ipv64api.NewTokenSet(append([]string{p.Token}, p.Tokens...), p.TokenBudgetPerMinute)
+	p.redact = ipv64api.NewRedactor(slices.Concat([]string{p.Token}, p.Tokens, slices.Collect(maps.Values(p.DomainTokens)))...)
+	p.logger = redactLogger(p.logger, p.redact)
+	if p.audit != nil {
+		p.audit.redact = p.redact
+	}
+	pending, err := loadPendingRegistry(p.Token)
+	if err != nil {
+		return err
+	}
+	p.pending = pending
+	p.storage = ctx.Storage()
+	if p.Mode == modeLive {
+		p.loadDomains(ctx)
+	}
+	if p.Endpoint == "" {
+		p.Endpoint = ipv64.DefaultEndpoint
+	}
+	p.Endpoint = strings.TrimSuffix(p.Endpoint, "/")
 	if p.TimeoutSeconds <= 0 {
 		p.TimeoutSeconds = 5
 	}
+	if p.MaxIdleConns <= 0 {
+		p.MaxIdleConns = 4
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = p.MaxIdleConns
+	transport.MaxIdleConnsPerHost = p.MaxIdleConns
+	if p.MinTimeout <= 0 {
+		p.MinTimeout = caddy.Duration(2 * time.Second)
+	}
+	if p.MaxTimeout <= 0 {
+		p.MaxTimeout = caddy.Duration(30 * time.Second)
+	}
+	if p.MaxTimeout < p.MinTimeout {
+		return fmt.Errorf("max_timeout %s is below min_timeout %s", time.Duration(p.MaxTimeout), time.Duration(p.MinTimeout))
+	}
+	// requests are bounded by requestTimeout; the client timeout is only a backstop
+	clientTimeout := time.Duration(p.TimeoutSeconds) * time.Second
+	if p.AdaptiveTimeout {
+		clientTimeout = max(clientTimeout, time.Duration(p.MaxTimeout))
+	}
+	p.client = &http.Client{
+		Timeout:   clientTimeout,
+		Transport: transport,
+	}
+	p.latency = newLatencyTracker()
+	if p.api == nil {
+		p.api = &ipv64api.Client{
+			Endpoint:     p.Endpoint,
+			APIPath:      p.APIPath,
+			RequestStyle: p.RequestStyle,
+			DeleteMethod: p.DeleteMethod,
+			Token:        p.Token,
+			HTTPClient:   p.client,
+			Logger:       p.logger,
+			Send:         p.doWithRetryFormBody,
+		}
+	}
 	if p.MaxRetries <= 0 {
 		p.MaxRetries = 3
 	}
 	if p.InitialBackoffMillis <= 0 {
 		p.InitialBackoffMillis = 400
 	}
+	if p.MaxBackoffMillis <= 0 {
+		p.MaxBackoffMillis = 10000
+	}
+	if p.MaxBackoffMillis < p.InitialBackoffMillis {
+		p.MaxBackoffMillis = p.InitialBackoffMillis
+	}
+	if p.RetryDeadline <= 0 {
+		p.RetryDeadline = caddy.Duration(time.Minute)
+	}
+	if p.MaxRetryAfter <= 0 {
+		p.MaxRetryAfter = caddy.Duration(5 * time.Minute)
+	}
+	if p.RetryJitter == "" {
+		p.RetryJitter = jitterFull
+	}
+	if p.RetryJitter != jitterOff && p.RetryJitter != jitterFull && p.RetryJitter != jitterDecorrelated {
+		return fmt.Errorf("invalid retry_jitter %q (must be %q, %q or %q)", p.RetryJitter, jitterFull, jitterDecorrelated, jitterOff)
+	}
 	if p.CreateDelaySeconds <= 0 {
 		p.CreateDelaySeconds = 25
 	}
 	if p.DeleteDelaySeconds < 0 {
 		p.DeleteDelaySeconds = 0
 	}
+	if p.RecordsCacheSeconds <= 0 {
+		p.RecordsCacheSeconds = 30
+	}
+	if p.PropagationTimeout <= 0 {
+		p.PropagationTimeout = caddy.Duration(2 * time.Minute)
+	}
+	if p.PropagationInterval <= 0 {
+		p.PropagationInterval = caddy.Duration(5 * time.Second)
+	}
+	if p.DomainsCacheTTL <= 0 {
+		p.DomainsCacheTTL = caddy.Duration(time.Hour)
+	}
+	if p.MaintenanceBackoffSeconds <= 0 {
+		p.MaintenanceBackoffSeconds = 60
+	}
+	if p.MaxMaintenanceWaitSeconds <= 0 {
+		p.MaxMaintenanceWaitSeconds = 600
+	}
 	if len(p.Resolvers) == 0 {
 		// Prefer ipv64 nameservers first, then common public resolvers
 		p.Resolvers = []string{
@@ -75,113 +403,249 @@ func (p *Provider) Provision(ctx caddy.Context) error {
 			"9.9.9.9:53",
 		}
 	} else {
-		// normalize to include :53 if missing
 		for i, r := range p.Resolvers {
-			if !strings.Contains(r, ":") {
-				p.Resolvers[i] = r + ":53"
-			}
+			p.Resolvers[i] = normalizeResolver(r)
+		}
+	}
+	if len(p.AuthoritativeNameservers) == 0 {
+		p.AuthoritativeNameservers = []string{"ns1.ipv64.net:53", "ns2.ipv64.net:53"}
+	}
+	for i, ns := range p.AuthoritativeNameservers {
+		p.AuthoritativeNameservers[i] = normalizeResolver(ns)
+	}
+	for domain, mode := range p.PropagationModes {
+		if mode != propagationOff && mode != propagationDelay && mode != propagationVerify {
+			return fmt.Errorf("invalid propagation mode %q for %s (must be %q, %q or %q)",
+				mode, domain, propagationOff, propagationDelay, propagationVerify)
 		}
 	}
+	if p.PropagationQuorum > len(p.Resolvers) {
+		return fmt.Errorf("propagation_quorum %d exceeds the %d configured resolvers", p.PropagationQuorum, len(p.Resolvers))
+	}
+	for _, preset := range p.MailPresets {
+		if err := preset.provision(); err != nil {
+			return err
+		}
+	}
+	go p.applyMailPresets(ctx)
+	trackLive(live.providers, p, true)
 	return nil
 }
 
 // Validate ensures required fields are present.
 func (p *Provider) Validate() error {
-	if p.Token == "" {
-		return errors.New("api_token is required (or set IPV64_API_TOKEN)")
+	if p.Mode == modeMock {
+		return nil
+	}
+	if p.Token == "" && len(p.DomainTokens) == 0 {
+		return errors.New("api_token, api_tokens or domain_token is required (or set IPV64_API_TOKEN)")
 	}
 	return nil
 }
 
+// Cleanup releases resources such as the audit log file.
+func (p *Provider) Cleanup() error {
+	trackLive(live.providers, p, false)
+	if p.pending != nil {
+		_, _ = pendingPool.Delete(p.Token)
+	}
+	if p.client != nil {
+		p.client.CloseIdleConnections()
+	}
+	return p.audit.close()
+}
+
+// SetAPIClient replaces the HTTP layer, e.g. with a fake in tests. It must
+// be called before Provision.
+func (p *Provider) SetAPIClient(c ipv64.APIClient) {
+	p.api = c
+}
+
 // SetResolvers can be used by tests to override resolvers.
 func (p *Provider) SetResolvers(resolvers []string) {
 	p.Resolvers = resolvers
 }
 
-// AppendRecords creates TXT records for the ACME dns-01 challenge.
-func (p *Provider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+// AppendRecords creates records; TXT for the ACME dns-01 challenge, but A, AAAA,
+// CNAME, MX, NS, SRV and CAA are supported as well.
+func (p *Provider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) (_ []libdns.Record, err error) {
+	ctx = p.debugContext(ctx)
+	ctx, span := startSpan(ctx, "ipv64.AppendRecords",
+		attribute.String("zone", zone), attribute.Int("records", len(recs)))
+	defer func() {
+		err = p.redact.Error(err)
+		endSpan(span, err)
+	}()
+	if err := p.checkRecordsScope(zone, recs); err != nil {
+		return nil, err
+	}
+	if p.Mode == modeMock {
+		return mockZones.append(zone, recs), nil
+	}
 	if err := p.Validate(); err != nil {
 		return nil, err
 	}
 	zone = normalizeZone(zone)
-	client := &http.Client{Timeout: time.Duration(p.TimeoutSeconds) * time.Second}
+
+	results := make([]appendResult, len(recs))
+	var g errgroup.Group
+	g.SetLimit(p.MaxConcurrentRequests)
+	for i, r := range recs {
+		g.Go(func() error {
+			results[i] = p.appendRecord(ctx, zone, r)
+			return nil
+		})
+	}
+	_ = g.Wait()
 
 	var appended []libdns.Record
-	for _, r := range recs {
-		rr := r.RR()
-		fqdn := libdns.AbsoluteName(rr.Name, zone)
-		value := rr.Data
-		// ipv64.net expects relative label under the managed domain
-		managed := p.deriveManagedZone(fqdn, zone)
-		if managed == "" {
-			return appended, fmt.Errorf("cannot derive managed zone for %s in zone %s", fqdn, zone)
-		}
-		// Compute relative prefix under managed zone
-		// Remove the managed zone and trailing dot from fqdn
-		fqdnClean := strings.TrimSuffix(fqdn, ".")
-		managedClean := strings.TrimSuffix(managed, ".")
-
-		var prefix string
-		if fqdnClean == managedClean {
-			prefix = "@"
-		} else if strings.HasSuffix(fqdnClean, "."+managedClean) {
-			prefix = strings.TrimSuffix(fqdnClean, "."+managedClean)
-		} else {
-			// Fallback: use the first part before the first dot
-			parts := strings.Split(fqdnClean, ".")
-			prefix = parts[0]
+	var created []propagationTarget
+	var failed RecordErrors
+	changed := make(map[string]bool) // managed zones with new records
+	for i, res := range results {
+		if res.err != nil {
+			failed = append(failed, &RecordError{Record: recs[i], Err: res.err})
+			continue
+		}
+		appended = append(appended, res.record)
+		if res.target != nil {
+			created = append(created, *res.target)
+			changed[res.managed] = true
 		}
+	}
+	if err := p.awaitPropagation(ctx, created, changed); err != nil {
+		return appended, err
+	}
+	if len(failed) > 0 {
+		return appended, failed
+	}
+	return appended, nil
+}
 
-		if p.logger != nil {
-			p.logger.Debug("ipv64: DNS record details",
-				zap.String("fqdn", fqdn),
-				zap.String("zone", zone),
-				zap.String("managed", managed),
-				zap.String("prefix", prefix),
-				zap.String("value", value))
+// appendResult is the outcome of creating a single record.
+type appendResult struct {
+	record  libdns.Record
+	target  *propagationTarget // nil if an existing record was reused
+	managed string
+	err     error
+}
+
+// appendRecord creates r, or reuses an identical record of another in-flight challenge.
+func (p *Provider) appendRecord(ctx context.Context, zone string, r libdns.Record) appendResult {
+	rr := r.RR()
+	fqdn := libdns.AbsoluteName(rr.Name, zone)
+	rtype := ipv64.RecordType(rr)
+	value, err := ipv64.Content(rr)
+	if err != nil {
+		return appendResult{err: err}
+	}
+	if domain, key := domainToken(p.DomainTokens, fqdn); key != "" {
+		err := p.dynTXT(ctx, domain, key, fqdn, rtype, value, false)
+		return appendResult{record: r, managed: domain, err: err}
+	}
+	// Values under the same name are independent records; only an identical
+	// value of another in-flight challenge is shared instead of duplicated.
+	rec, create, err := p.pending.Reserve(ctx, fqdn, rtype, value)
+	if err != nil {
+		return appendResult{err: err}
+	}
+	if !create {
+		return appendResult{record: ipv64.WithID(ipv64.Typed(libdns.RR{Name: rr.Name, TTL: rr.TTL, Type: rtype, Data: rr.Data}), rec.ID)}
+	}
+	added := false
+	defer func() {
+		if !added {
+			p.pending.Abandon(fqdn, rtype, value)
 		}
+	}()
+	// ipv64.net expects relative label under the managed domain
+	managed, err := p.managedZone(ctx, fqdn, zone)
+	if err != nil {
+		observeRecordOp("", "append", err)
+		p.recordFailed("append", fqdn, "", "", rtype, err)
+		return appendResult{err: err}
+	}
+	prefix := p.recordPrefix(fqdn, managed)
+	p.ensureCAA(ctx, managed)
 
-		// Use form-urlencoded format as per API documentation
-		formData := url.Values{}
-		formData.Set("add_record", managed)
-		formData.Set("praefix", prefix)
-		formData.Set("type", "TXT")
-		formData.Set("content", value)
+	if p.logger != nil {
+		p.logger.Debug("ipv64: DNS record details",
+			zap.String("fqdn", fqdn),
+			zap.String("zone", zone),
+			zap.String("managed", managed),
+			zap.String("prefix", prefix),
+			zap.String("value", value))
+	}
 
-		apiURL := "https://ipv64.net/api"
-		err := p.doWithRetryForm(ctx, client, http.MethodPost, apiURL, formData)
+	// The API occasionally confirms add_record without storing the record,
+	// so the record is read back and created again if it is missing.
+	var id int
+	var addedAt time.Time
+	for attempt := 1; ; attempt++ {
+		err = p.serializeWrite(ctx, func(ctx context.Context) error {
+			return p.api.AddRecord(ctx, managed, prefix, rtype, value)
+		})
+		p.audit.record("dns_provider", "add", managed, prefix, rtype, value, err)
+		observeRecordOp(managed, "append", err)
 		if err != nil {
-			return appended, err
+			p.recordFailed("append", fqdn, managed, prefix, rtype, err)
+			return appendResult{err: err}
 		}
-		appended = append(appended, r)
-		if p.logger != nil {
-			p.logger.Debug("ipv64: appended TXT", zap.String("fqdn", fqdn), zap.String("zone", managed))
+		addedAt = time.Now()
+		var listed bool
+		id, listed = p.recordID(ctx, managed, prefix, rtype, value)
+		if id != 0 || !listed {
+			break
 		}
-	}
-
-	// Wait for DNS propagation after creating records
-	if p.CreateDelaySeconds > 0 {
-		if p.logger != nil {
-			p.logger.Debug("ipv64: waiting for DNS propagation after record creation",
-				zap.Int("delay_seconds", p.CreateDelaySeconds))
+		if attempt >= p.MaxRetries {
+			err := fmt.Errorf("ipv64 confirmed %s record %s but it is not in list_records after %d attempts", rtype, fqdn, attempt)
+			p.recordFailed("append", fqdn, managed, prefix, rtype, err)
+			return appendResult{err: err}
 		}
-		select {
-		case <-time.After(time.Duration(p.CreateDelaySeconds) * time.Second):
-		case <-ctx.Done():
-			return appended, ctx.Err()
+		if p.logger != nil {
+			p.logger.Warn("ipv64: created record is missing, creating it again",
+				zap.String("fqdn", fqdn), zap.String("type", rtype), zap.Int("attempt", attempt))
 		}
 	}
-
-	return appended, nil
+	p.pending.Add(fqdn, pendingRecord{
+		ID:      id,
+		Managed: managed,
+		Prefix:  prefix,
+		Type:    rtype,
+		Value:   value,
+		Created: time.Now(),
+	})
+	added = true
+	p.recordSucceeded()
+	if p.logger != nil {
+		p.logger.Debug("ipv64: appended record", zap.String("fqdn", fqdn), zap.String("type", rtype), zap.String("zone", managed))
+	}
+	return appendResult{
+		record:  ipv64.WithID(ipv64.Typed(libdns.RR{Name: rr.Name, TTL: rr.TTL, Type: rtype, Data: rr.Data}), id),
+		target:  &propagationTarget{fqdn: fqdn, name: prefixedName(prefix, managed), managed: managed, rtype: rtype, value: value, created: addedAt},
+		managed: managed,
+	}
 }
 
-// DeleteRecords deletes TXT records, optionally with a configurable delay.
-func (p *Provider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+// DeleteRecords deletes records, optionally with a configurable delay.
+func (p *Provider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) (_ []libdns.Record, err error) {
+	ctx = p.debugContext(ctx)
+	ctx, span := startSpan(ctx, "ipv64.DeleteRecords",
+		attribute.String("zone", zone), attribute.Int("records", len(recs)))
+	defer func() {
+		err = p.redact.Error(err)
+		endSpan(span, err)
+	}()
+	if err := p.checkRecordsScope(zone, recs); err != nil {
+		return nil, err
+	}
+	if p.Mode == modeMock {
+		return mockZones.delete(zone, recs), nil
+	}
 	if err := p.Validate(); err != nil {
 		return nil, err
 	}
 	zone = normalizeZone(zone)
-	client := &http.Client{Timeout: time.Duration(p.TimeoutSeconds) * time.Second}
 
 	// Delay delete to reduce flakiness during secondary validation
 	if p.DeleteDelaySeconds > 0 {
@@ -192,89 +656,304 @@ func (p *Provider) DeleteRecords(ctx context.Context, zone string, recs []libdns
 		}
 	}
 
+	results := make([]deleteResult, len(recs))
+	var g errgroup.Group
+	g.SetLimit(p.MaxConcurrentRequests)
+	for i, r := range recs {
+		g.Go(func() error {
+			results[i] = p.deleteRecord(ctx, zone, r)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
 	var deleted []libdns.Record
-	for _, r := range recs {
-		rr := r.RR()
-		fqdn := libdns.AbsoluteName(rr.Name, zone)
-		value := rr.Data // Get the TXT record content
-		managed := p.deriveManagedZone(fqdn, zone)
-		if managed == "" {
-			continue
+	var failed RecordErrors
+	changed := make(map[string]bool) // managed zones with deleted records
+	for i, res := range results {
+		deleted = append(deleted, res.records...)
+		for _, managed := range res.managed {
+			changed[managed] = true
+		}
+		if res.err != nil {
+			failed = append(failed, &RecordError{Record: recs[i], Err: res.err})
+		}
+	}
+	if p.WaitForZoneSync {
+		for managed := range changed {
+			if err := p.waitForZoneSync(ctx, managed); err != nil {
+				return deleted, err
+			}
 		}
+	}
+	if len(failed) > 0 {
+		return deleted, failed
+	}
+	return deleted, nil
+}
 
-		// Compute relative prefix under managed zone
-		// Remove the managed zone and trailing dot from fqdn
-		fqdnClean := strings.TrimSuffix(fqdn, ".")
-		managedClean := strings.TrimSuffix(managed, ".")
+// deleteResult is the outcome of deleting the records matching a single record.
+// A partially deleted record has both records and err set.
+type deleteResult struct {
+	records []libdns.Record
+	managed []string // managed zones with deleted records
+	err     error
+}
 
-		var prefix string
-		if fqdnClean == managedClean {
-			prefix = "@"
-		} else if strings.HasSuffix(fqdnClean, "."+managedClean) {
-			prefix = strings.TrimSuffix(fqdnClean, "."+managedClean)
-		} else {
-			// Fallback: use the first part before the first dot
-			parts := strings.Split(fqdnClean, ".")
-			prefix = parts[0]
+// deleteRecord deletes the records matching r, unless another in-flight
+// challenge still needs them.
+func (p *Provider) deleteRecord(ctx context.Context, zone string, r libdns.Record) deleteResult {
+	rr := r.RR()
+	fqdn := libdns.AbsoluteName(rr.Name, zone)
+	if domain, key := domainToken(p.DomainTokens, fqdn); key != "" {
+		value, err := ipv64.Content(rr)
+		if err == nil {
+			err = p.dynTXT(ctx, domain, key, fqdn, ipv64.RecordType(rr), value, true)
 		}
+		if err != nil {
+			return deleteResult{err: err}
+		}
+		return deleteResult{records: []libdns.Record{r}, managed: []string{domain}}
+	}
+	var targets []pendingRecord
+	if value, err := ipv64.Content(rr); err == nil && rr.Data != "" {
+		if p.pending.Release(fqdn, ipv64.RecordType(rr), value) {
+			// still needed by another in-flight challenge
+			return deleteResult{records: []libdns.Record{r}}
+		}
+		if rec, ok := p.pending.Get(fqdn, ipv64.RecordType(rr), value); ok {
+			// created by us (possibly before a reload): delete exactly what was added
+			targets = []pendingRecord{rec}
+		}
+	}
+	if targets == nil {
+		managed, err := p.managedZone(ctx, fqdn, zone)
+		if err == nil {
+			targets, err = p.deleteTargets(ctx, r, fqdn, managed, p.recordPrefix(fqdn, managed))
+		}
+		if err != nil {
+			if p.logger != nil {
+				p.logger.Warn("ipv64: delete failed", zap.String("fqdn", fqdn), zap.Error(err))
+			}
+			observeRecordOp("", "delete", err)
+			p.recordFailed("delete", fqdn, "", "", ipv64.RecordType(rr), err)
+			return deleteResult{err: err}
+		}
+	}
 
-		// Use form-urlencoded format as per API documentation
-		formData := url.Values{}
-		formData.Set("del_record", managed)
-		formData.Set("praefix", prefix)
-		formData.Set("type", "TXT")
-		formData.Set("content", value) // Include content parameter as required by API
-
+	var res deleteResult
+	for _, t := range targets {
+		if err := p.waitMinLifetime(ctx, t); err != nil {
+			res.err = err
+			return res
+		}
 		if p.logger != nil {
 			p.logger.Debug("ipv64: DNS delete details",
 				zap.String("fqdn", fqdn),
 				zap.String("zone", zone),
-				zap.String("managed", managed),
-				zap.String("prefix", prefix),
-				zap.String("value", value))
+				zap.String("managed", t.Managed),
+				zap.String("prefix", t.Prefix),
+				zap.String("type", t.Type),
+				zap.String("value", t.Value),
+				zap.Int("record_id", t.ID))
 		}
 
-		apiURL := "https://ipv64.net/api"
-		if err := p.doWithRetryForm(ctx, client, http.MethodDelete, apiURL, formData); err != nil {
+		err := p.serializeWrite(ctx, func(ctx context.Context) error {
+			if t.ID != 0 {
+				return p.api.DelRecordByID(ctx, t.Managed, t.ID)
+			}
+			return p.api.DelRecord(ctx, t.Managed, t.Prefix, t.Type, t.Value)
+		})
+		p.audit.record("dns_provider", "delete", t.Managed, t.Prefix, t.Type, t.Value, err)
+		observeRecordOp(t.Managed, "delete", err)
+		if err != nil {
+			p.recordFailed("delete", fqdn, t.Managed, t.Prefix, t.Type, err)
 			if p.logger != nil {
 				p.logger.Warn("ipv64: delete failed", zap.String("fqdn", fqdn), zap.Error(err))
 			}
+			res.err = errors.Join(res.err, err)
 			continue
 		}
-		deleted = append(deleted, r)
+		p.recordSucceeded()
+		p.records.invalidate(t.Managed)
+		res.managed = append(res.managed, t.Managed)
+		p.pending.Remove(fqdn, t.Type, t.Value)
+		res.records = append(res.records, ipv64.WithID(ipv64.Typed(libdns.RR{Name: rr.Name, TTL: rr.TTL, Type: t.Type, Data: t.Value}), t.ID))
 		if p.logger != nil {
-			p.logger.Debug("ipv64: deleted TXT", zap.String("fqdn", fqdn), zap.String("zone", managed))
+			p.logger.Debug("ipv64: deleted record", zap.String("fqdn", fqdn), zap.String("type", t.Type), zap.String("zone", t.Managed))
 		}
 	}
-	return deleted, nil
+	return res
+}
+
+// dynTXT sets or removes a TXT record below domain through the DynDNS API
+// with the domain's update key, which cannot manage other record types.
+func (p *Provider) dynTXT(ctx context.Context, domain, key, fqdn, rtype, value string, del bool) error {
+	if rtype != "TXT" {
+		return fmt.Errorf("%s record %s: the update key of %s can only manage TXT records", rtype, fqdn, domain)
+	}
+	prefix := p.recordPrefix(fqdn, domain)
+	params := url.Values{}
+	params.Set("domain", domain)
+	params.Set("praefix", prefix)
+	params.Set("type", rtype)
+	params.Set("content", value)
+	action, operation := "add", "append"
+	if del {
+		params.Set("del_record", "1")
+		action, operation = "delete", "delete"
+	}
+	_, err := dynDNSUpdate(ctx, p.Endpoint, dynAuth{key: key}, params)
+	p.audit.record("dns_provider", action, domain, prefix, rtype, value, err)
+	observeRecordOp(domain, operation, err)
+	if err != nil {
+		p.recordFailed(operation, fqdn, domain, prefix, rtype, err)
+	} else {
+		p.recordSucceeded()
+	}
+	return err
+}
+
+// recordPrefix computes the praefix of fqdn relative to the managed zone,
+// applying the configured challenge label and suffix.
+func (p *Provider) recordPrefix(fqdn, managed string) string {
+	prefix := ipv64.Prefix(fqdn, managed)
+	if p.PraefixStyle == praefixFirstLabel {
+		prefix, _, _ = strings.Cut(prefix, ".")
+	}
+
+	if p.ChallengeLabel != "" {
+		if prefix == "_acme-challenge" {
+			prefix = p.ChallengeLabel
+		} else if rest, ok := strings.CutPrefix(prefix, "_acme-challenge."); ok {
+			prefix = p.ChallengeLabel + "." + rest
+		}
+	}
+	if p.ChallengeSuffix != "" {
+		if prefix == "@" {
+			prefix = p.ChallengeSuffix
+		} else {
+			prefix += "." + p.ChallengeSuffix
+		}
+	}
+	return prefix
 }
 
-// doWithRetryForm performs form-urlencoded HTTP requests with backoff for 5xx and 429 statuses.
-func (p *Provider) doWithRetryForm(ctx context.Context, client *http.Client, method, apiURL string, formData url.Values) error {
-	backoff := time.Duration(p.InitialBackoffMillis) * time.Millisecond
+// doWithRetryFormBody performs form-urlencoded HTTP requests with backoff for 5xx and 429
+// statuses and returns the body of the successful response. Maintenance responses are
+// waited out separately and do not count against MaxRetries. It is the sender of p.api.
+func (p *Provider) doWithRetryFormBody(ctx context.Context, method, apiURL string, formData url.Values) ([]byte, error) {
+	backoff := newRetryBackoff(
+		time.Duration(p.InitialBackoffMillis)*time.Millisecond,
+		time.Duration(p.MaxBackoffMillis)*time.Millisecond,
+		p.RetryJitter,
+		time.Duration(p.RetryDeadline),
+	)
+	maintenanceDeadline := time.Now().Add(time.Duration(p.MaxMaintenanceWaitSeconds) * time.Second)
+	endpoint := apiEndpoint(apiURL, formData)
+	ctx = p.debugContext(ctx)
 	for attempt := 0; attempt < p.MaxRetries; attempt++ {
-		req, err := http.NewRequestWithContext(ctx, method, apiURL, strings.NewReader(formData.Encode()))
+		if err := p.waitForMaintenance(ctx, maintenanceDeadline); err != nil {
+			return nil, err
+		}
+		token, err := p.tokens.Pick()
 		if err != nil {
-			return err
+			return nil, err
 		}
-		req.Header.Set("Authorization", "Bearer "+p.Token)
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-		resp, err := client.Do(req)
+		if err := p.limit(ctx, token); err != nil {
+			return nil, err
+		}
+		reqCtx, cancel := context.WithTimeout(ctx, p.requestTimeout(endpoint))
+		reqCtx, span := startSpan(reqCtx, "ipv64.api",
+			attribute.String("endpoint", endpoint), attribute.Int("attempt", attempt+1))
+		req, err := http.NewRequestWithContext(reqCtx, method, apiURL, strings.NewReader(formData.Encode()))
 		if err != nil {
+			endSpan(span, err)
+			cancel()
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		if len(formData) > 0 {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		start := time.Now()
+		resp, err := p.client.Do(req)
+		if err != nil {
+			endSpan(span, err)
+			cancel()
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				// a timeout is a lower bound of the latency
+				p.latency.observe(endpoint, time.Since(start))
+			}
+			p.calls.record(endpoint, 0, err, time.Since(start))
+			p.debugAPICall(ctx, method, apiURL, formData, attempt+1, 0, nil, time.Since(start), err)
 			// Retry on network timeouts and connection errors
-			if strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "connection") {
-				time.Sleep(backoff)
-				backoff *= 2
+			if isRetryableNetError(ctx, err) {
+				sleep := backoff.next()
+				if backoff.exhausted(sleep) {
+					return nil, fmt.Errorf("ipv64 API retry deadline of %s exceeded: %w", time.Duration(p.RetryDeadline), err)
+				}
+				if err := sleepContext(ctx, sleep); err != nil {
+					return nil, err
+				}
 				continue
 			}
-			return err
+			return nil, err
 		}
-		// Properly read and drain response body before closing
-		respBody, _ := io.ReadAll(resp.Body)
+		// Read the bounded response body before closing
+		respBody, truncated, _ := ipv64api.ReadBody(resp.Body)
 		_ = resp.Body.Close()
+		if truncated && p.logger != nil {
+			p.logger.Warn("ipv64: API response truncated",
+				zap.String("endpoint", endpoint),
+				zap.Int("status", resp.StatusCode),
+				zap.Int("limit", ipv64api.MaxResponseSize))
+		}
+		cancel()
+		p.latency.observe(endpoint, time.Since(start))
+		p.calls.record(endpoint, resp.StatusCode, nil, time.Since(start))
+		p.debugAPICall(ctx, method, apiURL, formData, attempt+1, resp.StatusCode, respBody, time.Since(start), nil)
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		if resp.StatusCode >= 400 {
+			endSpan(span, errors.New(resp.Status))
+		} else {
+			endSpan(span, nil)
+		}
 
 		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-			return nil
+			return respBody, nil
+		}
+		if isMaintenanceResponse(resp, respBody) {
+			p.enterMaintenance(resp, respBody)
+			// a known outage must not burn the retry budget
+			attempt--
+			continue
+		}
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			if p.tokens.HasAlternative(token) {
+				p.tokens.MarkRevoked(token)
+				if p.logger != nil {
+					p.logger.Warn("ipv64 API rejected token, failing over to next token",
+						zap.Int("status", resp.StatusCode))
+				}
+				attempt--
+				continue
+			}
+		}
+		retryAfter, hasRetryAfter := p.retryAfter(resp)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			blocked := time.Minute
+			if hasRetryAfter {
+				blocked = retryAfter
+			}
+			p.tokens.MarkRateLimited(token, blocked)
+			if p.tokens.HasAlternative(token) {
+				if p.logger != nil {
+					p.logger.Warn("ipv64 API rate limited, failing over to next token",
+						zap.Int("attempt", attempt+1))
+				}
+				continue
+			}
 		}
 		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
 			if p.logger != nil {
@@ -283,61 +962,100 @@ func (p *Provider) doWithRetryForm(ctx context.Context, client *http.Client, met
 					zap.String("response", string(respBody)),
 					zap.Int("attempt", attempt+1))
 			}
-			time.Sleep(backoff)
-			backoff *= 2
+			if hasRetryAfter {
+				backoff.atLeast(retryAfter)
+			}
+			sleep := backoff.next()
+			if backoff.exhausted(sleep) {
+				return nil, fmt.Errorf("ipv64 API retry deadline of %s exceeded: %s (response: %s)",
+					time.Duration(p.RetryDeadline), resp.Status, string(respBody))
+			}
+			if err := sleepContext(ctx, sleep); err != nil {
+				return nil, err
+			}
 			continue
 		}
-		return fmt.Errorf("ipv64 API error: %s (response: %s)", resp.Status, string(respBody))
+		return nil, &ipv64api.HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(respBody)}
 	}
-	return fmt.Errorf("ipv64 API failed after %d attempts", p.MaxRetries)
+	return nil, fmt.Errorf("ipv64 API failed after %d attempts", p.MaxRetries)
 }
 
-// testDomainExists tests if a domain is managed in the ipv64.net API
-func (p *Provider) testDomainExists(ctx context.Context, domain string) bool {
-	client := &http.Client{Timeout: time.Duration(p.TimeoutSeconds) * time.Second}
-
-	// Use list_records to test if the domain exists
-	formData := url.Values{}
-	formData.Set("list_records", domain)
+// errMaintenance is returned when ipv64 stays in maintenance longer than MaxMaintenanceWaitSeconds.
+var errMaintenance = errors.New("ipv64 API is in maintenance")
 
-	apiURL := "https://ipv64.net/api"
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, strings.NewReader(formData.Encode()))
-	if err != nil {
+// isMaintenanceResponse reports whether resp is a 503 that announces planned maintenance
+// rather than an ordinary server error. Only the body tells them apart; Retry-After is
+// sent with overload errors as well and merely sizes the wait.
+func isMaintenanceResponse(resp *http.Response, body []byte) bool {
+	if resp.StatusCode != http.StatusServiceUnavailable {
 		return false
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Authorization", "Bearer "+p.Token)
+	lower := strings.ToLower(string(body))
+	return strings.Contains(lower, "maintenance") || strings.Contains(lower, "wartung")
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return false
+// enterMaintenance records a maintenance window for all operations of this provider
+// and emits an event when a new window starts.
+func (p *Provider) enterMaintenance(resp *http.Response, body []byte) {
+	wait := time.Duration(p.MaintenanceBackoffSeconds) * time.Second
+	if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok && d > 0 {
+		wait = d
 	}
-	defer resp.Body.Close()
+	until := time.Now().Add(wait)
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return false
+	p.maintenanceMu.Lock()
+	started := time.Now().After(p.maintenanceUntil)
+	if until.After(p.maintenanceUntil) {
+		p.maintenanceUntil = until
 	}
+	p.maintenanceMu.Unlock()
 
-	// If status 200 and not "domain not found", then domain exists
-	if resp.StatusCode == 200 {
-		responseStr := string(respBody)
-		// API responds with error if domain doesn't exist
-		return !strings.Contains(responseStr, "domain not found") &&
-			!strings.Contains(responseStr, "error")
+	if !started {
+		return
+	}
+	if p.logger != nil {
+		p.logger.Warn("ipv64 API in maintenance, backing off",
+			zap.Duration("backoff", wait),
+			zap.String("response", string(body)))
 	}
+	p.events.emit("ipv64.maintenance", map[string]any{
+		"status":  resp.StatusCode,
+		"backoff": wait.String(),
+		"until":   until,
+	})
+}
+
+// waitForMaintenance blocks until any announced maintenance window is over.
+// It gives up with errMaintenance if the window extends past deadline.
+func (p *Provider) waitForMaintenance(ctx context.Context, deadline time.Time) error {
+	p.maintenanceMu.Lock()
+	until := p.maintenanceUntil
+	p.maintenanceMu.Unlock()
 
-	return false
+	wait := time.Until(until)
+	if wait <= 0 {
+		return nil
+	}
+	if until.After(deadline) {
+		return fmt.Errorf("%w (waited up to %ds)", errMaintenance, p.MaxMaintenanceWaitSeconds)
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-// parseDomainList extracts domain names from API response
-func (p *Provider) parseDomainList(response string) []string {
-	// TODO: Analyze API response format and parse accordingly
-	// For now return empty list as fallback
-	return []string{}
+// testDomainExists tests if a domain is managed in the ipv64.net API
+func (p *Provider) testDomainExists(ctx context.Context, domain string) bool {
+	// list_records fails for domains that aren't in the account
+	_, err := p.api.ListRecords(ctx, domain)
+	return err == nil
 }
 
-// deriveManagedZone tries to find the longest matching suffix of fqdn within zone.
+// deriveManagedZone guesses the managed zone of fqdn from the *64.de/*64.net
+// naming pattern. It is only used when the account's domain list is unavailable.
 func (p *Provider) deriveManagedZone(fqdn, zone string) string {
 	fqdn = strings.TrimSuffix(fqdn, ".")
 	zone = strings.TrimSuffix(zone, ".")
@@ -351,7 +1069,8 @@ func (p *Provider) deriveManagedZone(fqdn, zone string) string {
 		return domain
 	}
 
-	// Smart heuristic: Find the managed zone by looking for *64.de/*64.net pattern
+	// Smart heuristic: Find the managed zone by looking for a name directly
+	// below one of the ipv64 base domains (ipv64.de, any64.net, ...).
 	// This works for all ipv64.net-style domains without API calls
 
 	// Remove _acme-challenge prefix if present
@@ -361,63 +1080,63 @@ func (p *Provider) deriveManagedZone(fqdn, zone string) string {
 	}
 
 	parts := strings.Split(workingFqdn, ".")
-	if len(parts) >= 3 {
-		// Look for the *64.de or *64.net pattern from right to left
-		for i := len(parts) - 2; i >= 0; i-- {
-			candidate := strings.Join(parts[i:], ".")
-			candidateParts := strings.Split(candidate, ".")
-
-			// Check if this looks like a root managed domain (username.service64.tld)
-			if len(candidateParts) == 3 {
-				service := candidateParts[1] // e.g., "ipv64", "vpn64", "example64"
-				tld := candidateParts[2]     // e.g., "de", "net"
-
-				// If service ends with "64" and TLD is common, this is likely the managed zone
-				if strings.HasSuffix(service, "64") && (tld == "de" || tld == "net") {
-					return candidate
-				}
-			}
+	// Look for username.<base domain> from right to left
+	for i := len(parts) - 2; i >= 0; i-- {
+		candidate := strings.Join(parts[i:], ".")
+		if p.isRootIpv64Domain(candidate) {
+			return candidate
 		}
 	}
 
 	// Fallback: use the domain as-is
-	return workingFqdn // Generic fallback
-	return zone
+	return workingFqdn
 }
 
-// isIpv64Domain checks if a domain uses any *64.de or *64.net pattern
-func (p *Provider) isIpv64Domain(domain string) bool {
-	domain = strings.TrimSuffix(domain, ".")
+// isBaseDomain reports whether domain is a base domain offered by ipv64.net,
+// below which users register their domains. These are the configured
+// base_domains or, without them, the *64.de/*64.net pattern. The account's
+// domains are not used: a custom domain such as example.com would make its
+// TLD a base domain.
+func (p *Provider) isBaseDomain(domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, b := range p.BaseDomains {
+		if strings.EqualFold(strings.TrimSuffix(b, "."), domain) {
+			return true
+		}
+	}
+	if len(p.BaseDomains) > 0 {
+		return false
+	}
 	parts := strings.Split(domain, ".")
-	if len(parts) < 2 {
+	if len(parts) != 2 {
 		return false
 	}
+	tld := parts[1]     // "de" or "net"
+	service := parts[0] // "ipv64", "any64", etc.
+	return (tld == "de" || tld == "net") && strings.HasSuffix(service, "64")
+}
 
-	// Check for *64.de pattern (e.g., ipv64.de, any64.de, srv64.de)
-	if len(parts) >= 2 {
-		tld := parts[len(parts)-1]     // "de" or "net"
-		service := parts[len(parts)-2] // "ipv64", "any64", etc.
-
-		if (tld == "de" || tld == "net") && strings.HasSuffix(service, "64") {
+// isIpv64Domain checks if a domain is, or is below, an ipv64 base domain
+func (p *Provider) isIpv64Domain(domain string) bool {
+	domain = strings.TrimSuffix(domain, ".")
+	for {
+		if p.isBaseDomain(domain) {
 			return true
 		}
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			return false
+		}
+		domain = parent
 	}
-
-	return false
 }
 
-// isRootIpv64Domain checks if a domain is a root *64.de/*64.net domain (e.g., "username.ipv64.de")
+// isRootIpv64Domain checks if a domain is a root domain directly below an ipv64
+// base domain (e.g., "username.ipv64.de")
 func (p *Provider) isRootIpv64Domain(domain string) bool {
 	domain = strings.TrimSuffix(domain, ".")
-	parts := strings.Split(domain, ".")
-
-	// Root domain should have exactly 3 parts: username.service64.tld
-	if len(parts) != 3 {
-		return false
-	}
-
-	// Check if it matches *64.de or *64.net pattern
-	return p.isIpv64Domain(domain)
+	_, parent, ok := strings.Cut(domain, ".")
+	return ok && p.isBaseDomain(parent)
 }
 
 // isIpv64Subzone checks if a domain looks like an ipv64.net managed subzone (legacy function)
@@ -437,21 +1156,244 @@ func (p *Provider) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	for d.Next() {
 		for d.NextBlock(0) {
 			switch d.Val() {
+			case "mode":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.Mode = d.Val()
 			case "api_token":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				p.Token = d.Val()
+			case "api_tokens":
+				p.Tokens = append(p.Tokens, d.RemainingArgs()...)
+				if len(p.Tokens) == 0 {
+					return d.ArgErr()
+				}
+			case "domain_token":
+				var domain, key string
+				if !d.Args(&domain, &key) {
+					return d.ArgErr()
+				}
+				if p.DomainTokens == nil {
+					p.DomainTokens = make(map[string]string)
+				}
+				p.DomainTokens[domain] = key
+			case "token_budget_per_minute":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var v int
+				if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+					return d.Errf("invalid token_budget_per_minute: %s", d.Val())
+				}
+				p.TokenBudgetPerMinute = v
 			case "domain":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				p.Domain = d.Val()
+			case "endpoint":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.Endpoint = d.Val()
+			case "api_path":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.APIPath = d.Val()
+			case "delete_method":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.DeleteMethod = d.Val()
+			case "request_style":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.RequestStyle = d.Val()
+			case "only_domains", "allowed_domains":
+				for d.NextArg() {
+					p.OnlyDomains = append(p.OnlyDomains, d.Val())
+				}
+				if len(p.OnlyDomains) == 0 {
+					return d.ArgErr()
+				}
+			case "ignore_domains", "denied_domains":
+				for d.NextArg() {
+					p.IgnoreDomains = append(p.IgnoreDomains, d.Val())
+				}
+				if len(p.IgnoreDomains) == 0 {
+					return d.ArgErr()
+				}
+			case "audit_log":
+				a, err := unmarshalAuditLog(d)
+				if err != nil {
+					return err
+				}
+				p.AuditLog = a
+			case "failure_hook":
+				h, err := unmarshalFailureHook(d)
+				if err != nil {
+					return err
+				}
+				p.FailureHook = h
+			case "failure_threshold":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var v int
+				if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v <= 0 {
+					return d.Errf("invalid failure_threshold: %s", d.Val())
+				}
+				p.FailureThreshold = v
+			case "stagger_requests_per_minute":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var v int
+				if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+					return d.Errf("invalid stagger_requests_per_minute: %s", d.Val())
+				}
+				p.StaggerRequestsPerMinute = v
+			case "max_requests_per_minute":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var v int
+				if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+					return d.Errf("invalid max_requests_per_minute: %s", d.Val())
+				}
+				p.MaxRequestsPerMinute = v
+			case "serialize_writes":
+				p.SerializeWrites = true
+			case "debug_api":
+				p.DebugAPI = true
+			case "max_concurrent_requests":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var v int
+				if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+					return d.Errf("invalid max_concurrent_requests: %s", d.Val())
+				}
+				p.MaxConcurrentRequests = v
+			case "challenge_label":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.ChallengeLabel = d.Val()
+			case "challenge_suffix":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.ChallengeSuffix = d.Val()
+			case "zone_depth":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var v int
+				if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+					return d.Errf("invalid zone_depth: %s", d.Val())
+				}
+				p.ZoneDepth = v
+			case "praefix_style":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.PraefixStyle = d.Val()
+			case "base_domains":
+				p.BaseDomains = append(p.BaseDomains, d.RemainingArgs()...)
+				if len(p.BaseDomains) == 0 {
+					return d.ArgErr()
+				}
+			case "allow_any_zone":
+				p.AllowAnyZone = true
+			case "propagation_quorum":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var v int
+				if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+					return d.Errf("invalid propagation_quorum: %s", d.Val())
+				}
+				p.PropagationQuorum = v
+			case "check_authoritative":
+				p.CheckAuthoritative = true
+				p.AuthoritativeNameservers = append(p.AuthoritativeNameservers, d.RemainingArgs()...)
+			case "wait_for_zone_sync":
+				p.WaitForZoneSync = true
+			case "propagation_mode":
+				args := d.RemainingArgs()
+				if len(args) != 2 {
+					return d.ArgErr()
+				}
+				if p.PropagationModes == nil {
+					p.PropagationModes = make(map[string]string)
+				}
+				p.PropagationModes[args[0]] = args[1]
+			case "verify_propagation":
+				p.VerifyPropagation = true
+			case "propagation_timeout", "propagation_interval":
+				opt := d.Val()
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid %s: %v", opt, err)
+				}
+				if opt == "propagation_timeout" {
+					p.PropagationTimeout = caddy.Duration(dur)
+				} else {
+					p.PropagationInterval = caddy.Duration(dur)
+				}
+			case "min_record_lifetime":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid min_record_lifetime: %v", err)
+				}
+				p.MinRecordLifetime = caddy.Duration(dur)
+			case "domains_cache_ttl":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid domains_cache_ttl: %v", err)
+				}
+				p.DomainsCacheTTL = caddy.Duration(dur)
+			case "mail_preset":
+				m, err := unmarshalMailPreset(d)
+				if err != nil {
+					return err
+				}
+				p.MailPresets = append(p.MailPresets, m)
+			case "caa":
+				c, err := unmarshalCAA(d)
+				if err != nil {
+					return err
+				}
+				p.CAA = c
 			case "resolver":
 				// one or many
 				for d.NextArg() {
 					p.Resolvers = append(p.Resolvers, d.Val())
 				}
+			case "max_idle_conns":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var v int
+				if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+					return d.Errf("invalid max_idle_conns: %s", d.Val())
+				}
+				p.MaxIdleConns = v
 			case "timeout_seconds":
 				if !d.NextArg() {
 					return d.ArgErr()
@@ -461,6 +1403,22 @@ func (p *Provider) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.Errf("invalid timeout_seconds: %s", d.Val())
 				}
 				p.TimeoutSeconds = v
+			case "adaptive_timeout":
+				p.AdaptiveTimeout = true
+			case "min_timeout", "max_timeout":
+				opt := d.Val()
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid %s: %v", opt, err)
+				}
+				if opt == "min_timeout" {
+					p.MinTimeout = caddy.Duration(dur)
+				} else {
+					p.MaxTimeout = caddy.Duration(dur)
+				}
 			case "max_retries":
 				if !d.NextArg() {
 					return d.ArgErr()
@@ -479,6 +1437,34 @@ func (p *Provider) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.Errf("invalid initial_backoff_ms: %s", d.Val())
 				}
 				p.InitialBackoffMillis = v
+			case "retry_jitter":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.RetryJitter = d.Val()
+			case "max_backoff_ms":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var v int
+				if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+					return d.Errf("invalid max_backoff_ms: %s", d.Val())
+				}
+				p.MaxBackoffMillis = v
+			case "retry_deadline", "max_retry_after":
+				opt := d.Val()
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid %s: %v", opt, err)
+				}
+				if opt == "retry_deadline" {
+					p.RetryDeadline = caddy.Duration(dur)
+				} else {
+					p.MaxRetryAfter = caddy.Duration(dur)
+				}
 			case "create_delay_seconds":
 				if !d.NextArg() {
 					return d.ArgErr()
@@ -497,6 +1483,33 @@ func (p *Provider) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.Errf("invalid delete_delay_seconds: %s", d.Val())
 				}
 				p.DeleteDelaySeconds = v
+			case "records_cache_seconds":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var v int
+				if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+					return d.Errf("invalid records_cache_seconds: %s", d.Val())
+				}
+				p.RecordsCacheSeconds = v
+			case "maintenance_backoff_seconds":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var v int
+				if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+					return d.Errf("invalid maintenance_backoff_seconds: %s", d.Val())
+				}
+				p.MaintenanceBackoffSeconds = v
+			case "max_maintenance_wait_seconds":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var v int
+				if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+					return d.Errf("invalid max_maintenance_wait_seconds: %s", d.Val())
+				}
+				p.MaxMaintenanceWaitSeconds = v
 			}
 		}
 	}
@@ -507,12 +1520,57 @@ func init() {
 	caddy.RegisterModule(Provider{})
 }
 
-// GetRecords is optional for ACME and returns empty result (not required for issuance).
-func (p *Provider) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
-	return nil, nil
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*Provider)(nil)
+	_ caddy.CleanerUpper    = (*Provider)(nil)
+	_ caddyfile.Unmarshaler = (*Provider)(nil)
+
+	_ libdns.RecordGetter   = (*Provider)(nil)
+	_ libdns.RecordAppender = (*Provider)(nil)
+	_ libdns.RecordDeleter  = (*Provider)(nil)
+	_ libdns.RecordSetter   = (*Provider)(nil)
+)
+
+// GetRecords returns the records of the zone using the list_records API.
+// Results are cached for RecordsCacheSeconds.
+func (p *Provider) GetRecords(ctx context.Context, zone string) (_ []libdns.Record, err error) {
+	defer func() { err = p.redact.Error(err) }()
+	ctx = p.debugContext(ctx)
+	if err := p.checkZoneScope(zone); err != nil {
+		return nil, err
+	}
+	if p.Mode == modeMock {
+		return p.inScope(zone, mockZones.get(zone)), nil
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	zone = normalizeZone(zone)
+	managed, err := p.managedZone(ctx, zone, zone)
+	if err != nil {
+		return nil, err
+	}
+	apiRecs, err := p.listRecords(ctx, managed)
+	if err != nil {
+		return nil, err
+	}
+	var recs []libdns.Record
+	for _, rec := range apiRecs {
+		if r, ok := ipv64.ToLibdns(rec, managed, zone); ok {
+			recs = append(recs, r)
+		}
+	}
+	return p.inScope(zone, recs), nil
 }
 
-// SetRecords is not implemented; ACME flow uses Append/Delete.
+// SetRecords is only implemented in mock mode; the ACME flow uses Append/Delete.
 func (p *Provider) SetRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	if err := p.checkRecordsScope(zone, recs); err != nil {
+		return nil, err
+	}
+	if p.Mode == modeMock {
+		return mockZones.set(zone, recs), nil
+	}
 	return nil, fmt.Errorf("SetRecords not implemented")
 }
@@ -0,0 +1,98 @@
+package caddyipv64
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dnsIPService is a DNS server that answers a special name with the address
+// the query came from.
+type dnsIPService struct {
+	server string // host:port
+	name   string
+	qtype  uint16 // dns.TypeTXT or, depending on the family, A/AAAA
+}
+
+// dnsIPServices are the services of a dns IP source, by name.
+var dnsIPServices = map[string]dnsIPService{
+	"opendns": {server: "resolver1.opendns.com:53", name: "myip.opendns.com."},
+	"google":  {server: "ns1.google.com:53", name: "o-o.myaddr.l.google.com.", qtype: dns.TypeTXT},
+	"akamai":  {server: "ns1-1.akamaitech.net:53", name: "whoami.akamai.net."},
+}
+
+// lookupDNSIP asks the named service for this host's address, over IPv4 and
+// IPv6 separately; the answer is the address the query arrived from.
+func lookupDNSIP(ctx context.Context, service string) (ip4, ip6 netip.Addr, err error) {
+	if service == "" {
+		service = "opendns"
+	}
+	svc, ok := dnsIPServices[service]
+	if !ok {
+		return ip4, ip6, fmt.Errorf("unknown DNS IP service %q", service)
+	}
+	ip4, err4 := svc.query(ctx, "udp4")
+	ip6, err6 := svc.query(ctx, "udp6")
+	if !ip4.Is4() {
+		ip4 = netip.Addr{}
+	}
+	if !ip6.Is6() {
+		ip6 = netip.Addr{}
+	}
+	if !ip4.IsValid() && !ip6.IsValid() {
+		return ip4, ip6, fmt.Errorf("dns %s: %w", service, errors.Join(err4, err6))
+	}
+	return ip4, ip6, nil
+}
+
+// query asks the service over network (udp4 or udp6).
+func (s dnsIPService) query(ctx context.Context, network string) (netip.Addr, error) {
+	qtype := s.qtype
+	if qtype == 0 {
+		qtype = dns.TypeA
+		if network == "udp6" {
+			qtype = dns.TypeAAAA
+		}
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(s.name, qtype)
+	// the services are authoritative servers, not resolvers
+	m.RecursionDesired = false
+	host, port, err := net.SplitHostPort(s.server)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	// the server must be reached over the family being asked about
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, strings.Replace(network, "udp", "ip", 1), host)
+	if err != nil || len(ips) == 0 {
+		return netip.Addr{}, fmt.Errorf("resolving %s: %v", host, err)
+	}
+	c := &dns.Client{Net: network, Timeout: 5 * time.Second}
+	in, _, err := c.ExchangeContext(ctx, m, net.JoinHostPort(ips[0].String(), port))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	for _, rr := range in.Answer {
+		var v string
+		switch rr := rr.(type) {
+		case *dns.A:
+			v = rr.A.String()
+		case *dns.AAAA:
+			v = rr.AAAA.String()
+		case *dns.TXT:
+			v = strings.Join(rr.Txt, "")
+		default:
+			continue
+		}
+		if ip, err := netip.ParseAddr(v); err == nil {
+			return ip.Unmap(), nil
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("no address in the answer of %s", s.server)
+}
@@ -0,0 +1,748 @@
+package caddyipv64
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"go.uber.org/zap"
+
+	"github.com/Sickjuicy/caddy-ipv64/internal/ipv64api"
+)
+
+// dynAuth authenticates DynDNS updates with either a domain-scoped update
+// key or, if there is none, the account API token.
+type dynAuth struct {
+	key          string
+	accountToken string
+}
+
+// dynDNSUpdate calls the DynDNS2 API below endpoint (ipv64.DefaultEndpoint if empty)
+// with the given credentials and parameters (domain, ip, ip6, ...) and returns
+// the parsed response.
+func dynDNSUpdate(ctx context.Context, endpoint string, auth dynAuth, params url.Values) (*ipv64api.DynResult, error) {
+	api := &ipv64api.Client{
+		Endpoint:   endpoint,
+		Token:      auth.accountToken,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	return api.DynUpdate(ctx, auth.key, params)
+}
+
+// domainToken returns the update key in tokens of the longest domain that
+// fqdn is equal to or below, along with that domain.
+func domainToken(tokens map[string]string, fqdn string) (domain, key string) {
+	name := strings.ToLower(strings.TrimSuffix(fqdn, "."))
+	for d, k := range tokens {
+		d = strings.ToLower(strings.TrimSuffix(d, "."))
+		if (name == d || strings.HasSuffix(name, "."+d)) && len(d) > len(domain) {
+			domain, key = d, k
+		}
+	}
+	return domain, key
+}
+
+// DynUpdateOptions are the optional DynDNS2 parameters sent with updates.
+type DynUpdateOptions struct {
+	// Wildcard is "on" or "off" to enable or disable the wildcard record of
+	// the domain; empty leaves it unchanged.
+	Wildcard string `json:"wildcard,omitempty"`
+
+	// MX is the mail exchanger of the domain; BackupMX marks it as backup MX.
+	MX       string `json:"mx,omitempty"`
+	BackupMX bool   `json:"backup_mx,omitempty"`
+
+	// TTL of the updated records in seconds; 0 keeps ipv64's default.
+	TTL int `json:"ttl,omitempty"`
+}
+
+func (o DynUpdateOptions) validate() error {
+	if o.Wildcard != "" && o.Wildcard != "on" && o.Wildcard != "off" {
+		return fmt.Errorf("invalid wildcard %q (must be %q or %q)", o.Wildcard, "on", "off")
+	}
+	if o.TTL < 0 {
+		return fmt.Errorf("invalid ttl %d", o.TTL)
+	}
+	return nil
+}
+
+// apply adds the options to the parameters of an update.
+func (o DynUpdateOptions) apply(params url.Values) {
+	if o.Wildcard != "" {
+		params.Set("wildcard", strings.ToUpper(o.Wildcard))
+	}
+	if o.MX != "" {
+		params.Set("mx", o.MX)
+		if o.BackupMX {
+			params.Set("backmx", "YES")
+		} else {
+			params.Set("backmx", "NO")
+		}
+	}
+	if o.TTL > 0 {
+		params.Set("ttl", strconv.Itoa(o.TTL))
+	}
+}
+
+// unmarshalOption parses the wildcard, mx, backup_mx and ttl Caddyfile
+// options; the dispenser is at the option name.
+func (o *DynUpdateOptions) unmarshalOption(d *caddyfile.Dispenser) error {
+	opt := d.Val()
+	if opt == "backup_mx" {
+		o.BackupMX = true
+		return nil
+	}
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	switch opt {
+	case "wildcard":
+		o.Wildcard = strings.ToLower(d.Val())
+	case "mx":
+		o.MX = d.Val()
+	case "ttl":
+		var v int
+		if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+			return d.Errf("invalid ttl: %s", d.Val())
+		}
+		o.TTL = v
+	}
+	return nil
+}
+
+// logDynResult logs a successful update: changes at info, nochg at debug.
+func logDynResult(logger *zap.Logger, domain string, res *ipv64api.DynResult) {
+	if res.Changed() {
+		logger.Info("ipv64 dynDNS record updated", zap.String("domain", domain), zap.String("ip", res.IP))
+		return
+	}
+	logger.Debug("ipv64 dynDNS record unchanged", zap.String("domain", domain), zap.String("response", res.Raw))
+}
+
+// notifyIPChange remembers the address of domain after an update and, if the
+// update changed it, posts the change to webhook (if set).
+func notifyIPChange(ctx context.Context, logger *zap.Logger, history *ipHistory, webhook, domain string, res *ipv64api.DynResult, params url.Values) {
+	ip := res.IP
+	if ip == "" {
+		ip = params.Get("ip")
+	}
+	if ip == "" {
+		ip = params.Get("ip6")
+	}
+	if ip == "" {
+		return
+	}
+	setCurrentIP(ip)
+	old := history.swap(ctx, domain, ip)
+	if !res.Changed() || webhook == "" {
+		return
+	}
+	change := ipChange{Domain: domain, OldIP: old, NewIP: ip, Timestamp: time.Now().UTC()}
+	if err := postIPChange(ctx, webhook, change); err != nil {
+		logger.Warn("ipv64 dynDNS: IP change webhook failed", zap.String("domain", domain), zap.Error(err))
+	}
+}
+
+// emitIPChanged emits ipv64.ip_changed if the update changed the record.
+func emitIPChanged(events eventEmitter, domain string, res *ipv64api.DynResult) {
+	if !res.Changed() {
+		return
+	}
+	data := map[string]any{"domain": domain}
+	if res.IP != "" {
+		data["ip"] = res.IP
+	}
+	events.emit("ipv64.ip_changed", data)
+}
+
+// dynHealth halts DynDNS updates after a badauth or abuse response: sending
+// more updates with the same key can get the account blocked. The degraded
+// state is kept until the config is reloaded.
+type dynHealth struct {
+	mu     sync.Mutex
+	status ipv64api.DynStatus // return code that halted updates; empty while healthy
+}
+
+// fail records err and reports whether it halted the updates just now.
+func (h *dynHealth) fail(err error) bool {
+	var dynErr *ipv64api.DynError
+	if !errors.As(err, &dynErr) {
+		return false
+	}
+	switch dynErr.Result.Status {
+	case ipv64api.DynBadAuth, ipv64api.DynAbuse:
+	default:
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.status != "" {
+		return false
+	}
+	h.status = dynErr.Result.Status
+	return true
+}
+
+// degraded returns the return code that halted the updates, if any.
+func (h *dynHealth) degraded() (ipv64api.DynStatus, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status, h.status != ""
+}
+
+// errDynHalted is returned for updates after they were halted.
+var errDynHalted = errors.New("ipv64 dynDNS updates halted after badauth/abuse; fix the config and reload")
+
+// haltDynDNS reports updates being halted loudly, since nothing else will
+// happen until someone fixes the config.
+func haltDynDNS(logger *zap.Logger, events eventEmitter, domain string, err error) {
+	logger.Error("ipv64 dynDNS updates halted; fix the update key or wait for the block to end, then reload the config",
+		zap.String("domain", domain), zap.Error(err))
+	var dynErr *ipv64api.DynError
+	errors.As(err, &dynErr)
+	events.emit("ipv64.dyndns_halted", map[string]any{
+		"domain": domain,
+		"status": string(dynErr.Result.Status),
+	})
+}
+
+// updateFailed counts a failed update of domain and emits
+// ipv64.dyndns_failing once FailureThreshold updates failed in a row.
+func (a *DynDNS) updateFailed(domain string, err error) {
+	if a.failures.fail(domain) == a.FailureThreshold {
+		a.events.emit("ipv64.dyndns_failing", map[string]any{
+			"domain":   domain,
+			"failures": a.FailureThreshold,
+			"error":    err.Error(),
+		})
+	}
+}
+
+// updateSucceeded ends the failure streak of domain and emits
+// ipv64.dyndns_recovered if ipv64.dyndns_failing was emitted for it.
+func (a *DynDNS) updateSucceeded(domain string) {
+	if n := a.failures.succeed(domain); n >= a.FailureThreshold {
+		a.events.emit("ipv64.dyndns_recovered", map[string]any{
+			"domain":   domain,
+			"failures": n,
+		})
+	}
+}
+
+// dynRetryBase is the first retry delay after a failed DynDNS update; retries
+// back off up to dynRetryMax or the update interval, whichever is shorter.
+const (
+	dynRetryBase = 5 * time.Second
+	dynRetryMax  = 5 * time.Minute
+)
+
+// dynSchedule spaces out DynDNS updates: the interval with ±10% jitter after
+// successful updates, so many instances don't update in lockstep, and a
+// fast, capped backoff after failures, so DNS recovers soon after a blip.
+type dynSchedule struct {
+	interval time.Duration
+	retry    *retryBackoff // nil while updates succeed
+}
+
+func newDynSchedule(interval time.Duration) *dynSchedule {
+	return &dynSchedule{interval: interval}
+}
+
+// next returns the delay before the next update, given whether the last
+// one succeeded.
+func (s *dynSchedule) next(ok bool) time.Duration {
+	if ok {
+		s.retry = nil
+		spread := s.interval / 5
+		return s.interval - spread/2 + rand.N(spread+1)
+	}
+	if s.retry == nil {
+		s.retry = newRetryBackoff(dynRetryBase, max(min(s.interval, dynRetryMax), dynRetryBase), jitterDecorrelated, 0)
+	}
+	return s.retry.next()
+}
+
+// DynDNS is a Caddy app that keeps ipv64 domains pointed at this server on
+// its own schedule, independent of any site or HTTP traffic.
+//
+//	{
+//	    ipv64_dyndns {
+//	        token {env.IPV64_UPDATE_KEY}
+//	        domains home.example.ipv64.de
+//	        interval 5m
+//	    }
+//	}
+type DynDNS struct {
+	// Token is the ipv64 DynDNS update key.
+	Token string `json:"token,omitempty"`
+
+	// APIToken is the account API token, used for domains that have no
+	// update key of their own when Token is not set.
+	APIToken string `json:"api_token,omitempty"`
+
+	// DomainTokens maps domains to their domain-scoped update keys, which
+	// are preferred over Token and APIToken, so edge nodes don't need the
+	// account token.
+	DomainTokens map[string]string `json:"domain_tokens,omitempty"`
+
+	// Domains are updated one by one on every run.
+	Domains []string `json:"domains,omitempty"`
+
+	// Endpoint overrides the base URL of the ipv64 API (e.g. for a fakeserver).
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Webhook, if set, receives a POST with a JSON object (domain, old_ip,
+	// new_ip, timestamp) whenever an update changes the address of a domain.
+	Webhook string `json:"webhook,omitempty"`
+
+	DynUpdateOptions
+
+	// HeartbeatURL, if set, is an ipv64 healthcheck URL that is pinged after
+	// every round of successful updates, or every HeartbeatInterval if set,
+	// so ipv64 alerts when the updates stop. Pings stop while updates are
+	// halted, and only the leader pings with leader_election.
+	HeartbeatURL      string         `json:"heartbeat_url,omitempty"`
+	HeartbeatInterval caddy.Duration `json:"heartbeat_interval,omitempty"`
+
+	// LeaderElection makes the Caddy instances sharing a storage elect one
+	// leader with a lease in storage; only the leader sends updates. If it
+	// stops renewing the lease, another instance takes over after LeaseTTL
+	// (default 1m).
+	LeaderElection bool           `json:"leader_election,omitempty"`
+	LeaseTTL       caddy.Duration `json:"lease_ttl,omitempty"`
+
+	// Freshness skips the update on start if every domain was updated within
+	// this window to the current address, as remembered in Caddy storage
+	// across restarts (default 0: always update on start).
+	Freshness caddy.Duration `json:"freshness,omitempty"`
+
+	// Interval between updates (default 5m), with ±10% jitter. The first
+	// update runs on start; failed updates are retried sooner.
+	Interval caddy.Duration `json:"interval,omitempty"`
+
+	// PrefixInterface, if set, is the network interface whose IPv6 prefix
+	// of PrefixLength bits (default 64) is sent along with every update, for
+	// ISPs that rotate the delegated prefix.
+	PrefixInterface string `json:"prefix_interface,omitempty"`
+	PrefixLength    int    `json:"prefix_length,omitempty"`
+
+	// IPSources determine the addresses sent with the updates, tried in
+	// order. Without sources, ipv64 uses the address the update comes from.
+	IPSources []IPSource `json:"ip_sources,omitempty"`
+
+	// Interface, if set, is the network interface whose public addresses
+	// are sent with the updates, ahead of any IPSources.
+	Interface string `json:"interface,omitempty"`
+
+	// FailureThreshold is how many updates of a domain must fail in a row
+	// before ipv64.dyndns_failing is emitted (default 3).
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+
+	logger   *zap.Logger
+	events   eventEmitter
+	health   *dynHealth
+	failures *failureStreak
+	ips      *ipHistory
+	lease    *dynLease // nil without leader election
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// CaddyModule returns the Caddy module information.
+func (DynDNS) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "ipv64.dyndns",
+		New: func() caddy.Module { return new(DynDNS) },
+	}
+}
+
+// Provision sets up the app.
+func (a *DynDNS) Provision(ctx caddy.Context) error {
+	secrets := slices.Concat([]string{a.Token, a.APIToken}, slices.Collect(maps.Values(a.DomainTokens)))
+	a.logger = redactLogger(ctx.Logger(a), ipv64api.NewRedactor(secrets...))
+	events, err := newEventEmitter(ctx)
+	if err != nil {
+		return fmt.Errorf("getting events app: %v", err)
+	}
+	a.events = events
+	initMetrics(ctx.GetMetricsRegistry())
+	a.health = new(dynHealth)
+	a.failures = new(failureStreak)
+	a.ips = new(ipHistory)
+	a.IPSources = withInterface(a.Interface, a.IPSources)
+	a.ips.restore(ctx, ctx.Storage(), a.logger, a.Domains)
+	if a.LeaderElection {
+		if a.LeaseTTL <= 0 {
+			a.LeaseTTL = caddy.Duration(time.Minute)
+		}
+		sum := sha256.Sum256([]byte(strings.Join(a.Domains, ",")))
+		a.lease = newDynLease(ctx.Storage(), hex.EncodeToString(sum[:8]), time.Duration(a.LeaseTTL), a.logger)
+	}
+	if a.Interval <= 0 {
+		a.Interval = caddy.Duration(5 * time.Minute)
+	}
+	if a.PrefixLength == 0 {
+		a.PrefixLength = 64
+	}
+	if a.FailureThreshold <= 0 {
+		a.FailureThreshold = 3
+	}
+	return nil
+}
+
+// Validate validates the app config.
+func (a *DynDNS) Validate() error {
+	if len(a.Domains) == 0 {
+		return fmt.Errorf("domains must be set")
+	}
+	for _, domain := range a.Domains {
+		if a.auth(domain) == (dynAuth{}) {
+			return fmt.Errorf("no token, api_token or domain_token for %s", domain)
+		}
+	}
+	if a.PrefixLength < 0 || a.PrefixLength > 128 {
+		return fmt.Errorf("invalid prefix_length %d", a.PrefixLength)
+	}
+	for _, s := range a.IPSources {
+		if err := s.validate(); err != nil {
+			return err
+		}
+	}
+	return a.DynUpdateOptions.validate()
+}
+
+// Start runs the updates in the background.
+func (a *DynDNS) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+	a.done = make(chan struct{})
+	go a.run(ctx)
+	trackLive(live.apps, a, true)
+	return nil
+}
+
+// Stop stops the updates.
+func (a *DynDNS) Stop() error {
+	trackLive(live.apps, a, false)
+	if a.cancel != nil {
+		a.cancel()
+		<-a.done
+	}
+	return nil
+}
+
+func (a *DynDNS) run(ctx context.Context) {
+	defer close(a.done)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	if a.lease != nil {
+		a.lease.renew(ctx)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.lease.run(ctx)
+		}()
+	}
+	if a.HeartbeatURL != "" && a.HeartbeatInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.heartbeat(ctx)
+		}()
+	}
+	sched := newDynSchedule(time.Duration(a.Interval))
+	if startupFresh(ctx, a.ips, a.IPSources, a.Domains, time.Duration(a.Freshness)) {
+		a.logger.Info("ipv64 dynDNS: addresses are fresh, skipping the update on start")
+		if err := sleepContext(ctx, sched.next(true)); err != nil {
+			return
+		}
+	}
+	for {
+		if !a.leading() {
+			// check again soon, to take over quickly if the leader is gone
+			if err := sleepContext(ctx, min(time.Duration(a.LeaseTTL), time.Duration(a.Interval))); err != nil {
+				return
+			}
+			continue
+		}
+		ok := a.updateAll(ctx)
+		if _, halted := a.health.degraded(); halted {
+			return
+		}
+		if ok && a.HeartbeatURL != "" && a.HeartbeatInterval <= 0 {
+			a.ping(ctx)
+		}
+		if err := sleepContext(ctx, sched.next(ok)); err != nil {
+			return
+		}
+	}
+}
+
+// leading reports whether this instance sends the updates: it holds the
+// lease, or there is no leader election.
+func (a *DynDNS) leading() bool {
+	return a.lease == nil || a.lease.isLeader()
+}
+
+// heartbeat pings HeartbeatURL every HeartbeatInterval until ctx is done.
+// Only the leader pings, so the healthcheck fails when no instance updates.
+func (a *DynDNS) heartbeat(ctx context.Context) {
+	for {
+		if _, halted := a.health.degraded(); !halted && a.leading() {
+			a.ping(ctx)
+		}
+		if err := sleepContext(ctx, time.Duration(a.HeartbeatInterval)); err != nil {
+			return
+		}
+	}
+}
+
+func (a *DynDNS) ping(ctx context.Context) {
+	if err := pingHeartbeat(ctx, a.HeartbeatURL); err != nil && ctx.Err() == nil {
+		a.logger.Warn("ipv64 dynDNS: heartbeat failed", zap.Error(err))
+	}
+}
+
+// auth returns the credentials for updating domain.
+func (a *DynDNS) auth(domain string) dynAuth {
+	if _, key := domainToken(a.DomainTokens, domain); key != "" {
+		return dynAuth{key: key}
+	}
+	if a.Token != "" {
+		return dynAuth{key: a.Token}
+	}
+	return dynAuth{accountToken: a.APIToken}
+}
+
+// updateAll updates every domain once and reports whether all updates
+// succeeded.
+func (a *DynDNS) updateAll(ctx context.Context) bool {
+	var prefix string
+	if a.PrefixInterface != "" {
+		p, err := interfacePrefix(a.PrefixInterface, a.PrefixLength)
+		if err != nil {
+			a.logger.Warn("ipv64 dynDNS: reading IPv6 prefix failed",
+				zap.String("interface", a.PrefixInterface), zap.Error(err))
+		} else {
+			prefix = p.String()
+		}
+	}
+	addrs := url.Values{}
+	if len(a.IPSources) > 0 {
+		ip4, ip6, err := lookupPublicIP(ctx, a.IPSources)
+		if err != nil {
+			a.logger.Warn("ipv64 dynDNS: determining public IP failed", zap.Error(err))
+			for _, domain := range a.Domains {
+				observeDynUpdate(domain, nil, err)
+				a.updateFailed(domain, err)
+			}
+			return false
+		}
+		if ip4.IsValid() {
+			addrs.Set("ip", ip4.String())
+		}
+		if ip6.IsValid() {
+			addrs.Set("ip6", ip6.String())
+		}
+	}
+	ok := true
+	for _, domain := range a.Domains {
+		params := url.Values{}
+		params.Set("domain", domain)
+		for k, v := range addrs {
+			params[k] = v
+		}
+		if prefix != "" {
+			params.Set("ip6lanprefix", prefix)
+		}
+		a.DynUpdateOptions.apply(params)
+		res, err := dynDNSUpdate(ctx, a.Endpoint, a.auth(domain), params)
+		observeDynUpdate(domain, res, err)
+		if err != nil {
+			if a.health.fail(err) {
+				haltDynDNS(a.logger, a.events, domain, err)
+				return false
+			}
+			if ctx.Err() == nil {
+				a.logger.Warn("ipv64 dynDNS update failed", zap.String("domain", domain), zap.Error(err))
+				a.updateFailed(domain, err)
+			}
+			ok = false
+			continue
+		}
+		logDynResult(a.logger, domain, res)
+		a.updateSucceeded(domain)
+		emitIPChanged(a.events, domain, res)
+		notifyIPChange(ctx, a.logger, a.ips, a.Webhook, domain, res, params)
+	}
+	return ok
+}
+
+// parseDynDNSOption parses the ipv64_dyndns global option:
+//
+//	ipv64_dyndns {
+//	    token <key>
+//	    api_token <token>
+//	    domain_token <domain> <key>
+//	    domains <domain...>
+//	    endpoint <url>
+//	    webhook <url>
+//	    heartbeat <url> [<interval>]
+//	    interval <duration>
+//	    freshness <duration>
+//	    leader_election [<lease_ttl>]
+//	    wildcard on|off
+//	    mx <host>
+//	    backup_mx
+//	    ttl <seconds>
+//	    prefix_interface <name> [<length>]
+//	    ip_source <type> [<value>]
+//	    interface <name>
+//	    failure_threshold <n>
+//	}
+func parseDynDNSOption(d *caddyfile.Dispenser, _ any) (any, error) {
+	a := new(DynDNS)
+	d.Next() // consume option name
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "token":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			a.Token = d.Val()
+		case "api_token":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			a.APIToken = d.Val()
+		case "domain_token":
+			var domain, key string
+			if !d.Args(&domain, &key) {
+				return nil, d.ArgErr()
+			}
+			if a.DomainTokens == nil {
+				a.DomainTokens = make(map[string]string)
+			}
+			a.DomainTokens[domain] = key
+		case "domains":
+			a.Domains = append(a.Domains, d.RemainingArgs()...)
+			if len(a.Domains) == 0 {
+				return nil, d.ArgErr()
+			}
+		case "endpoint":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			a.Endpoint = d.Val()
+		case "webhook":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			a.Webhook = d.Val()
+		case "leader_election":
+			a.LeaderElection = true
+			if d.NextArg() {
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return nil, d.Errf("invalid lease_ttl: %v", err)
+				}
+				a.LeaseTTL = caddy.Duration(dur)
+			}
+		case "heartbeat":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			a.HeartbeatURL = d.Val()
+			if d.NextArg() {
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return nil, d.Errf("invalid heartbeat interval: %v", err)
+				}
+				a.HeartbeatInterval = caddy.Duration(dur)
+			}
+		case "wildcard", "mx", "backup_mx", "ttl":
+			if err := a.DynUpdateOptions.unmarshalOption(d); err != nil {
+				return nil, err
+			}
+		case "interval", "freshness":
+			opt := d.Val()
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid %s: %v", opt, err)
+			}
+			if opt == "interval" {
+				a.Interval = caddy.Duration(dur)
+			} else {
+				a.Freshness = caddy.Duration(dur)
+			}
+		case "ip_source":
+			s, err := unmarshalIPSource(d)
+			if err != nil {
+				return nil, err
+			}
+			a.IPSources = append(a.IPSources, s)
+		case "interface":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			a.Interface = d.Val()
+		case "failure_threshold":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			var v int
+			if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v <= 0 {
+				return nil, d.Errf("invalid failure_threshold: %s", d.Val())
+			}
+			a.FailureThreshold = v
+		case "prefix_interface":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			a.PrefixInterface = d.Val()
+			if d.NextArg() {
+				var v int
+				if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v <= 0 || v > 128 {
+					return nil, d.Errf("invalid prefix length: %s", d.Val())
+				}
+				a.PrefixLength = v
+			}
+		default:
+			return nil, d.Errf("unrecognized option: %s", d.Val())
+		}
+	}
+	return httpcaddyfile.App{
+		Name:  "ipv64.dyndns",
+		Value: caddyconfig.JSON(a, nil),
+	}, nil
+}
+
+func init() {
+	caddy.RegisterModule(DynDNS{})
+	httpcaddyfile.RegisterGlobalOption("ipv64_dyndns", parseDynDNSOption)
+}
+
+// Interface guards
+var (
+	_ caddy.App         = (*DynDNS)(nil)
+	_ caddy.Provisioner = (*DynDNS)(nil)
+	_ caddy.Validator   = (*DynDNS)(nil)
+)
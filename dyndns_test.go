@@ -0,0 +1,45 @@
+package caddyipv64
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+func TestHeartbeatOnlyFromLeader(t *testing.T) {
+	var pings atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		pings.Add(1)
+	}))
+	defer srv.Close()
+
+	lease := &dynLease{}
+	a := &DynDNS{
+		HeartbeatURL:      srv.URL,
+		HeartbeatInterval: caddy.Duration(5 * time.Millisecond),
+		logger:            zap.NewNop(),
+		health:            new(dynHealth),
+		lease:             lease,
+	}
+	beat := func() int32 {
+		pings.Store(0)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		a.heartbeat(ctx)
+		return pings.Load()
+	}
+
+	if n := beat(); n != 0 {
+		t.Errorf("follower sent %d heartbeats, want none", n)
+	}
+	lease.leader.Store(true)
+	if n := beat(); n == 0 {
+		t.Error("leader sent no heartbeat")
+	}
+}
@@ -0,0 +1,130 @@
+package caddyipv64
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/netip"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// storedIP is the last address pushed for a domain, as kept in Caddy storage.
+type storedIP struct {
+	IP      string    `json:"ip"`
+	Updated time.Time `json:"updated"`
+}
+
+// dynStateStorageKey returns the storage key of the last pushed address of domain.
+func dynStateStorageKey(domain string) string {
+	return path.Join("ipv64", "dyndns", strings.ToLower(strings.TrimSuffix(domain, "."))+".json")
+}
+
+// ipHistory remembers the last address pushed for each domain, to report old
+// and new address of a change and to skip needless updates after a restart.
+// With a storage, it survives restarts.
+type ipHistory struct {
+	mu      sync.Mutex
+	last    map[string]storedIP
+	storage certmagic.Storage
+	logger  *zap.Logger
+}
+
+// restore sets up persistence in storage and loads the stored addresses of domains.
+func (h *ipHistory) restore(ctx context.Context, storage certmagic.Storage, logger *zap.Logger, domains []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.storage, h.logger = storage, logger
+	if h.last == nil {
+		h.last = make(map[string]storedIP)
+	}
+	if storage == nil {
+		return
+	}
+	for _, domain := range domains {
+		data, err := storage.Load(ctx, dynStateStorageKey(domain))
+		if err != nil {
+			continue
+		}
+		var st storedIP
+		if err := json.Unmarshal(data, &st); err != nil {
+			logger.Debug("ipv64 dynDNS: ignoring invalid stored address", zap.String("domain", domain), zap.Error(err))
+			continue
+		}
+		h.last[domain] = st
+	}
+}
+
+// swap records ip as the address of domain and returns the previous one.
+func (h *ipHistory) swap(ctx context.Context, domain, ip string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.last == nil {
+		h.last = make(map[string]storedIP)
+	}
+	old := h.last[domain].IP
+	st := storedIP{IP: ip, Updated: time.Now().UTC()}
+	h.last[domain] = st
+	if h.storage != nil {
+		data, err := json.Marshal(st)
+		if err == nil {
+			err = h.storage.Store(ctx, dynStateStorageKey(domain), data)
+		}
+		if err != nil && h.logger != nil {
+			h.logger.Warn("ipv64 dynDNS: storing last address failed", zap.String("domain", domain), zap.Error(err))
+		}
+	}
+	return old
+}
+
+// snapshot returns the last address pushed for each domain.
+func (h *ipHistory) snapshot() map[string]storedIP {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return maps.Clone(h.last)
+}
+
+// fresh reports whether domain was updated within window and, if current
+// addresses are given, to one of them.
+func (h *ipHistory) fresh(domain string, window time.Duration, current []string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st, ok := h.last[domain]
+	if !ok || window <= 0 || time.Since(st.Updated) > window {
+		return false
+	}
+	return len(current) == 0 || slices.Contains(current, st.IP)
+}
+
+// startupFresh reports whether all domains were updated within window to
+// the current address, so that the update on start can be skipped. Without
+// IP sources, the current address is unknown and only the age counts.
+func startupFresh(ctx context.Context, h *ipHistory, sources []IPSource, domains []string, window time.Duration) bool {
+	if window <= 0 {
+		return false
+	}
+	var current []string
+	if len(sources) > 0 {
+		ip4, ip6, err := lookupPublicIP(ctx, sources)
+		if err != nil {
+			return false
+		}
+		for _, ip := range []netip.Addr{ip4, ip6} {
+			if ip.IsValid() {
+				current = append(current, ip.String())
+			}
+		}
+	}
+	for _, domain := range domains {
+		if !h.fresh(domain, window, current) {
+			return false
+		}
+	}
+	return true
+}
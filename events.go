@@ -0,0 +1,59 @@
+package caddyipv64
+
+import (
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
+)
+
+// eventEmitter emits Caddy events on behalf of one of our modules.
+// The zero value is valid and silently drops events (e.g. outside of Caddy).
+type eventEmitter struct {
+	ctx    caddy.Context
+	events *caddyevents.App
+}
+
+// newEventEmitter looks up the events app for the given module context.
+func newEventEmitter(ctx caddy.Context) (eventEmitter, error) {
+	eventsAppIface, err := ctx.App("events")
+	if err != nil {
+		return eventEmitter{}, err
+	}
+	return eventEmitter{ctx: ctx, events: eventsAppIface.(*caddyevents.App)}, nil
+}
+
+// emit dispatches an event if the events app is available.
+func (e eventEmitter) emit(name string, data map[string]any) {
+	if e.events == nil {
+		return
+	}
+	e.events.Emit(e.ctx, name, data)
+}
+
+// failureStreak counts consecutive failures per key, so an event can be
+// emitted once a streak reaches a threshold instead of on every failure.
+type failureStreak struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// fail counts a failure of key and returns the length of its streak.
+func (s *failureStreak) fail(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[string]int)
+	}
+	s.counts[key]++
+	return s.counts[key]
+}
+
+// succeed ends the streak of key and returns its length.
+func (s *failureStreak) succeed(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.counts[key]
+	delete(s.counts, key)
+	return n
+}
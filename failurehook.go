@@ -0,0 +1,175 @@
+package caddyipv64
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// FailureHook is notified when a record create or delete fails for good,
+// i.e. after all retries and token failovers, so failed renewals can page
+// someone right away.
+type FailureHook struct {
+	// Webhook, if set, receives a POST with a JSON object (operation, name,
+	// zone, prefix, type, error, timestamp).
+	Webhook string `json:"webhook,omitempty"`
+
+	// Command, if set, is run with the same JSON object on stdin and the
+	// fields in IPV64_OPERATION, IPV64_NAME, IPV64_ZONE, IPV64_PREFIX,
+	// IPV64_TYPE and IPV64_ERROR.
+	Command []string `json:"command,omitempty"`
+
+	// Timeout bounds the webhook request and the command (default 30s).
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+}
+
+// recordFailure is the payload passed to failure hooks.
+type recordFailure struct {
+	Operation string    `json:"operation"` // "append" or "delete"
+	Name      string    `json:"name"`
+	Zone      string    `json:"zone,omitempty"` // empty if the managed zone could not be determined
+	Prefix    string    `json:"prefix,omitempty"`
+	Type      string    `json:"type,omitempty"`
+	Error     string    `json:"error"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// recordFailed emits ipv64.record_failed, plus ipv64.provider_failing once
+// FailureThreshold record changes failed in a row, and fires the failure
+// hook for a failed record operation. Hooks run in the background; a nil err
+// or a canceled operation fires nothing.
+func (p *Provider) recordFailed(operation, name, zone, prefix, rtype string, err error) {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	err = p.redact.Error(err)
+	p.events.emit("ipv64.record_failed", map[string]any{
+		"operation": operation,
+		"name":      name,
+		"zone":      zone,
+		"prefix":    prefix,
+		"type":      rtype,
+		"error":     err.Error(),
+	})
+	if p.failures.fail("") == p.FailureThreshold {
+		p.events.emit("ipv64.provider_failing", map[string]any{
+			"failures": p.FailureThreshold,
+			"error":    err.Error(),
+		})
+	}
+	h := p.FailureHook
+	if h == nil {
+		return
+	}
+	f := recordFailure{
+		Operation: operation,
+		Name:      name,
+		Zone:      zone,
+		Prefix:    prefix,
+		Type:      rtype,
+		Error:     err.Error(),
+		Timestamp: time.Now().UTC(),
+	}
+	go func() {
+		timeout := time.Duration(h.Timeout)
+		if timeout <= 0 {
+			timeout = 30 * time.Second
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := h.fire(ctx, f); err != nil && p.logger != nil {
+			p.logger.Warn("ipv64: failure hook failed",
+				zap.String("operation", operation),
+				zap.String("name", name),
+				zap.Error(err))
+		}
+	}()
+}
+
+// recordSucceeded ends the failure streak of the provider and emits
+// ipv64.provider_recovered if ipv64.provider_failing was emitted.
+func (p *Provider) recordSucceeded() {
+	if n := p.failures.succeed(""); n >= p.FailureThreshold {
+		p.events.emit("ipv64.provider_recovered", map[string]any{"failures": n})
+	}
+}
+
+// fire sends f to the webhook and runs the command, if configured.
+func (h *FailureHook) fire(ctx context.Context, f recordFailure) error {
+	var errs []error
+	if h.Webhook != "" {
+		errs = append(errs, postJSON(ctx, h.Webhook, f))
+	}
+	if len(h.Command) > 0 {
+		payload, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+		cmd.Stdin = bytes.NewReader(payload)
+		cmd.Env = append(os.Environ(),
+			"IPV64_OPERATION="+f.Operation,
+			"IPV64_NAME="+f.Name,
+			"IPV64_ZONE="+f.Zone,
+			"IPV64_PREFIX="+f.Prefix,
+			"IPV64_TYPE="+f.Type,
+			"IPV64_ERROR="+f.Error,
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Errorf("command %s: %v: %s", h.Command[0], err, bytes.TrimSpace(out)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// unmarshalFailureHook parses:
+//
+//	failure_hook {
+//	    webhook <url>
+//	    exec <command> [<args...>]
+//	    timeout <duration>
+//	}
+func unmarshalFailureHook(d *caddyfile.Dispenser) (*FailureHook, error) {
+	h := new(FailureHook)
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "webhook":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			h.Webhook = d.Val()
+		case "exec":
+			h.Command = d.RemainingArgs()
+			if len(h.Command) == 0 {
+				return nil, d.ArgErr()
+			}
+		case "timeout":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil || dur <= 0 {
+				return nil, d.Errf("invalid timeout: %s", d.Val())
+			}
+			h.Timeout = caddy.Duration(dur)
+		default:
+			return nil, d.Errf("unrecognized failure_hook option: %s", d.Val())
+		}
+	}
+	if h.Webhook == "" && len(h.Command) == 0 {
+		return nil, d.Err("failure_hook: webhook or exec is required")
+	}
+	return h, nil
+}
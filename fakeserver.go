@@ -0,0 +1,432 @@
+package caddyipv64
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"go.uber.org/zap"
+)
+
+// FakeServer is a Caddy app that emulates the ipv64.net API on a local
+// address: add_record, del_record, list_records, get_domains and nic/update.
+// Point the provider's (or handler's) endpoint at it to exercise complete
+// issuance flows in staging or CI, optionally with injected failures.
+//
+//	{
+//	    ipv64_fakeserver {
+//	        listen 127.0.0.1:6464
+//	        domains example.ipv64.de
+//	        failure_rate 0.2
+//	    }
+//	}
+type FakeServer struct {
+	// Listen is the address to listen on (default 127.0.0.1:6464).
+	Listen string `json:"listen,omitempty"`
+
+	// Token, if set, is the only accepted API token / update key.
+	Token string `json:"token,omitempty"`
+
+	// Domains are the account domains known to the server.
+	Domains []string `json:"domains,omitempty"`
+
+	// Failure injection.
+	FailureRate        float64        `json:"failure_rate,omitempty"` // share of requests answered with 500
+	Latency            caddy.Duration `json:"latency,omitempty"`
+	RateLimitPerMinute int            `json:"rate_limit_per_minute,omitempty"` // 429 above this
+	Maintenance        bool           `json:"maintenance,omitempty"`           // answer everything with 503
+
+	// PageSize, if set, splits get_domains and list_records responses into
+	// pages of that many domains or records.
+	PageSize int `json:"page_size,omitempty"`
+
+	server *http.Server
+	logger *zap.Logger
+
+	mu          *sync.Mutex
+	zones       map[string]*fakeZone
+	nextID      int
+	windowStart time.Time
+	requests    int
+}
+
+type fakeZone struct {
+	IP      string
+	IP6     string
+	Updates int
+	Records []fakeRecord
+}
+
+type fakeRecord struct {
+	ID         int    `json:"record_id"`
+	Content    string `json:"content"`
+	TTL        int    `json:"ttl"`
+	Type       string `json:"type"`
+	Praefix    string `json:"praefix"`
+	LastUpdate string `json:"last_update"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (FakeServer) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "ipv64.fakeserver",
+		New: func() caddy.Module { return new(FakeServer) },
+	}
+}
+
+// Provision sets up the fake server.
+func (f *FakeServer) Provision(ctx caddy.Context) error {
+	f.logger = ctx.Logger(f)
+	if f.Listen == "" {
+		f.Listen = "127.0.0.1:6464"
+	}
+	if f.FailureRate < 0 || f.FailureRate > 1 {
+		return fmt.Errorf("failure_rate must be between 0 and 1")
+	}
+	f.mu = new(sync.Mutex)
+	f.zones = make(map[string]*fakeZone)
+	for _, d := range f.Domains {
+		f.zones[strings.ToLower(strings.TrimSuffix(d, "."))] = &fakeZone{}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api", f.serveAPI)
+	mux.HandleFunc("/api.php", f.serveAPI)
+	mux.HandleFunc("/nic/update", f.serveUpdate)
+	f.server = &http.Server{Handler: f.inject(mux), ReadHeaderTimeout: 10 * time.Second}
+	return nil
+}
+
+// Start starts the app.
+func (f *FakeServer) Start() error {
+	ln, err := net.Listen("tcp", f.Listen)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := f.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			f.logger.Error("fake ipv64 API server stopped", zap.Error(err))
+		}
+	}()
+	f.logger.Warn("fake ipv64 API server listening; not for production use", zap.String("address", ln.Addr().String()))
+	return nil
+}
+
+// Stop stops the app.
+func (f *FakeServer) Stop() error {
+	return f.server.Close()
+}
+
+// inject applies latency, maintenance, rate limits and random failures.
+func (f *FakeServer) inject(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.Latency > 0 {
+			select {
+			case <-time.After(time.Duration(f.Latency)):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if f.Maintenance {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "Wartungsarbeiten / maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		if f.RateLimitPerMinute > 0 {
+			f.mu.Lock()
+			if time.Since(f.windowStart) >= time.Minute {
+				f.windowStart, f.requests = time.Now(), 0
+			}
+			f.requests++
+			limited := f.requests > f.RateLimitPerMinute
+			f.mu.Unlock()
+			if limited {
+				w.Header().Set("Retry-After", "60")
+				writeFakeJSON(w, http.StatusTooManyRequests, map[string]any{"info": "error", "status": "429 Too Many Requests"})
+				return
+			}
+		}
+		if f.FailureRate > 0 && rand.Float64() < f.FailureRate {
+			writeFakeJSON(w, http.StatusInternalServerError, map[string]any{"info": "error", "status": "500 Internal Server Error"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fakeParams merges query and body parameters; unlike r.ParseForm it also
+// reads the body of DELETE requests, which the ipv64 API accepts.
+func fakeParams(r *http.Request) url.Values {
+	params := r.URL.Query()
+	if body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20)); err == nil {
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			for k, v := range form {
+				params[k] = v
+			}
+		}
+	}
+	return params
+}
+
+func (f *FakeServer) authorized(r *http.Request, params url.Values) bool {
+	if f.Token == "" {
+		return true
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ") == f.Token || params.Get("key") == f.Token
+}
+
+func (f *FakeServer) serveAPI(w http.ResponseWriter, r *http.Request) {
+	params := fakeParams(r)
+	if !f.authorized(r, params) {
+		writeFakeJSON(w, http.StatusUnauthorized, map[string]any{"info": "error", "status": "401 Unauthorized"})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case params.Has("get_domains"):
+		names := slices.Sorted(maps.Keys(f.zones))
+		start, end, page, pages := f.page(params, len(names))
+		subdomains := make(map[string]any)
+		for _, name := range names[start:end] {
+			subdomains[name] = fakeZoneJSON(f.zones[name], f.zones[name].Records)
+		}
+		writeFakeJSON(w, http.StatusOK, map[string]any{"subdomains": subdomains, "info": "success", "status": "200 OK", "page": page, "pages": pages})
+
+	case params.Has("list_records"):
+		name := strings.ToLower(params.Get("list_records"))
+		z, ok := f.zones[name]
+		if !ok {
+			writeFakeJSON(w, http.StatusOK, map[string]any{"info": "domain not found", "status": "404 Not Found"})
+			return
+		}
+		start, end, page, pages := f.page(params, len(z.Records))
+		writeFakeJSON(w, http.StatusOK, map[string]any{"subdomains": map[string]any{name: fakeZoneJSON(z, z.Records[start:end])}, "info": "success", "status": "200 OK", "page": page, "pages": pages})
+
+	case params.Has("add_record"):
+		z, ok := f.zones[strings.ToLower(params.Get("add_record"))]
+		if !ok {
+			writeFakeJSON(w, http.StatusOK, map[string]any{"info": "error", "status": "403 Forbidden", "add_record": "domain not found"})
+			return
+		}
+		rtype := strings.ToUpper(params.Get("type"))
+		if rtype == "" || params.Get("content") == "" {
+			writeFakeJSON(w, http.StatusBadRequest, map[string]any{"info": "error", "status": "400 Bad Request", "add_record": "type and content are required"})
+			return
+		}
+		f.nextID++
+		z.Records = append(z.Records, fakeRecord{
+			ID:         f.nextID,
+			Content:    params.Get("content"),
+			TTL:        60,
+			Type:       rtype,
+			Praefix:    params.Get("praefix"),
+			LastUpdate: time.Now().Format(time.DateTime),
+		})
+		writeFakeJSON(w, http.StatusCreated, map[string]any{"info": "success", "status": "201 created", "add_record": "record created"})
+
+	case params.Has("del_record"):
+		z, ok := f.zones[strings.ToLower(params.Get("del_record"))]
+		if !ok {
+			writeFakeJSON(w, http.StatusOK, map[string]any{"info": "error", "status": "403 Forbidden", "del_record": "domain not found"})
+			return
+		}
+		var kept []fakeRecord
+		deleted := 0
+		for _, rec := range z.Records {
+			if fakeRecordMatches(rec, params) {
+				deleted++
+				continue
+			}
+			kept = append(kept, rec)
+		}
+		z.Records = kept
+		if deleted == 0 {
+			writeFakeJSON(w, http.StatusOK, map[string]any{"info": "error", "status": "404 Not Found", "del_record": "record not found"})
+			return
+		}
+		writeFakeJSON(w, http.StatusAccepted, map[string]any{"info": "success", "status": "202 accepted", "del_record": "del record"})
+
+	default:
+		writeFakeJSON(w, http.StatusBadRequest, map[string]any{"info": "error", "status": "400 Bad Request"})
+	}
+}
+
+func fakeRecordMatches(rec fakeRecord, params url.Values) bool {
+	if id := params.Get("record_id"); id != "" {
+		return fmt.Sprint(rec.ID) == id
+	}
+	if rec.Praefix != params.Get("praefix") {
+		return false
+	}
+	if t := params.Get("type"); t != "" && !strings.EqualFold(t, rec.Type) {
+		return false
+	}
+	if c := params.Get("content"); c != "" && c != rec.Content {
+		return false
+	}
+	return true
+}
+
+// page returns the bounds of the requested page of n items, the page and the
+// number of pages. Without PageSize, everything is on the first page.
+func (f *FakeServer) page(params url.Values, n int) (start, end, page, pages int) {
+	size := f.PageSize
+	if size <= 0 {
+		size = max(n, 1)
+	}
+	pages = max(1, (n+size-1)/size)
+	page, _ = strconv.Atoi(params.Get("page"))
+	page = min(max(page, 1), pages)
+	start = min(n, (page-1)*size)
+	return start, min(n, start+size), page, pages
+}
+
+// fakeZoneJSON encodes z with the given records.
+func fakeZoneJSON(z *fakeZone, records []fakeRecord) map[string]any {
+	if records == nil {
+		records = []fakeRecord{}
+	}
+	return map[string]any{"updates": z.Updates, "wildcard": 0, "records": records}
+}
+
+// serveUpdate emulates the DynDNS2 endpoint.
+func (f *FakeServer) serveUpdate(w http.ResponseWriter, r *http.Request) {
+	params := fakeParams(r)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !f.authorized(r, params) {
+		http.Error(w, "badauth", http.StatusUnauthorized)
+		return
+	}
+	ip := params.Get("ip")
+	if ip == "" {
+		ip, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var lines []string
+	for _, name := range strings.Split(params.Get("domain"), ",") {
+		z, ok := f.zones[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			lines = append(lines, "nohost")
+			continue
+		}
+		if z.IP == ip && z.IP6 == params.Get("ip6") {
+			lines = append(lines, "nochg "+ip)
+			continue
+		}
+		z.IP, z.IP6 = ip, params.Get("ip6")
+		z.Updates++
+		lines = append(lines, "good "+ip)
+	}
+	_, _ = io.WriteString(w, strings.Join(lines, "\n"))
+}
+
+func writeFakeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// parseFakeServerOption parses the ipv64_fakeserver global option:
+//
+//	ipv64_fakeserver {
+//	    listen <addr>
+//	    token <token>
+//	    domains <domain...>
+//	    failure_rate <0..1>
+//	    latency <duration>
+//	    rate_limit_per_minute <n>
+//	    maintenance
+//	    page_size <n>
+//	}
+func parseFakeServerOption(d *caddyfile.Dispenser, _ any) (any, error) {
+	f := new(FakeServer)
+	d.Next() // consume option name
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "listen":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			f.Listen = d.Val()
+		case "token":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			f.Token = d.Val()
+		case "domains":
+			f.Domains = append(f.Domains, d.RemainingArgs()...)
+		case "failure_rate":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			if _, err := fmt.Sscanf(d.Val(), "%g", &f.FailureRate); err != nil {
+				return nil, d.Errf("invalid failure_rate: %s", d.Val())
+			}
+		case "latency":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid latency: %v", err)
+			}
+			f.Latency = caddy.Duration(dur)
+		case "rate_limit_per_minute":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			var v int
+			if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+				return nil, d.Errf("invalid rate_limit_per_minute: %s", d.Val())
+			}
+			f.RateLimitPerMinute = v
+		case "maintenance":
+			f.Maintenance = true
+		case "page_size":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			v, err := strconv.Atoi(d.Val())
+			if err != nil || v < 0 {
+				return nil, d.Errf("invalid page_size: %s", d.Val())
+			}
+			f.PageSize = v
+		default:
+			return nil, d.Errf("unrecognized option: %s", d.Val())
+		}
+	}
+	return httpcaddyfile.App{
+		Name:  "ipv64.fakeserver",
+		Value: caddyconfig.JSON(f, nil),
+	}, nil
+}
+
+func init() {
+	caddy.RegisterModule(FakeServer{})
+	httpcaddyfile.RegisterGlobalOption("ipv64_fakeserver", parseFakeServerOption)
+}
+
+// Interface guards
+var (
+	_ caddy.App         = (*FakeServer)(nil)
+	_ caddy.Provisioner = (*FakeServer)(nil)
+)
@@ -0,0 +1,83 @@
+package caddyipv64
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/Sickjuicy/caddy-ipv64/internal/ipv64api"
+)
+
+// newTestFakeServer serves the fake API for domains over HTTP, without
+// provisioning the app.
+func newTestFakeServer(t *testing.T, f *FakeServer, domains ...string) *httptest.Server {
+	t.Helper()
+	f.mu = new(sync.Mutex)
+	f.zones = make(map[string]*fakeZone)
+	for _, d := range domains {
+		f.zones[d] = &fakeZone{}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(f.serveAPI))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPaginatedResponses(t *testing.T) {
+	ctx := context.Background()
+	var domains []string
+	for i := range 7 {
+		domains = append(domains, fmt.Sprintf("d%d.ipv64.de", i))
+	}
+	f := &FakeServer{PageSize: 3}
+	srv := newTestFakeServer(t, f, domains...)
+	client := &ipv64api.Client{Endpoint: srv.URL, APIPath: "/"}
+
+	for i := range 8 {
+		if err := client.AddRecord(ctx, "d0.ipv64.de", fmt.Sprintf("r%d", i), "TXT", "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := client.GetDomains(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(domains) {
+		t.Errorf("get_domains returned %d domains over 3 pages, want %d: %v", len(got), len(domains), got)
+	}
+
+	recs, err := client.ListRecords(ctx, "d0.ipv64.de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, rec := range recs {
+		seen[rec.Praefix] = true
+	}
+	if len(recs) != 8 || len(seen) != 8 {
+		t.Errorf("list_records returned %d records (%d distinct) over 3 pages, want 8", len(recs), len(seen))
+	}
+}
+
+func TestFakeServerPage(t *testing.T) {
+	f := &FakeServer{PageSize: 3}
+	for _, tc := range []struct {
+		page, n                       int
+		start, end, wantPage, wantPgs int
+	}{
+		{1, 7, 0, 3, 1, 3},
+		{3, 7, 6, 7, 3, 3},
+		{9, 7, 6, 7, 3, 3},
+		{1, 0, 0, 0, 1, 1},
+	} {
+		start, end, page, pages := f.page(url.Values{"page": {fmt.Sprint(tc.page)}}, tc.n)
+		if start != tc.start || end != tc.end || page != tc.wantPage || pages != tc.wantPgs {
+			t.Errorf("page %d of %d = [%d:%d] %d/%d, want [%d:%d] %d/%d",
+				tc.page, tc.n, start, end, page, pages, tc.start, tc.end, tc.wantPage, tc.wantPgs)
+		}
+	}
+}
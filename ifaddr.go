@@ -0,0 +1,52 @@
+package caddyipv64
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// interfaceAddrs returns the public unicast addresses of the named network
+// interface; link-local, private and unique local addresses are skipped.
+func interfaceAddrs(name string) ([]netip.Addr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("addresses of %s: %v", name, err)
+	}
+	var public []netip.Addr
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok {
+			continue
+		}
+		ip = ip.Unmap()
+		if ip.IsGlobalUnicast() && !ip.IsPrivate() {
+			public = append(public, ip)
+		}
+	}
+	return public, nil
+}
+
+// interfacePrefix returns the IPv6 prefix of the given length that the first
+// public IPv6 address of the named interface is in, e.g. the /56 or /64
+// delegated by the ISP.
+func interfacePrefix(name string, bits int) (netip.Prefix, error) {
+	addrs, err := interfaceAddrs(name)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	for _, ip := range addrs {
+		if ip.Is6() {
+			return ip.Prefix(bits)
+		}
+	}
+	return netip.Prefix{}, fmt.Errorf("no public IPv6 address on %s", name)
+}
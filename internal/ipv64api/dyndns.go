@@ -0,0 +1,69 @@
+package ipv64api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DynStatus is the return code of a DynDNS2 update.
+type DynStatus string
+
+// DynDNS2 return codes.
+const (
+	DynGood     DynStatus = "good"     // the record was changed
+	DynNoChange DynStatus = "nochg"    // the record already had the address
+	DynBadAuth  DynStatus = "badauth"  // wrong update key
+	DynAbuse    DynStatus = "abuse"    // blocked for too many updates
+	DynNotFQDN  DynStatus = "notfqdn"  // the domain is not a fully qualified name
+	DynNoHost   DynStatus = "nohost"   // the domain doesn't exist in the account
+	DynNumHost  DynStatus = "numhost"  // too many domains in one request
+	DynBadAgent DynStatus = "badagent" // the user agent is blocked
+	DynDNSErr   DynStatus = "dnserr"   // server-side DNS error
+	Dyn911      DynStatus = "911"      // server-side failure, retry later
+)
+
+// DynResult is a parsed DynDNS2 response.
+type DynResult struct {
+	Status DynStatus
+	IP     string // the address reported with good or nochg, if any
+	Raw    string // the trimmed response body
+}
+
+// Changed reports whether the update changed the record.
+func (r *DynResult) Changed() bool {
+	return r.Status == DynGood
+}
+
+// DynError is a DynDNS2 update rejected with an error return code.
+type DynError struct {
+	Result *DynResult
+}
+
+func (e *DynError) Error() string {
+	return fmt.Sprintf("ipv64 dynDNS update failed: %s", e.Result.Raw)
+}
+
+// ParseDynResult parses a DynDNS2 response body. Only the first line is
+// considered when several domains were updated at once. A body that is not a
+// known return code is an error.
+func ParseDynResult(body string) (*DynResult, error) {
+	res := &DynResult{Raw: strings.TrimSpace(body)}
+	line, _, _ := strings.Cut(res.Raw, "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return res, errors.New("ipv64 dynDNS update: empty response")
+	}
+	res.Status = DynStatus(strings.ToLower(fields[0]))
+	switch res.Status {
+	case DynGood, DynNoChange:
+		if len(fields) > 1 {
+			res.IP = fields[1]
+		}
+		return res, nil
+	case DynBadAuth, DynAbuse, DynNotFQDN, DynNoHost, DynNumHost, DynBadAgent, DynDNSErr, Dyn911:
+		return res, &DynError{Result: res}
+	default:
+		return res, fmt.Errorf("ipv64 dynDNS update: unexpected response: %s", res.Raw)
+	}
+}
@@ -0,0 +1,612 @@
+// Package ipv64api is the HTTP client of the ipv64.net API shared by the
+// libdns provider, the Caddy modules and the DynDNS updaters. It builds the
+// requests of the API actions, authenticates them and decodes the responses.
+package ipv64api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultEndpoint is the base URL of the ipv64.net APIs.
+const DefaultEndpoint = "https://ipv64.net"
+
+// MaxResponseSize is the number of bytes read of an API response body; the
+// API's answers are far smaller, so anything longer is e.g. an error page of
+// a misbehaving proxy.
+const MaxResponseSize = 1 << 20
+
+// ReadBody reads at most MaxResponseSize bytes of r. truncated reports
+// whether the body was cut off.
+func ReadBody(r io.Reader) (body []byte, truncated bool, err error) {
+	body, err = io.ReadAll(io.LimitReader(r, MaxResponseSize+1))
+	if len(body) > MaxResponseSize {
+		return body[:MaxResponseSize], true, err
+	}
+	return body, false, err
+}
+
+// Request styles.
+const (
+	StyleForm  = "form"  // parameters in an x-www-form-urlencoded body
+	StyleQuery = "query" // GET requests with the parameters in the query string
+)
+
+// Record is a DNS record as returned by the ipv64 API.
+type Record struct {
+	ID         int    `json:"record_id"`
+	Content    string `json:"content"`
+	TTL        int    `json:"ttl"`
+	Type       string `json:"type"`
+	Praefix    string `json:"praefix"`
+	LastUpdate string `json:"last_update,omitempty"`
+}
+
+// Updated returns the time of LastUpdate, which ipv64 reports without zone
+// in German local time. It reports false if the time is missing or invalid.
+func (r Record) Updated() (time.Time, bool) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		loc = time.UTC
+	}
+	t, err := time.ParseInLocation(time.DateTime, r.LastUpdate, loc)
+	return t, err == nil
+}
+
+// Domain is a domain of the account with its records.
+type Domain struct {
+	Updates  int      `json:"updates"`
+	Wildcard int      `json:"wildcard"`
+	Records  []Record `json:"records"`
+}
+
+// DomainsResponse is the response of get_domains and list_records. Large
+// accounts get the response in pages; Page is the current page (starting
+// at 1) and Pages their number.
+type DomainsResponse struct {
+	Subdomains map[string]Domain `json:"subdomains"`
+	Info       string            `json:"info"`
+	Status     string            `json:"status"`
+	Page       int               `json:"page,omitempty"`
+	Pages      int               `json:"pages,omitempty"`
+}
+
+// maxPages bounds the pages fetched of one response, in case the API keeps
+// announcing more.
+const maxPages = 100
+
+// APIError is a failure reported in the body of an API response, which
+// ipv64 sends with HTTP status 200 (e.g. for an invalid praefix, a cooldown
+// or an exhausted quota).
+type APIError struct {
+	Action  string // API action, e.g. "add_record"
+	Info    string
+	Status  string
+	Message string // the action's message, if any
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("ipv64 %s failed: %s (%s)", e.Action, e.Info, e.Status)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// HTTPError is a non-successful HTTP response of the API.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("ipv64 API error: %s (response: %s)", e.Status, e.Body)
+}
+
+// checkResult returns an *APIError if body reports a failure of action.
+// Bodies that aren't JSON or don't report a result are taken as success.
+func checkResult(action string, body []byte) error {
+	var resp map[string]json.RawMessage
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil
+	}
+	var info, status, message string
+	_ = json.Unmarshal(resp["info"], &info)
+	_ = json.Unmarshal(resp["status"], &status)
+	_ = json.Unmarshal(resp[action], &message)
+	code, _, _ := strings.Cut(status, " ")
+	if (info == "" || strings.EqualFold(info, "success")) && (code == "" || strings.HasPrefix(code, "2")) {
+		return nil
+	}
+	return &APIError{Action: action, Info: info, Status: status, Message: message}
+}
+
+// Client calls the ipv64.net API. The zero value with Token set is ready
+// to use.
+type Client struct {
+	// Endpoint is the base URL of the API (default DefaultEndpoint).
+	Endpoint string
+
+	// Token is the account API token sent as bearer token.
+	Token string
+
+	// Tokens, if set, is used instead of Token: the first usable token is
+	// sent, and tokens rejected with 401/403 or rate limited with 429 are
+	// skipped while another one is left.
+	Tokens *TokenSet
+
+	// HTTPClient is used for requests (default: 30s timeout).
+	HTTPClient *http.Client
+
+	// APIPath is the path of the API below Endpoint, "/api" or "/api.php".
+	// If empty, the working one is detected on first use and after repeated
+	// 404 responses, and remembered for the lifetime of the process.
+	APIPath string
+
+	// RequestStyle is StyleForm (default) or StyleQuery, for middleboxes
+	// that mangle request bodies.
+	RequestStyle string
+
+	// DeleteMethod is the HTTP method of del_record: POST (default), which
+	// passes proxies that drop DELETE requests with a body, or DELETE.
+	DeleteMethod string
+
+	// MaxRetries is how often network errors, 429 and 5xx responses are
+	// retried, with exponential backoff starting at Backoff (default 1s).
+	MaxRetries int
+	Backoff    time.Duration
+
+	// Logger, if set, logs retried requests and the detected API path.
+	Logger *zap.Logger
+
+	// Send, if set, replaces the built-in request handling. It receives the
+	// URL and the form parameters of an API call and returns the body of the
+	// successful response. The Caddy provider plugs in its own retries, token
+	// failover and rate limiting this way.
+	Send func(ctx context.Context, method, apiURL string, params url.Values) ([]byte, error)
+}
+
+// BaseURL returns the endpoint without trailing slash.
+func (c *Client) BaseURL() string {
+	if c.Endpoint == "" {
+		return DefaultEndpoint
+	}
+	return strings.TrimSuffix(c.Endpoint, "/")
+}
+
+// AddRecord creates a record of type rtype with content at praefix below domain.
+func (c *Client) AddRecord(ctx context.Context, domain, praefix, rtype, content string) error {
+	params := url.Values{}
+	params.Set("add_record", domain)
+	params.Set("praefix", praefix)
+	params.Set("type", rtype)
+	params.Set("content", content)
+	body, err := c.call(ctx, http.MethodPost, params)
+	if err != nil {
+		return err
+	}
+	return checkResult("add_record", body)
+}
+
+// DelRecord deletes the record of type rtype with content at praefix below domain.
+func (c *Client) DelRecord(ctx context.Context, domain, praefix, rtype, content string) error {
+	params := url.Values{}
+	params.Set("del_record", domain)
+	params.Set("praefix", praefix)
+	params.Set("type", rtype)
+	params.Set("content", content)
+	body, err := c.call(ctx, c.deleteMethod(), params)
+	if err != nil {
+		return err
+	}
+	return checkResult("del_record", body)
+}
+
+// DelRecordByID deletes the record with the given ID below domain.
+func (c *Client) DelRecordByID(ctx context.Context, domain string, id int) error {
+	params := url.Values{}
+	params.Set("del_record", domain)
+	params.Set("record_id", strconv.Itoa(id))
+	body, err := c.call(ctx, c.deleteMethod(), params)
+	if err != nil {
+		return err
+	}
+	return checkResult("del_record", body)
+}
+
+func (c *Client) deleteMethod() string {
+	if strings.EqualFold(c.DeleteMethod, http.MethodDelete) {
+		return http.MethodDelete
+	}
+	return http.MethodPost
+}
+
+// ListRecords returns the records of domain.
+func (c *Client) ListRecords(ctx context.Context, domain string) ([]Record, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	resp, err := c.fetchPages(ctx, http.MethodPost, "list_records", url.Values{"list_records": {domain}})
+	if err != nil {
+		return nil, fmt.Errorf("list_records %s: %w", domain, err)
+	}
+	var recs []Record
+	for name, d := range resp.Subdomains {
+		if strings.EqualFold(strings.TrimSuffix(name, "."), domain) {
+			recs = append(recs, d.Records...)
+		}
+	}
+	return recs, nil
+}
+
+// GetDomains returns the sorted, lower-cased domains of the account.
+func (c *Client) GetDomains(ctx context.Context) ([]string, error) {
+	resp, err := c.fetchPages(ctx, http.MethodGet, "get_domains", url.Values{"get_domains": {""}})
+	if err != nil {
+		return nil, err
+	}
+	return domainNames(resp), nil
+}
+
+// GetDomainDetails returns the domains of the account, keyed by lower-cased
+// name, with their records.
+func (c *Client) GetDomainDetails(ctx context.Context) (map[string]Domain, error) {
+	resp, err := c.fetchPages(ctx, http.MethodGet, "get_domains", url.Values{"get_domains": {""}})
+	if err != nil {
+		return nil, err
+	}
+	domains := make(map[string]Domain, len(resp.Subdomains))
+	for name, d := range resp.Subdomains {
+		domains[strings.ToLower(strings.TrimSuffix(name, "."))] = d
+	}
+	return domains, nil
+}
+
+// ParseDomains returns the sorted, lower-cased domain names of a single
+// get_domains response body.
+func ParseDomains(body []byte) ([]string, error) {
+	resp, err := parseDomainsResponse("get_domains", body)
+	if err != nil {
+		return nil, err
+	}
+	return domainNames(resp), nil
+}
+
+// fetchPages calls action and merges the subdomains of all pages of the response.
+func (c *Client) fetchPages(ctx context.Context, method, action string, params url.Values) (DomainsResponse, error) {
+	var merged DomainsResponse
+	for page := 1; page <= maxPages; page++ {
+		if page > 1 {
+			params.Set("page", strconv.Itoa(page))
+		}
+		body, err := c.call(ctx, method, params)
+		if err != nil {
+			return DomainsResponse{}, err
+		}
+		resp, err := parseDomainsResponse(action, body)
+		if err != nil {
+			return DomainsResponse{}, err
+		}
+		if page == 1 {
+			merged = resp
+		} else {
+			for name, d := range resp.Subdomains {
+				if prev, ok := merged.Subdomains[name]; ok {
+					prev.Records = append(prev.Records, d.Records...)
+					d = prev
+				}
+				merged.Subdomains[name] = d
+			}
+		}
+		if resp.Pages <= page || len(resp.Subdomains) == 0 {
+			break
+		}
+	}
+	return merged, nil
+}
+
+// parseDomainsResponse decodes one page of a get_domains or list_records response.
+func parseDomainsResponse(action string, body []byte) (DomainsResponse, error) {
+	var resp DomainsResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return resp, fmt.Errorf("decoding %s response: %v", action, err)
+	}
+	if len(resp.Subdomains) == 0 && resp.Info != "success" {
+		if err := checkResult(action, body); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+func domainNames(resp DomainsResponse) []string {
+	domains := make([]string, 0, len(resp.Subdomains))
+	for name := range resp.Subdomains {
+		domains = append(domains, strings.ToLower(strings.TrimSuffix(name, ".")))
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// DynUpdate calls the DynDNS2 API with the given update key and parameters
+// (domain, ip, ip6, ...) and returns the parsed response. With an empty key,
+// the update is authenticated with the account Token instead. Error return codes
+// are returned as *DynError along with the result, also when the API sends
+// them with an HTTP error status.
+func (c *Client) DynUpdate(ctx context.Context, key string, params url.Values) (*DynResult, error) {
+	q := url.Values{}
+	for k, v := range params {
+		q[k] = v
+	}
+	token := c.Token
+	if key != "" {
+		q.Set("key", key)
+		token = ""
+	}
+	tokens := c.Tokens
+	if key != "" {
+		tokens = nil
+	}
+	body, err := c.do(ctx, http.MethodGet, c.BaseURL()+"/nic/update?"+q.Encode(), nil, token, tokens)
+	if err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) {
+			if res, perr := ParseDynResult(httpErr.Body); res.Status != "" && errors.As(perr, new(*DynError)) {
+				return res, perr
+			}
+		}
+		return nil, err
+	}
+	return ParseDynResult(string(body))
+}
+
+// call sends an API action. GET parameters are sent in the query string,
+// all others as form body unless RequestStyle is StyleQuery.
+func (c *Client) call(ctx context.Context, method string, params url.Values) ([]byte, error) {
+	apiURL := c.BaseURL() + c.apiPath(ctx)
+	if c.RequestStyle == StyleQuery {
+		method = http.MethodGet
+	}
+	if method == http.MethodGet {
+		apiURL += "?" + params.Encode()
+		params = url.Values{}
+	}
+	body, err := c.send(ctx, method, apiURL, params)
+	c.trackNotFound(err)
+	return body, err
+}
+
+// send sends a request through Send, or with the built-in retries.
+func (c *Client) send(ctx context.Context, method, apiURL string, params url.Values) ([]byte, error) {
+	if c.Send != nil {
+		return c.Send(ctx, method, apiURL, params)
+	}
+	var form []byte
+	if method != http.MethodGet {
+		form = []byte(params.Encode())
+	}
+	return c.do(ctx, method, apiURL, form, c.Token, c.Tokens)
+}
+
+// do sends a request, retrying it up to MaxRetries times, and returns the
+// body of the successful response. If tokens is set, each attempt picks its
+// token from it. The tokens and the update key in reqURL are masked in errors
+// and log fields.
+func (c *Client) do(ctx context.Context, method, reqURL string, form []byte, token string, tokens *TokenSet) ([]byte, error) {
+	var key string
+	if u, err := url.Parse(reqURL); err == nil {
+		key = u.Query().Get("key")
+	}
+	secrets := []string{c.Token, token, key}
+	if tokens != nil {
+		secrets = append(secrets, tokens.tokens()...)
+	}
+	redact := NewRedactor(secrets...)
+	client := c.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	backoff := c.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	for attempt := 0; ; attempt++ {
+		if tokens != nil {
+			var err error
+			if token, err = tokens.Pick(); err != nil {
+				return nil, err
+			}
+		}
+		var body io.Reader
+		if form != nil {
+			body = strings.NewReader(string(form))
+		}
+		req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+		if err != nil {
+			return nil, redact.Error(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if form != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		resp, err := client.Do(req)
+		var respBody []byte
+		if err == nil {
+			var truncated bool
+			respBody, truncated, err = ReadBody(resp.Body)
+			_ = resp.Body.Close()
+			if truncated && c.Logger != nil {
+				c.Logger.Warn("ipv64: API response truncated",
+					zap.String("url", redact.String(reqURL)), zap.Int("limit", MaxResponseSize))
+			}
+			if err == nil && resp.StatusCode >= 300 {
+				err = &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(respBody))}
+				if tokens != nil && c.failover(tokens, token, resp) {
+					if resp.StatusCode != http.StatusTooManyRequests {
+						// a rejected token does not use up an attempt
+						attempt--
+						continue
+					}
+					if attempt < c.MaxRetries {
+						continue
+					}
+				}
+				if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+					return respBody, redact.Error(err)
+				}
+			}
+		}
+		if err == nil {
+			return respBody, nil
+		}
+		err = redact.Error(err)
+		if attempt >= c.MaxRetries || ctx.Err() != nil {
+			return respBody, err
+		}
+		if c.Logger != nil {
+			c.Logger.Warn("ipv64 API retrying", zap.Int("attempt", attempt+1), zap.Error(err))
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// failover marks token as revoked after a 401/403 response, or as rate
+// limited after a 429 response, and reports whether the request should be
+// sent again right away with another token.
+func (c *Client) failover(tokens *TokenSet, token string, resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if !tokens.HasAlternative(token) {
+			return false
+		}
+		tokens.MarkRevoked(token)
+		if c.Logger != nil {
+			c.Logger.Warn("ipv64 API rejected token, failing over to next token", zap.Int("status", resp.StatusCode))
+		}
+		return true
+	case http.StatusTooManyRequests:
+		wait := time.Minute
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+			wait = time.Duration(s) * time.Second
+		}
+		tokens.MarkRateLimited(token, wait)
+		if !tokens.HasAlternative(token) {
+			return false
+		}
+		if c.Logger != nil {
+			c.Logger.Warn("ipv64 API rate limited, failing over to next token")
+		}
+		return true
+	}
+	return false
+}
+
+// apiPaths are the detected API paths by base URL.
+var (
+	apiPathsMu sync.Mutex
+	apiPaths   = make(map[string]*detectedPath)
+)
+
+type detectedPath struct {
+	path     string
+	notFound int       // consecutive 404 responses since detection
+	retryAt  time.Time // if set, no path answered; probe again after it
+}
+
+// apiPathCandidates are probed in order; the first is the fallback.
+var apiPathCandidates = []string{"/api", "/api.php"}
+
+// notFoundLimit is the number of consecutive 404s that trigger a new detection.
+const notFoundLimit = 3
+
+// probeRetryInterval is how long the fallback path is used when no candidate
+// answered, before probing again.
+const probeRetryInterval = time.Minute
+
+// apiPath returns APIPath, or the detected path of the endpoint.
+func (c *Client) apiPath(ctx context.Context) string {
+	if c.APIPath != "" {
+		return c.APIPath
+	}
+	base := c.BaseURL()
+	apiPathsMu.Lock()
+	d, ok := apiPaths[base]
+	apiPathsMu.Unlock()
+	if ok && (d.retryAt.IsZero() || time.Now().Before(d.retryAt)) {
+		return d.path
+	}
+
+	path, found := c.probe(ctx)
+	if !found && ctx.Err() != nil {
+		return path
+	}
+	d = &detectedPath{path: path}
+	if !found {
+		d.retryAt = time.Now().Add(probeRetryInterval)
+	}
+	apiPathsMu.Lock()
+	apiPaths[base] = d
+	apiPathsMu.Unlock()
+	if found && c.Logger != nil {
+		c.Logger.Info("ipv64: selected API endpoint", zap.String("url", base+path))
+	}
+	return path
+}
+
+// probe returns the first API path that doesn't answer get_domains with 404.
+// Probes are sent like API calls, so they are retried and rate limited.
+func (c *Client) probe(ctx context.Context) (string, bool) {
+	for _, path := range apiPathCandidates {
+		_, err := c.send(ctx, http.MethodGet, c.BaseURL()+path+"?get_domains", url.Values{})
+		var httpErr *HTTPError
+		if err == nil || errors.As(err, &httpErr) && httpErr.StatusCode != http.StatusNotFound {
+			return path, true
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return apiPathCandidates[0], false
+}
+
+// trackNotFound forgets the detected path after notFoundLimit consecutive
+// 404 responses, so that it is detected again.
+func (c *Client) trackNotFound(err error) {
+	if c.APIPath != "" {
+		return
+	}
+	var httpErr *HTTPError
+	notFound := errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound
+	apiPathsMu.Lock()
+	defer apiPathsMu.Unlock()
+	d, ok := apiPaths[c.BaseURL()]
+	if !ok {
+		return
+	}
+	if !notFound {
+		d.notFound = 0
+		return
+	}
+	d.notFound++
+	if d.notFound >= notFoundLimit {
+		delete(apiPaths, c.BaseURL())
+	}
+}
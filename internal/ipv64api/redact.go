@@ -0,0 +1,79 @@
+package ipv64api
+
+import (
+	"cmp"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// MaskSecret hides all but the last four characters of secret.
+func MaskSecret(secret string) string {
+	if len(secret) <= 4 {
+		return "****"
+	}
+	return "****" + secret[len(secret)-4:]
+}
+
+// Redactor masks secrets, such as API tokens and update keys, in strings
+// and errors. The zero value and a nil *Redactor leave everything unchanged.
+type Redactor struct {
+	replacer *strings.Replacer
+}
+
+// NewRedactor returns a Redactor for the given secrets; empty ones are
+// ignored. Secrets are also matched in their URL-encoded form.
+func NewRedactor(secrets ...string) *Redactor {
+	var forms []string
+	for _, s := range secrets {
+		if s == "" {
+			continue
+		}
+		forms = append(forms, s)
+		if e := url.QueryEscape(s); e != s {
+			forms = append(forms, e)
+		}
+	}
+	if len(forms) == 0 {
+		return &Redactor{}
+	}
+	// longest first, so a secret containing another one is masked as a whole
+	slices.SortFunc(forms, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), strings.Compare(a, b))
+	})
+	forms = slices.Compact(forms)
+	var oldnew []string
+	for _, s := range forms {
+		oldnew = append(oldnew, s, MaskSecret(s))
+	}
+	return &Redactor{replacer: strings.NewReplacer(oldnew...)}
+}
+
+// String returns s with all secrets masked.
+func (r *Redactor) String(s string) string {
+	if r == nil || r.replacer == nil {
+		return s
+	}
+	return r.replacer.Replace(s)
+}
+
+// Error returns err with all secrets masked in its message. The original
+// error is still reachable with errors.Is and errors.As.
+func (r *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := r.String(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{msg: msg, err: err}
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
@@ -0,0 +1,155 @@
+package ipv64api
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNoUsableToken is returned when every configured API token was revoked.
+var ErrNoUsableToken = errors.New("no usable ipv64 API token (all revoked or rejected)")
+
+// tokenState tracks the health and budget of a single API token.
+type tokenState struct {
+	token        string
+	blockedUntil time.Time // rate limited until
+	revoked      bool      // rejected with 401/403
+	windowStart  time.Time // start of the current budget minute
+	used         int       // requests in the current budget minute
+	rateLimited  int       // total 429 responses seen
+}
+
+// TokenSet implements ordered failover between API tokens: the first token
+// that is neither rate limited, revoked, nor out of budget is used. It is
+// shared by the Caddy module and Client.Tokens.
+type TokenSet struct {
+	mu     sync.Mutex
+	states []*tokenState
+	budget int // requests per minute and token, 0 = unlimited
+}
+
+// NewTokenSet returns a set of tokens in failover order, with budget
+// requests per minute and token (0 = unlimited). Empty and duplicate tokens
+// are skipped.
+func NewTokenSet(tokens []string, budget int) *TokenSet {
+	ts := &TokenSet{budget: budget}
+	seen := make(map[string]bool)
+	for _, t := range tokens {
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		ts.states = append(ts.states, &tokenState{token: t})
+	}
+	return ts
+}
+
+// Pick returns the token to use for the next request and accounts it
+// against the token's budget. If all tokens are temporarily unavailable, the
+// one that becomes available first is returned.
+func (ts *TokenSet) Pick() (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	now := time.Now()
+	var soonest *tokenState
+	for _, st := range ts.states {
+		if st.revoked {
+			continue
+		}
+		if now.Sub(st.windowStart) >= time.Minute {
+			st.windowStart, st.used = now, 0
+		}
+		available := now.After(st.blockedUntil) && (ts.budget <= 0 || st.used < ts.budget)
+		if available {
+			st.used++
+			return st.token, nil
+		}
+		if soonest == nil || st.blockedUntil.Before(soonest.blockedUntil) {
+			soonest = st
+		}
+	}
+	if soonest == nil {
+		return "", ErrNoUsableToken
+	}
+	soonest.used++
+	return soonest.token, nil
+}
+
+// MarkRateLimited blocks token for wait.
+func (ts *TokenSet) MarkRateLimited(token string, wait time.Duration) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, st := range ts.states {
+		if st.token == token {
+			st.blockedUntil = time.Now().Add(wait)
+			st.rateLimited++
+		}
+	}
+}
+
+// MarkRevoked disables token for the lifetime of the set.
+func (ts *TokenSet) MarkRevoked(token string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, st := range ts.states {
+		if st.token == token {
+			st.revoked = true
+		}
+	}
+}
+
+// HasAlternative reports whether another non-revoked token than token exists.
+func (ts *TokenSet) HasAlternative(token string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, st := range ts.states {
+		if st.token != token && !st.revoked {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenStatus is the state of one API token, e.g. for the admin API.
+type TokenStatus struct {
+	Token        string     `json:"token"` // masked
+	Revoked      bool       `json:"revoked,omitempty"`
+	BlockedUntil *time.Time `json:"blocked_until,omitempty"`
+	RateLimited  int        `json:"rate_limited,omitempty"`
+	Used         int        `json:"used_this_minute"`
+}
+
+// Status returns the state of all tokens, in failover order.
+func (ts *TokenSet) Status() []TokenStatus {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	now := time.Now()
+	list := make([]TokenStatus, 0, len(ts.states))
+	for _, st := range ts.states {
+		s := TokenStatus{
+			Token:       MaskSecret(st.token),
+			Revoked:     st.revoked,
+			RateLimited: st.rateLimited,
+		}
+		if st.blockedUntil.After(now) {
+			t := st.blockedUntil
+			s.BlockedUntil = &t
+		}
+		if now.Sub(st.windowStart) < time.Minute {
+			s.Used = st.used
+		}
+		list = append(list, s)
+	}
+	return list
+}
+
+// tokens returns all tokens of the set, for redaction.
+func (ts *TokenSet) tokens() []string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	list := make([]string, 0, len(ts.states))
+	for _, st := range ts.states {
+		list = append(list, st.token)
+	}
+	return list
+}
@@ -0,0 +1,147 @@
+// Package ipv64 implements the libdns interfaces for ipv64.net DNS hosting.
+// It has no dependencies on Caddy and can be used from any Go program. The
+// Caddy module dns.providers.ipv64 shares its API client, token failover,
+// record conversion, zone matching and challenge bookkeeping, and implements
+// the record operations itself to add rate limiting, maintenance handling and
+// read-back verification.
+package ipv64
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+
+	"github.com/Sickjuicy/caddy-ipv64/internal/ipv64api"
+)
+
+// DefaultEndpoint is the base URL of the ipv64.net APIs.
+const DefaultEndpoint = ipv64api.DefaultEndpoint
+
+// Record is a DNS record as returned by the ipv64 API.
+type Record = ipv64api.Record
+
+// Domain is a domain of the account with its records.
+type Domain = ipv64api.Domain
+
+// DomainsResponse is the response of get_domains and list_records.
+type DomainsResponse = ipv64api.DomainsResponse
+
+// ParseDomains returns the sorted, lower-cased domain names of a get_domains
+// response body.
+func ParseDomains(body []byte) ([]string, error) {
+	return ipv64api.ParseDomains(body)
+}
+
+// supportedTypes are the record types ipv64 manages.
+var supportedTypes = map[string]bool{
+	"A": true, "AAAA": true, "CNAME": true, "MX": true,
+	"NS": true, "SRV": true, "CAA": true, "TXT": true,
+}
+
+// RecordType returns the upper-cased type of rr, defaulting to TXT.
+func RecordType(rr libdns.RR) string {
+	if rr.Type == "" {
+		return "TXT"
+	}
+	return strings.ToUpper(rr.Type)
+}
+
+// Typed returns the concrete libdns type for rr (libdns.TXT, libdns.Address,
+// libdns.MX, ...), falling back to rr itself if it does not parse.
+func Typed(rr libdns.RR) libdns.Record {
+	parsed, err := rr.Parse()
+	if err != nil {
+		return rr
+	}
+	return parsed
+}
+
+// Content formats the data of rr as the ipv64 API expects it in "content":
+// host names without trailing dot and priorities/weights/ports first.
+func Content(rr libdns.RR) (string, error) {
+	rtype := RecordType(rr)
+	if !supportedTypes[rtype] {
+		return "", fmt.Errorf("record type %s is not supported by ipv64", rtype)
+	}
+	rr.Type = rtype
+	parsed, err := rr.Parse()
+	if err != nil {
+		return "", fmt.Errorf("invalid %s record %s: %v", rtype, rr.Name, err)
+	}
+	switch rec := parsed.(type) {
+	case libdns.Address:
+		return rec.IP.String(), nil
+	case libdns.CNAME:
+		return strings.TrimSuffix(rec.Target, "."), nil
+	case libdns.NS:
+		return strings.TrimSuffix(rec.Target, "."), nil
+	case libdns.MX:
+		return fmt.Sprintf("%d %s", rec.Preference, strings.TrimSuffix(rec.Target, ".")), nil
+	case libdns.SRV:
+		return fmt.Sprintf("%d %d %d %s", rec.Priority, rec.Weight, rec.Port, strings.TrimSuffix(rec.Target, ".")), nil
+	case libdns.CAA:
+		return fmt.Sprintf("%d %s %q", rec.Flags, rec.Tag, rec.Value), nil
+	case libdns.TXT:
+		return rec.Text, nil
+	}
+	return rr.Data, nil
+}
+
+// Prefix returns the "praefix" of fqdn below the managed domain: "@" for the
+// domain itself, the relative name for names below it and the first label
+// otherwise.
+func Prefix(fqdn, managed string) string {
+	fqdn = strings.TrimSuffix(fqdn, ".")
+	managed = strings.TrimSuffix(managed, ".")
+	if strings.EqualFold(fqdn, managed) {
+		return "@"
+	}
+	if inDomain(fqdn, managed) {
+		return fqdn[:len(fqdn)-len(managed)-1]
+	}
+	return strings.Split(fqdn, ".")[0]
+}
+
+// ManagedZone returns the longest of domains that fqdn lies in, or "" if
+// there is none.
+func ManagedZone(fqdn string, domains []string) string {
+	var best string
+	for _, d := range domains {
+		d = strings.TrimSuffix(d, ".")
+		if inDomain(fqdn, d) && len(d) > len(best) {
+			best = d
+		}
+	}
+	return best
+}
+
+// ToLibdns converts an API record of managed into a libdns record relative
+// to zone, with the record ID as ProviderData. ok is false if the record lies
+// outside of zone.
+func ToLibdns(rec Record, managed, zone string) (libdns.Record, bool) {
+	fqdn := strings.TrimSuffix(managed, ".")
+	if rec.Praefix != "" && rec.Praefix != "@" {
+		fqdn = rec.Praefix + "." + fqdn
+	}
+	if !inDomain(fqdn, zone) {
+		return nil, false
+	}
+	if !strings.HasSuffix(zone, ".") {
+		zone += "."
+	}
+	return WithID(Typed(libdns.RR{
+		Name: libdns.RelativeName(fqdn+".", zone),
+		TTL:  time.Duration(rec.TTL) * time.Second,
+		Type: strings.ToUpper(rec.Type),
+		Data: rec.Content,
+	}), rec.ID), true
+}
+
+// inDomain reports whether name equals domain or is a subdomain of it.
+func inDomain(name, domain string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
@@ -0,0 +1,30 @@
+package ipv64
+
+import "testing"
+
+func TestManagedZone(t *testing.T) {
+	domains := []string{"example.ipv64.de", "sub.example.ipv64.de.", "other.any64.de"}
+	for _, tc := range []struct {
+		fqdn string
+		want string
+	}{
+		{"_acme-challenge.www.example.ipv64.de.", "example.ipv64.de"},
+		{"_acme-challenge.sub.example.ipv64.de.", "sub.example.ipv64.de"},
+		{"a.b.sub.example.ipv64.de", "sub.example.ipv64.de"},
+		{"example.ipv64.de", "example.ipv64.de"},
+		{"WWW.Example.IPv64.de.", "example.ipv64.de"},
+		{"notexample.ipv64.de", ""},
+		{"ipv64.de", ""},
+		{"www.example.com.", ""},
+	} {
+		if got := ManagedZone(tc.fqdn, domains); got != tc.want {
+			t.Errorf("ManagedZone(%q) = %q, want %q", tc.fqdn, got, tc.want)
+		}
+	}
+}
+
+func TestManagedZoneNoDomains(t *testing.T) {
+	if got := ManagedZone("www.example.ipv64.de", nil); got != "" {
+		t.Errorf("ManagedZone without domains = %q, want \"\"", got)
+	}
+}
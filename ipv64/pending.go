@@ -0,0 +1,173 @@
+package ipv64
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PendingRecord is a record created by AppendRecords, with the managed zone
+// and praefix used at creation time. Refs counts the in-flight challenges
+// that asked for this exact value; the record is deleted when the last one
+// is done.
+type PendingRecord struct {
+	ID      int // ipv64 record ID, 0 if unknown
+	Refs    int
+	Managed string
+	Prefix  string
+	Type    string
+	Value   string
+	Created time.Time
+}
+
+// Pending tracks challenge records that were created but not yet deleted,
+// so that concurrent challenges for the same name and value share one
+// record. Only _acme-challenge TXT records are tracked; other records pass
+// through, so that an A record appended twice is not reported deleted while
+// one copy stays live. The zero value is ready to use; a nil *Pending tracks
+// nothing.
+type Pending struct {
+	mu       sync.Mutex
+	records  map[string]PendingRecord
+	inflight map[string]chan struct{} // keys being created, closed once added or abandoned
+}
+
+// challengeRecord reports whether fqdn and rtype are those of an ACME
+// DNS-01 challenge record, the only records Pending tracks.
+func challengeRecord(fqdn, rtype string) bool {
+	label, _, _ := strings.Cut(fqdn, ".")
+	return strings.EqualFold(rtype, "TXT") && strings.EqualFold(label, "_acme-challenge")
+}
+
+func pendingKey(fqdn, rtype, value string) string {
+	return strings.ToLower(strings.TrimSuffix(fqdn, ".")) + "|" + rtype + "|" + value
+}
+
+// Add registers the record created for fqdn, after a successful Reserve.
+func (r *Pending) Add(fqdn string, rec PendingRecord) {
+	if r == nil || !challengeRecord(fqdn, rec.Type) {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rec.Refs == 0 {
+		rec.Refs = 1
+	}
+	if r.records == nil {
+		r.records = make(map[string]PendingRecord)
+	}
+	key := pendingKey(fqdn, rec.Type, rec.Value)
+	r.records[key] = rec
+	r.finish(key)
+}
+
+// Reserve adds a reference to an existing pending record. If there is none,
+// it reserves the key and reports true, in which case the caller has to
+// create the record and then Add or Abandon it. Callers asking for a key
+// that is being created wait for the creator. Records other than
+// challenges are always created.
+func (r *Pending) Reserve(ctx context.Context, fqdn, rtype, value string) (PendingRecord, bool, error) {
+	if r == nil || !challengeRecord(fqdn, rtype) {
+		return PendingRecord{}, true, nil
+	}
+	key := pendingKey(fqdn, rtype, value)
+	for {
+		r.mu.Lock()
+		if rec, ok := r.records[key]; ok {
+			rec.Refs++
+			r.records[key] = rec
+			r.mu.Unlock()
+			return rec, false, nil
+		}
+		wait, ok := r.inflight[key]
+		if !ok {
+			if r.inflight == nil {
+				r.inflight = make(map[string]chan struct{})
+			}
+			r.inflight[key] = make(chan struct{})
+			r.mu.Unlock()
+			return PendingRecord{}, true, nil
+		}
+		r.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return PendingRecord{}, false, ctx.Err()
+		}
+	}
+}
+
+// Abandon drops the reservation of a record that could not be created; a
+// waiting caller then tries to create it itself.
+func (r *Pending) Abandon(fqdn, rtype, value string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finish(pendingKey(fqdn, rtype, value))
+}
+
+// finish releases the callers waiting for key. r.mu must be held.
+func (r *Pending) finish(key string) {
+	if wait, ok := r.inflight[key]; ok {
+		close(wait)
+		delete(r.inflight, key)
+	}
+}
+
+// Release drops a reference to a pending record and reports whether other
+// challenges still use it, in which case the record must be kept.
+func (r *Pending) Release(fqdn, rtype, value string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := pendingKey(fqdn, rtype, value)
+	rec, ok := r.records[key]
+	if !ok || rec.Refs <= 1 {
+		return false
+	}
+	rec.Refs--
+	r.records[key] = rec
+	return true
+}
+
+// Get returns the pending record for fqdn, rtype and value.
+func (r *Pending) Get(fqdn, rtype, value string) (PendingRecord, bool) {
+	if r == nil {
+		return PendingRecord{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.records[pendingKey(fqdn, rtype, value)]
+	return rec, ok
+}
+
+// Remove forgets the pending record once it was deleted.
+func (r *Pending) Remove(fqdn, rtype, value string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.records, pendingKey(fqdn, rtype, value))
+}
+
+// List returns the pending records, oldest first.
+func (r *Pending) List() []PendingRecord {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	recs := make([]PendingRecord, 0, len(r.records))
+	for _, rec := range r.records {
+		recs = append(recs, rec)
+	}
+	slices.SortFunc(recs, func(a, b PendingRecord) int { return a.Created.Compare(b.Created) })
+	return recs
+}
@@ -0,0 +1,126 @@
+package ipv64
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPendingRefcount(t *testing.T) {
+	ctx := context.Background()
+	r := new(Pending)
+	const fqdn, rtype, value = "_acme-challenge.example.ipv64.de.", "TXT", "token"
+
+	if _, create, err := r.Reserve(ctx, fqdn, rtype, value); err != nil || !create {
+		t.Fatalf("first reserve = %v, %v; want create", create, err)
+	}
+	r.Add(fqdn, PendingRecord{ID: 7, Type: rtype, Value: value})
+
+	rec, create, err := r.Reserve(ctx, "_ACME-challenge.example.ipv64.de", rtype, value)
+	if err != nil || create {
+		t.Fatalf("second reserve = %v, %v; want shared record", create, err)
+	}
+	if rec.ID != 7 || rec.Refs != 2 {
+		t.Errorf("shared record = %+v, want ID 7 with 2 refs", rec)
+	}
+
+	if !r.Release(fqdn, rtype, value) {
+		t.Fatal("first release must keep the record for the other challenge")
+	}
+	if r.Release(fqdn, rtype, value) {
+		t.Fatal("last release must let the record be deleted")
+	}
+	if _, ok := r.Get(fqdn, rtype, value); !ok {
+		t.Fatal("record must stay registered until it is removed")
+	}
+	r.Remove(fqdn, rtype, value)
+	if _, ok := r.Get(fqdn, rtype, value); ok {
+		t.Fatal("record still registered after remove")
+	}
+}
+
+func TestPendingReserveConcurrent(t *testing.T) {
+	ctx := context.Background()
+	r := new(Pending)
+	const fqdn, rtype, value = "_acme-challenge.example.ipv64.de.", "TXT", "token"
+
+	var creators atomic.Int32
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, create, err := r.Reserve(ctx, fqdn, rtype, value)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if create {
+				creators.Add(1)
+				time.Sleep(10 * time.Millisecond) // the API call
+				r.Add(fqdn, PendingRecord{ID: 1, Type: rtype, Value: value})
+			}
+		}()
+	}
+	wg.Wait()
+	if n := creators.Load(); n != 1 {
+		t.Fatalf("%d callers created the record, want 1", n)
+	}
+	if rec, _ := r.Get(fqdn, rtype, value); rec.Refs != 10 {
+		t.Errorf("refs = %d, want 10", rec.Refs)
+	}
+}
+
+func TestPendingReserveAbandoned(t *testing.T) {
+	ctx := context.Background()
+	r := new(Pending)
+	const fqdn, rtype, value = "_acme-challenge.example.ipv64.de.", "TXT", "token"
+
+	if _, create, _ := r.Reserve(ctx, fqdn, rtype, value); !create {
+		t.Fatal("first reserve must create")
+	}
+	done := make(chan bool)
+	go func() {
+		_, create, _ := r.Reserve(ctx, fqdn, rtype, value)
+		done <- create
+	}()
+	select {
+	case <-done:
+		t.Fatal("reserve returned while the record was being created")
+	case <-time.After(20 * time.Millisecond):
+	}
+	r.Abandon(fqdn, rtype, value)
+	if create := <-done; !create {
+		t.Fatal("waiter must create the record after the first attempt was abandoned")
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := r.Reserve(cctx, fqdn, rtype, value); err == nil {
+		t.Fatal("reserve must give up when the context is done")
+	}
+}
+
+func TestPendingOnlyChallenges(t *testing.T) {
+	ctx := context.Background()
+	r := new(Pending)
+	const fqdn, rtype, value = "www.example.ipv64.de.", "A", "192.0.2.1"
+
+	for i := range 2 {
+		if _, create, err := r.Reserve(ctx, fqdn, rtype, value); err != nil || !create {
+			t.Fatalf("reserve %d = %v, %v; want create", i+1, create, err)
+		}
+		r.Add(fqdn, PendingRecord{ID: i + 1, Type: rtype, Value: value})
+	}
+	if r.Release(fqdn, rtype, value) {
+		t.Error("release kept a duplicate A record")
+	}
+	if _, ok := r.Get(fqdn, rtype, value); ok {
+		t.Error("A record registered as pending")
+	}
+	if _, create, _ := r.Reserve(ctx, "_acme-challenge.example.ipv64.de.", "CNAME", "target"); !create {
+		t.Error("CNAME record at a challenge name shared")
+	}
+}
@@ -0,0 +1,290 @@
+package ipv64
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libdns/libdns"
+
+	"github.com/Sickjuicy/caddy-ipv64/internal/ipv64api"
+)
+
+// Provider manages records of an ipv64.net account. The zero value with
+// APIToken set is ready to use. Network errors, 429 and 5xx responses are
+// retried, tokens fail over in order, and concurrent challenges for the same
+// record share it, like in the Caddy module. The settings are read on first
+// use.
+type Provider struct {
+	// APIToken is the account API token.
+	APIToken string `json:"api_token,omitempty"`
+
+	// Tokens are further API tokens of the account, used in order when the
+	// previous one is rejected or rate limited.
+	Tokens []string `json:"api_tokens,omitempty"`
+
+	// MaxRetries is how often a failed request is retried (default 3,
+	// negative for none).
+	MaxRetries int `json:"max_retries,omitempty"`
+
+	// Endpoint is the base URL of the API (default DefaultEndpoint).
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Domain, if set, is the ipv64 domain all records are managed under.
+	// Otherwise the domain is looked up in the account's domain list.
+	Domain string `json:"domain,omitempty"`
+
+	// RequestStyle is "form" (default) or "query" to send all parameters
+	// in the query string of GET requests.
+	RequestStyle string `json:"request_style,omitempty"`
+
+	// DeleteMethod is "POST" (default) or "DELETE", the HTTP method used to
+	// delete records. DELETE requests with a body are dropped by some proxies.
+	DeleteMethod string `json:"delete_method,omitempty"`
+
+	// HTTPClient is used for API requests (default: 30s timeout).
+	HTTPClient *http.Client `json:"-"`
+
+	// Client, if set, replaces the HTTP API client, e.g. with a fake in
+	// tests. APIToken, Endpoint and HTTPClient are ignored then.
+	Client APIClient `json:"-"`
+
+	// DomainsCacheTTL is how long the account's domain list is reused before
+	// get_domains is called again (default 1h).
+	DomainsCacheTTL time.Duration `json:"domains_cache_ttl,omitempty"`
+
+	mu        sync.Mutex
+	domains   []string
+	domainsAt time.Time
+
+	apiOnce sync.Once
+	api     *ipv64api.Client
+	pending Pending // challenge records not yet deleted
+}
+
+// GetRecords returns the records of zone.
+func (p *Provider) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
+	managed, err := p.managed(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := p.client().ListRecords(ctx, managed)
+	if err != nil {
+		return nil, err
+	}
+	var out []libdns.Record
+	for _, rec := range recs {
+		if r, ok := ToLibdns(rec, managed, zone); ok {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// AppendRecords creates recs in zone and returns the records created.
+func (p *Provider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	var appended []libdns.Record
+	for _, r := range recs {
+		rr := r.RR()
+		fqdn := libdns.AbsoluteName(rr.Name, zone)
+		managed, err := p.managed(ctx, fqdn)
+		if err != nil {
+			return appended, err
+		}
+		content, err := Content(rr)
+		if err != nil {
+			return appended, err
+		}
+		rtype, prefix := RecordType(rr), Prefix(fqdn, managed)
+		rr.Type = rtype
+		pending, create, err := p.pending.Reserve(ctx, fqdn, rtype, content)
+		if err != nil {
+			return appended, err
+		}
+		if !create {
+			// another challenge created the same record
+			appended = append(appended, WithID(Typed(rr), pending.ID))
+			continue
+		}
+		if err := p.client().AddRecord(ctx, managed, prefix, rtype, content); err != nil {
+			p.pending.Abandon(fqdn, rtype, content)
+			return appended, err
+		}
+		p.pending.Add(fqdn, PendingRecord{Managed: managed, Prefix: prefix, Type: rtype, Value: content, Created: time.Now()})
+		appended = append(appended, Typed(rr))
+	}
+	return appended, nil
+}
+
+// DeleteRecords deletes recs from zone and returns the records deleted.
+// Records with empty type or data match any type or data. Records are
+// deleted by their ID, so duplicates are removed one by one. A record that
+// another challenge appended as well is kept until that one deletes it too.
+func (p *Provider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	var deleted []libdns.Record
+	for _, r := range recs {
+		rr := r.RR()
+		fqdn := libdns.AbsoluteName(rr.Name, zone)
+		var rtype, content string
+		if rr.Data != "" {
+			rtype = RecordType(rr)
+			content, _ = Content(rr)
+			if p.pending.Release(fqdn, rtype, content) {
+				rr.Type = rtype
+				deleted = append(deleted, Typed(rr))
+				continue
+			}
+		}
+		managed, err := p.managed(ctx, fqdn)
+		if err != nil {
+			return deleted, err
+		}
+		prefix := Prefix(fqdn, managed)
+		existing, err := p.client().ListRecords(ctx, managed)
+		if err != nil {
+			return deleted, err
+		}
+		for _, rec := range existing {
+			if !Matches(rec, prefix, rr) {
+				continue
+			}
+			if id := ID(r); id != 0 && id != rec.ID {
+				continue
+			}
+			if err := p.client().DelRecordByID(ctx, managed, rec.ID); err != nil {
+				return deleted, err
+			}
+			rr.Type, rr.Data = strings.ToUpper(rec.Type), rec.Content
+			deleted = append(deleted, WithID(Typed(rr), rec.ID))
+		}
+		if rr.Data != "" {
+			p.pending.Remove(fqdn, rtype, content)
+		}
+	}
+	return deleted, nil
+}
+
+// ListZones returns the domains of the account.
+func (p *Provider) ListZones(ctx context.Context) ([]libdns.Zone, error) {
+	domains, err := p.listDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+	zones := make([]libdns.Zone, 0, len(domains))
+	for _, d := range domains {
+		zones = append(zones, libdns.Zone{Name: d + "."})
+	}
+	return zones, nil
+}
+
+// Matches reports whether the API record rec with the given prefix is
+// selected by rr, where empty type, TTL or data match anything.
+func Matches(rec Record, prefix string, rr libdns.RR) bool {
+	recPrefix := rec.Praefix
+	if recPrefix == "" {
+		recPrefix = "@"
+	}
+	if recPrefix != prefix {
+		return false
+	}
+	if rr.Type != "" && !strings.EqualFold(rr.Type, rec.Type) {
+		return false
+	}
+	if rr.TTL != 0 && int(rr.TTL.Seconds()) != rec.TTL {
+		return false
+	}
+	if rr.Data != "" {
+		content, err := Content(rr)
+		if err != nil || content != rec.Content {
+			return false
+		}
+	}
+	return true
+}
+
+// managed returns the ipv64 domain that name is managed under.
+func (p *Provider) managed(ctx context.Context, name string) (string, error) {
+	if p.Domain != "" {
+		return strings.TrimSuffix(p.Domain, "."), nil
+	}
+	domains, err := p.listDomains(ctx)
+	if err != nil {
+		return "", err
+	}
+	if managed := ManagedZone(name, domains); managed != "" {
+		return managed, nil
+	}
+	return "", fmt.Errorf("%s is not in any domain of the ipv64 account", name)
+}
+
+// listDomains returns the domains of the account, cached for DomainsCacheTTL.
+func (p *Provider) listDomains(ctx context.Context) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ttl := p.DomainsCacheTTL
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	if p.domains != nil && time.Since(p.domainsAt) < ttl {
+		return p.domains, nil
+	}
+	domains, err := p.client().GetDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.domains, p.domainsAt = domains, time.Now()
+	return domains, nil
+}
+
+// APIClient is the ipv64 API as used by Provider. The record and domain
+// logic only talks to the API through it.
+type APIClient interface {
+	AddRecord(ctx context.Context, domain, praefix, rtype, content string) error
+	DelRecord(ctx context.Context, domain, praefix, rtype, content string) error
+	DelRecordByID(ctx context.Context, domain string, id int) error
+	ListRecords(ctx context.Context, domain string) ([]Record, error)
+	GetDomains(ctx context.Context) ([]string, error)
+}
+
+// NewAPIClient returns the HTTP API client for token. endpoint and
+// httpClient may be empty for the defaults.
+func NewAPIClient(token, endpoint string, httpClient *http.Client) APIClient {
+	return &ipv64api.Client{Token: token, Endpoint: endpoint, HTTPClient: httpClient}
+}
+
+// client returns p.Client or the HTTP API client for the provider's
+// settings. The HTTP client is built once, so token failover state persists
+// across calls.
+func (p *Provider) client() APIClient {
+	if p.Client != nil {
+		return p.Client
+	}
+	p.apiOnce.Do(func() {
+		retries := p.MaxRetries
+		if retries == 0 {
+			retries = 3
+		}
+		p.api = &ipv64api.Client{
+			Endpoint:     p.Endpoint,
+			Token:        p.APIToken,
+			Tokens:       ipv64api.NewTokenSet(append([]string{p.APIToken}, p.Tokens...), 0),
+			HTTPClient:   p.HTTPClient,
+			RequestStyle: p.RequestStyle,
+			DeleteMethod: p.DeleteMethod,
+			MaxRetries:   max(retries, 0),
+		}
+	})
+	return p.api
+}
+
+// Interface guards
+var (
+	_ libdns.RecordGetter   = (*Provider)(nil)
+	_ libdns.RecordAppender = (*Provider)(nil)
+	_ libdns.RecordDeleter  = (*Provider)(nil)
+	_ libdns.ZoneLister     = (*Provider)(nil)
+	_ APIClient             = (*ipv64api.Client)(nil)
+)
@@ -0,0 +1,170 @@
+package ipv64
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// countingClient is an APIClient that only answers get_domains.
+type countingClient struct {
+	APIClient
+	calls int
+}
+
+func (c *countingClient) GetDomains(context.Context) ([]string, error) {
+	c.calls++
+	return []string{"example.ipv64.de"}, nil
+}
+
+func TestListDomainsCacheTTL(t *testing.T) {
+	ctx := context.Background()
+	client := new(countingClient)
+	p := &Provider{Client: client, DomainsCacheTTL: time.Minute}
+
+	for range 3 {
+		if _, err := p.ListZones(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if client.calls != 1 {
+		t.Fatalf("get_domains called %d times within the TTL, want 1", client.calls)
+	}
+
+	p.domainsAt = p.domainsAt.Add(-2 * time.Minute)
+	if _, err := p.ListZones(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if client.calls != 2 {
+		t.Fatalf("get_domains called %d times after the TTL, want 2", client.calls)
+	}
+}
+
+// memClient is an APIClient keeping the records of one domain in memory.
+type memClient struct {
+	APIClient
+	mu      sync.Mutex
+	records []Record
+	nextID  int
+}
+
+func (c *memClient) GetDomains(context.Context) ([]string, error) {
+	return []string{"example.ipv64.de"}, nil
+}
+
+func (c *memClient) AddRecord(_ context.Context, _, praefix, rtype, content string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	c.records = append(c.records, Record{ID: c.nextID, Praefix: praefix, Type: rtype, Content: content})
+	return nil
+}
+
+func (c *memClient) ListRecords(context.Context, string) ([]Record, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Record(nil), c.records...), nil
+}
+
+func (c *memClient) DelRecordByID(_ context.Context, _ string, id int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, rec := range c.records {
+		if rec.ID == id {
+			c.records = append(c.records[:i], c.records[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("record %d not found", id)
+}
+
+func TestSharedChallengeRecord(t *testing.T) {
+	ctx := context.Background()
+	client := new(memClient)
+	p := &Provider{Client: client}
+	const zone = "example.ipv64.de."
+	challenge := []libdns.Record{libdns.TXT{Name: "_acme-challenge", Text: "token"}}
+
+	for range 2 {
+		if _, err := p.AppendRecords(ctx, zone, challenge); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(client.records); n != 1 {
+		t.Fatalf("two challenges for the same value created %d records, want 1", n)
+	}
+	if _, err := p.DeleteRecords(ctx, zone, challenge); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(client.records); n != 1 {
+		t.Fatal("record deleted while the other challenge still uses it")
+	}
+	if _, err := p.DeleteRecords(ctx, zone, challenge); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(client.records); n != 0 {
+		t.Fatalf("%d records left after both challenges are done, want 0", n)
+	}
+}
+
+func TestTokenFailover(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.Header.Get("Authorization")]++
+		mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer good" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"subdomains":{"example.ipv64.de":{}},"info":"success","status":"200 OK"}`)
+	}))
+	defer srv.Close()
+
+	p := &Provider{APIToken: "revoked", Tokens: []string{"good"}, Endpoint: srv.URL}
+	for range 2 {
+		zones, err := p.ListZones(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(zones) != 1 {
+			t.Fatalf("zones = %v, want example.ipv64.de", zones)
+		}
+		p.domains = nil
+	}
+	if n := requests["Bearer revoked"]; n != 1 {
+		t.Errorf("rejected token was sent %d times, want once", n)
+	}
+}
+
+func TestDuplicateRecordsNotShared(t *testing.T) {
+	ctx := context.Background()
+	client := new(memClient)
+	p := &Provider{Client: client}
+	const zone = "example.ipv64.de."
+	a := []libdns.Record{libdns.Address{Name: "www", IP: netip.MustParseAddr("192.0.2.1")}}
+
+	for range 2 {
+		if _, err := p.AppendRecords(ctx, zone, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(client.records); n != 2 {
+		t.Fatalf("appending an A record twice created %d records, want 2", n)
+	}
+	deleted, err := p.DeleteRecords(ctx, zone, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 2 || len(client.records) != 0 {
+		t.Errorf("delete reported %d records with %d left, want both deleted", len(deleted), len(client.records))
+	}
+}
@@ -0,0 +1,63 @@
+package ipv64
+
+import "github.com/libdns/libdns"
+
+// WithID returns r with the ipv64 record ID stored in its ProviderData.
+// Records without a ProviderData field are returned unchanged.
+func WithID(r libdns.Record, id int) libdns.Record {
+	if id == 0 {
+		return r
+	}
+	switch rec := r.(type) {
+	case libdns.Address:
+		rec.ProviderData = id
+		return rec
+	case libdns.CAA:
+		rec.ProviderData = id
+		return rec
+	case libdns.CNAME:
+		rec.ProviderData = id
+		return rec
+	case libdns.MX:
+		rec.ProviderData = id
+		return rec
+	case libdns.NS:
+		rec.ProviderData = id
+		return rec
+	case libdns.SRV:
+		rec.ProviderData = id
+		return rec
+	case libdns.ServiceBinding:
+		rec.ProviderData = id
+		return rec
+	case libdns.TXT:
+		rec.ProviderData = id
+		return rec
+	}
+	return r
+}
+
+// ID returns the ipv64 record ID stored in the ProviderData of r, or 0.
+func ID(r libdns.Record) int {
+	var data any
+	switch rec := r.(type) {
+	case libdns.Address:
+		data = rec.ProviderData
+	case libdns.CAA:
+		data = rec.ProviderData
+	case libdns.CNAME:
+		data = rec.ProviderData
+	case libdns.MX:
+		data = rec.ProviderData
+	case libdns.NS:
+		data = rec.ProviderData
+	case libdns.SRV:
+		data = rec.ProviderData
+	case libdns.ServiceBinding:
+		data = rec.ProviderData
+	case libdns.TXT:
+		data = rec.ProviderData
+	}
+	id, _ := data.(int)
+	return id
+}
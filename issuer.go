@@ -0,0 +1,200 @@
+package caddyipv64
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddytls"
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// ACMEDefaultsIssuer wraps Caddy's ACME issuer and sets safer DNS-01 defaults
+// for ipv64.net when they are unset (propagation_delay 30s, propagation_timeout 4m).
+//
+// With fallback_after_failures, it additionally tracks consecutive DNS-01
+// failures per identifier and falls back to the HTTP-01/TLS-ALPN challenges for
+// non-wildcard names, so reachable sites still get certificates while the
+// ipv64 API or its nameservers have problems.
+type ACMEDefaultsIssuer struct {
+	*caddytls.ACMEIssuer
+
+	// FallbackAfterFailures is the number of consecutive DNS-01 failures of an
+	// identifier after which HTTP-01/TLS-ALPN is used instead (0 disables).
+	FallbackAfterFailures int `json:"fallback_after_failures,omitempty"`
+
+	fallback   *caddytls.ACMEIssuer
+	failuresMu *sync.Mutex
+	failures   map[string]int
+	logger     *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (ACMEDefaultsIssuer) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tls.issuance.acme_defaults",
+		New: func() caddy.Module { return new(ACMEDefaultsIssuer) },
+	}
+}
+
+// Provision applies the defaults and provisions the wrapped issuer(s).
+func (iss *ACMEDefaultsIssuer) Provision(ctx caddy.Context) error {
+	iss.logger = ctx.Logger(iss)
+	iss.failuresMu = new(sync.Mutex)
+	iss.failures = make(map[string]int)
+	if iss.ACMEIssuer == nil {
+		iss.ACMEIssuer = new(caddytls.ACMEIssuer)
+	}
+	if dns := iss.dnsConfig(); dns != nil {
+		if dns.PropagationDelay == 0 {
+			dns.PropagationDelay = caddy.Duration(30 * time.Second)
+		}
+		if dns.PropagationTimeout == 0 {
+			dns.PropagationTimeout = caddy.Duration(4 * time.Minute)
+		}
+	}
+
+	// the fallback is a copy of the configuration without the DNS challenge;
+	// copy before provisioning, which adds unexported state
+	if iss.FallbackAfterFailures > 0 && iss.dnsConfig() != nil {
+		raw, err := json.Marshal(iss.ACMEIssuer)
+		if err != nil {
+			return err
+		}
+		fallback := new(caddytls.ACMEIssuer)
+		if err := json.Unmarshal(raw, fallback); err != nil {
+			return err
+		}
+		fallback.Challenges.DNS = nil
+		if err := fallback.Provision(ctx); err != nil {
+			return fmt.Errorf("provisioning fallback issuer: %v", err)
+		}
+		iss.fallback = fallback
+	}
+
+	return iss.ACMEIssuer.Provision(ctx)
+}
+
+func (iss *ACMEDefaultsIssuer) dnsConfig() *caddytls.DNSChallengeConfig {
+	if iss.ACMEIssuer == nil || iss.ACMEIssuer.Challenges == nil {
+		return nil
+	}
+	return iss.ACMEIssuer.Challenges.DNS
+}
+
+// SetConfig implements caddytls.ConfigSetter.
+func (iss *ACMEDefaultsIssuer) SetConfig(cfg *certmagic.Config) {
+	iss.ACMEIssuer.SetConfig(cfg)
+	if iss.fallback != nil {
+		iss.fallback.SetConfig(cfg)
+	}
+}
+
+// Issue obtains a certificate, using the fallback challenges if DNS-01 kept
+// failing for one of the (non-wildcard) names.
+func (iss *ACMEDefaultsIssuer) Issue(ctx context.Context, csr *x509.CertificateRequest) (*certmagic.IssuedCertificate, error) {
+	names := csr.DNSNames
+	if iss.fallback != nil && iss.shouldFallback(names) {
+		iss.logger.Warn("DNS-01 challenge keeps failing; falling back to HTTP-01/TLS-ALPN",
+			zap.Strings("identifiers", names),
+			zap.Int("threshold", iss.FallbackAfterFailures))
+		cert, err := iss.fallback.Issue(ctx, csr)
+		if err == nil {
+			// give DNS-01 another chance next time
+			iss.resetFailures(names)
+		}
+		return cert, err
+	}
+
+	cert, err := iss.ACMEIssuer.Issue(ctx, csr)
+	if err != nil && ctx.Err() == nil {
+		iss.recordFailure(names)
+	} else if err == nil {
+		iss.resetFailures(names)
+	}
+	return cert, err
+}
+
+func (iss *ACMEDefaultsIssuer) shouldFallback(names []string) bool {
+	iss.failuresMu.Lock()
+	defer iss.failuresMu.Unlock()
+	exceeded := false
+	for _, name := range names {
+		if strings.HasPrefix(name, "*.") {
+			// wildcards can only be validated via DNS-01
+			return false
+		}
+		if iss.failures[name] >= iss.FallbackAfterFailures {
+			exceeded = true
+		}
+	}
+	return exceeded
+}
+
+func (iss *ACMEDefaultsIssuer) recordFailure(names []string) {
+	iss.failuresMu.Lock()
+	defer iss.failuresMu.Unlock()
+	for _, name := range names {
+		iss.failures[name]++
+	}
+}
+
+func (iss *ACMEDefaultsIssuer) resetFailures(names []string) {
+	iss.failuresMu.Lock()
+	defer iss.failuresMu.Unlock()
+	for _, name := range names {
+		delete(iss.failures, name)
+	}
+}
+
+// UnmarshalCaddyfile accepts the same syntax as the acme issuer plus:
+//
+//	fallback_after_failures <n>
+func (iss *ACMEDefaultsIssuer) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	// filter our own options out and hand the rest to the ACME issuer
+	var tokens []caddyfile.Token
+	depth, prevLine := 0, -1
+	for d.Next() {
+		tok := d.Token()
+		firstOnLine := tok.Line != prevLine
+		prevLine = tok.Line
+		switch {
+		case tok.Text == "{":
+			depth++
+		case tok.Text == "}":
+			depth--
+		case depth == 1 && firstOnLine && tok.Text == "fallback_after_failures":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			var v int
+			if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+				return d.Errf("invalid fallback_after_failures: %s", d.Val())
+			}
+			iss.FallbackAfterFailures = v
+			continue
+		}
+		tokens = append(tokens, tok)
+	}
+	iss.ACMEIssuer = new(caddytls.ACMEIssuer)
+	return iss.ACMEIssuer.UnmarshalCaddyfile(caddyfile.NewDispenser(tokens))
+}
+
+func init() {
+	caddy.RegisterModule(ACMEDefaultsIssuer{})
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*ACMEDefaultsIssuer)(nil)
+	_ certmagic.Issuer      = (*ACMEDefaultsIssuer)(nil)
+	_ caddytls.ConfigSetter = (*ACMEDefaultsIssuer)(nil)
+	_ caddyfile.Unmarshaler = (*ACMEDefaultsIssuer)(nil)
+)
@@ -0,0 +1,110 @@
+package caddyipv64
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	latencyWindow      = 50   // samples kept per endpoint
+	minLatencySamples  = 10   // samples needed before timeouts adapt
+	latencyPercentile  = 0.95 // percentile the timeout is derived from
+	latencyHeadroom    = 3    // timeout = headroom * percentile
+	timeoutChangeRatio = 1.25 // log when the timeout changes by more than this
+)
+
+// latencyTracker keeps the recent response times of each API endpoint.
+type latencyTracker struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpointLatency
+}
+
+type endpointLatency struct {
+	samples []time.Duration // ring buffer of the last latencyWindow samples
+	next    int
+	timeout time.Duration // last derived timeout, to log changes
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{endpoints: make(map[string]*endpointLatency)}
+}
+
+func (t *latencyTracker) observe(endpoint string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.endpoints[endpoint]
+	if !ok {
+		e = new(endpointLatency)
+		t.endpoints[endpoint] = e
+	}
+	if len(e.samples) < latencyWindow {
+		e.samples = append(e.samples, d)
+		return
+	}
+	e.samples[e.next] = d
+	e.next = (e.next + 1) % latencyWindow
+}
+
+// timeout derives the timeout of endpoint from its latency percentile, clamped
+// to [lo, hi]. It returns false while there are too few samples. changed is
+// set if the timeout moved notably since the last call.
+func (t *latencyTracker) timeout(endpoint string, lo, hi time.Duration) (d time.Duration, changed, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, found := t.endpoints[endpoint]
+	if !found || len(e.samples) < minLatencySamples {
+		return 0, false, false
+	}
+	sorted := slices.Clone(e.samples)
+	slices.Sort(sorted)
+	pct := sorted[int(latencyPercentile*float64(len(sorted)-1))]
+	d = min(max(latencyHeadroom*pct, lo), hi)
+
+	prev := e.timeout
+	e.timeout = d
+	changed = prev == 0 ||
+		float64(d) > float64(prev)*timeoutChangeRatio ||
+		float64(d)*timeoutChangeRatio < float64(prev)
+	return d, changed, true
+}
+
+// apiEndpoint names the API action of a request for latency tracking,
+// e.g. "add_record" or "get_domains".
+func apiEndpoint(apiURL string, formData url.Values) string {
+	for _, action := range []string{"add_record", "del_record", "list_records"} {
+		if formData.Has(action) {
+			return action
+		}
+	}
+	if u, err := url.Parse(apiURL); err == nil && u.RawQuery != "" {
+		action, _, _ := strings.Cut(u.RawQuery, "&")
+		action, _, _ = strings.Cut(action, "=")
+		return action
+	}
+	return "other"
+}
+
+// requestTimeout returns the timeout of the next request to endpoint: the
+// adapted timeout if AdaptiveTimeout is enabled and enough latencies have
+// been observed, timeout_seconds otherwise.
+func (p *Provider) requestTimeout(endpoint string) time.Duration {
+	fixed := time.Duration(p.TimeoutSeconds) * time.Second
+	if !p.AdaptiveTimeout {
+		return fixed
+	}
+	d, changed, ok := p.latency.timeout(endpoint, time.Duration(p.MinTimeout), time.Duration(p.MaxTimeout))
+	if !ok {
+		return fixed
+	}
+	if changed && p.logger != nil {
+		p.logger.Info("ipv64: adapted API request timeout",
+			zap.String("endpoint", endpoint),
+			zap.Duration("timeout", d))
+	}
+	return d
+}
@@ -0,0 +1,123 @@
+package caddyipv64
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// storedLease is the DynDNS leader lease as kept in Caddy storage.
+type storedLease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// dynLease elects one leader among the Caddy instances sharing a storage:
+// whoever holds the unexpired lease performs the DynDNS updates. The leader
+// renews the lease every third of its TTL; if it stops, another instance
+// takes over once the lease expires.
+type dynLease struct {
+	storage certmagic.Storage
+	key     string
+	id      string // this instance
+	ttl     time.Duration
+	logger  *zap.Logger
+	leader  atomic.Bool
+}
+
+func newDynLease(storage certmagic.Storage, name string, ttl time.Duration, logger *zap.Logger) *dynLease {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return &dynLease{
+		storage: storage,
+		key:     path.Join("ipv64", "dyndns", "leader-"+name+".json"),
+		id:      host + "-" + hex.EncodeToString(b),
+		ttl:     ttl,
+		logger:  logger,
+	}
+}
+
+// run renews the lease every third of its TTL until ctx is done, then
+// releases it.
+func (l *dynLease) run(ctx context.Context) {
+	for sleepContext(ctx, l.ttl/3) == nil {
+		l.renew(ctx)
+	}
+	l.release()
+}
+
+// renew tries to acquire or renew the lease once.
+func (l *dynLease) renew(ctx context.Context) {
+	held, err := l.acquire(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		// without storage, nobody can tell who leads; stepping down
+		// avoids two leaders fighting over the records
+		l.logger.Warn("ipv64 dynDNS: renewing leader lease failed", zap.Error(err))
+		held = false
+	}
+	if l.leader.Swap(held) != held {
+		l.logger.Info("ipv64 dynDNS: leadership changed", zap.Bool("leader", held), zap.String("instance", l.id))
+	}
+}
+
+// acquire takes the lease if it is free, expired or already ours.
+func (l *dynLease) acquire(ctx context.Context) (bool, error) {
+	if err := l.storage.Lock(ctx, l.key); err != nil {
+		return false, err
+	}
+	defer func() { _ = l.storage.Unlock(context.Background(), l.key) }()
+	var cur storedLease
+	if data, err := l.storage.Load(ctx, l.key); err == nil {
+		_ = json.Unmarshal(data, &cur)
+	}
+	now := time.Now()
+	if cur.Holder != "" && cur.Holder != l.id && now.Before(cur.Expires) {
+		return false, nil
+	}
+	data, err := json.Marshal(storedLease{Holder: l.id, Expires: now.Add(l.ttl)})
+	if err != nil {
+		return false, err
+	}
+	if err := l.storage.Store(ctx, l.key, data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// release gives up the lease so another instance can take over right away.
+func (l *dynLease) release() {
+	if !l.leader.Swap(false) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := l.storage.Lock(ctx, l.key); err != nil {
+		return
+	}
+	defer func() { _ = l.storage.Unlock(context.Background(), l.key) }()
+	data, err := l.storage.Load(ctx, l.key)
+	if err != nil {
+		return
+	}
+	var cur storedLease
+	if json.Unmarshal(data, &cur) == nil && cur.Holder == l.id {
+		_ = l.storage.Delete(ctx, l.key)
+	}
+}
+
+// isLeader reports whether this instance currently holds the lease.
+func (l *dynLease) isLeader() bool {
+	return l.leader.Load()
+}
@@ -0,0 +1,168 @@
+package caddyipv64
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// accountLimiter spaces out the API requests of one ipv64 account. It is keyed
+// by the API token a request is sent with, so that all providers and reloads
+// using the same account share it. It only records when requests were
+// scheduled; each request is checked against the rates of its own provider,
+// so providers with different settings don't override each other.
+type accountLimiter struct {
+	mu       sync.Mutex
+	requests []time.Time // scheduled requests of the last minute, in order
+	writes   []time.Time // same for staggered record changes
+	queue    writeQueue
+}
+
+var (
+	accountLimitersMu sync.Mutex
+	accountLimiters   = make(map[string]*accountLimiter)
+)
+
+func limiterFor(token string) *accountLimiter {
+	accountLimitersMu.Lock()
+	defer accountLimitersMu.Unlock()
+	l, ok := accountLimiters[token]
+	if !ok {
+		l = new(accountLimiter)
+		accountLimiters[token] = l
+	}
+	return l
+}
+
+// reserveSlot schedules a call no earlier than at and after all calls in
+// sched, such that at most burst calls start within burst intervals, and
+// returns how long after at the call has to wait. Windows are at most a
+// minute long, so older calls are dropped.
+func reserveSlot(sched *[]time.Time, at time.Time, interval time.Duration, burst int) time.Duration {
+	recent := *sched
+	slot := at
+	if n := len(recent); n > 0 && recent[n-1].After(slot) {
+		slot = recent[n-1]
+	}
+	if n := len(recent); n >= burst {
+		if free := recent[n-burst].Add(time.Duration(burst) * interval); free.After(slot) {
+			slot = free
+		}
+	}
+	cut := 0
+	for cut < len(recent) && recent[cut].Before(at.Add(-time.Minute)) {
+		cut++
+	}
+	*sched = append(recent[cut:], slot)
+	return slot.Sub(at)
+}
+
+// reserve returns how long a request of p has to wait. Record changes are
+// staggered by StaggerRequestsPerMinute first; all requests are limited by
+// MaxRequestsPerMinute, allowing ten seconds worth of requests at once so
+// that short bursts such as a create followed by its read-back aren't delayed.
+func (l *accountLimiter) reserve(p *Provider, write bool) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	var wait time.Duration
+	if write && p.StaggerRequestsPerMinute > 0 {
+		wait = reserveSlot(&l.writes, now, time.Minute/time.Duration(p.StaggerRequestsPerMinute), 1)
+	}
+	if p.MaxRequestsPerMinute > 0 {
+		wait += reserveSlot(&l.requests, now.Add(wait), time.Minute/time.Duration(p.MaxRequestsPerMinute), max(1, p.MaxRequestsPerMinute/6))
+	}
+	return wait
+}
+
+// limit waits until the account of token may issue another API request.
+func (p *Provider) limit(ctx context.Context, token string) error {
+	write := isWrite(ctx)
+	if p.MaxRequestsPerMinute <= 0 && (!write || p.StaggerRequestsPerMinute <= 0) {
+		return nil
+	}
+	wait := limiterFor(token).reserve(p, write)
+	if wait <= 0 {
+		return nil
+	}
+	if p.logger != nil {
+		p.logger.Debug("ipv64: rate limiting API request", zap.Duration("wait", wait), zap.Bool("write", write))
+	}
+	return sleepContext(ctx, wait)
+}
+
+type writeKey struct{}
+
+// isWrite reports whether ctx belongs to a record change.
+func isWrite(ctx context.Context) bool {
+	write, _ := ctx.Value(writeKey{}).(bool)
+	return write
+}
+
+// serializeWrite runs the record change fn, after all earlier changes of the
+// account have finished if SerializeWrites is set. The requests of fn are
+// staggered by StaggerRequestsPerMinute. Since the token is only picked per
+// request, the queue is keyed by the provider's first token.
+func (p *Provider) serializeWrite(ctx context.Context, fn func(ctx context.Context) error) error {
+	ctx = context.WithValue(ctx, writeKey{}, true)
+	if !p.SerializeWrites {
+		return fn(ctx)
+	}
+	q := &limiterFor(p.Token).queue
+	if err := q.acquire(ctx); err != nil {
+		return err
+	}
+	defer q.release()
+	return fn(ctx)
+}
+
+// writeQueue is a lock that is handed to waiters in FIFO order.
+type writeQueue struct {
+	mu      sync.Mutex
+	busy    bool
+	waiters []chan struct{}
+}
+
+func (q *writeQueue) acquire(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.busy {
+		q.busy = true
+		q.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	q.waiters = append(q.waiters, ch)
+	q.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		for i, w := range q.waiters {
+			if w == ch {
+				q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+				q.mu.Unlock()
+				return ctx.Err()
+			}
+		}
+		q.mu.Unlock()
+		// the lock was handed to us concurrently; pass it on
+		q.release()
+		return ctx.Err()
+	}
+}
+
+func (q *writeQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiters) == 0 {
+		q.busy = false
+		return
+	}
+	next := q.waiters[0]
+	q.waiters = q.waiters[1:]
+	close(next)
+}
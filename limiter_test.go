@@ -0,0 +1,55 @@
+package caddyipv64
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReserveSlot(t *testing.T) {
+	now := time.Now()
+	var sched []time.Time
+	for i, want := range []time.Duration{0, 0, 0, 30 * time.Second, 30 * time.Second, 30 * time.Second, time.Minute} {
+		if got := reserveSlot(&sched, now, 10*time.Second, 3); got != want {
+			t.Errorf("call %d waits %v, want %v", i+1, got, want)
+		}
+	}
+	// an idle schedule starts over with a full burst
+	if got := reserveSlot(&sched, now.Add(time.Hour), 10*time.Second, 3); got != 0 {
+		t.Errorf("after idle: waits %v, want 0", got)
+	}
+	if len(sched) != 1 {
+		t.Errorf("%d calls kept, want only the last one", len(sched))
+	}
+}
+
+func TestAccountLimiterPerProvider(t *testing.T) {
+	var l accountLimiter
+	slow := &Provider{MaxRequestsPerMinute: 1}
+	fast := &Provider{MaxRequestsPerMinute: 600}
+
+	if wait := l.reserve(slow, false); wait != 0 {
+		t.Fatalf("first request waits %v", wait)
+	}
+	// the slow provider may not send again for a minute, but the fast
+	// provider keeps its own rate instead of inheriting or overriding it
+	if wait := l.reserve(fast, false); wait != 0 {
+		t.Errorf("fast provider waits %v", wait)
+	}
+	if wait := l.reserve(slow, false); wait < 59*time.Second || wait > time.Minute {
+		t.Errorf("slow provider waits %v, want a minute", wait)
+	}
+}
+
+func TestAccountLimiterStagger(t *testing.T) {
+	var l accountLimiter
+	p := &Provider{StaggerRequestsPerMinute: 6}
+	if wait := l.reserve(p, true); wait != 0 {
+		t.Fatalf("first write waits %v", wait)
+	}
+	if wait := l.reserve(p, false); wait != 0 {
+		t.Errorf("read is staggered by %v", wait)
+	}
+	if wait := l.reserve(p, true); wait < 9*time.Second || wait > 10*time.Second {
+		t.Errorf("second write waits %v, want 10s", wait)
+	}
+}
@@ -0,0 +1,299 @@
+package caddyipv64
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/libdns/libdns"
+	"go.uber.org/zap"
+
+	"github.com/Sickjuicy/caddy-ipv64/ipv64"
+)
+
+// MailPreset generates the standard record set of a self-hosted mail domain
+// (MX, SPF, DKIM, DMARC and optionally autodiscover/autoconfig CNAMEs) in an
+// ipv64 zone, so it doesn't have to be assembled by hand.
+type MailPreset struct {
+	// Domain is the ipv64 domain receiving mail. Required.
+	Domain string `json:"domain,omitempty"`
+
+	// MailHost is the MX target (default mail.<domain>).
+	MailHost string `json:"mail_host,omitempty"`
+
+	// MXPriority of the MX record (default 10). 0 is a valid priority.
+	MXPriority *int `json:"mx_priority,omitempty"`
+
+	// SPF policy (default "v=spf1 mx -all").
+	SPF string `json:"spf,omitempty"`
+
+	// DKIM selector (default "mail") and public key, either inline (the
+	// base64 p= value) or read from a file.
+	DKIMSelector      string `json:"dkim_selector,omitempty"`
+	DKIMPublicKey     string `json:"dkim_public_key,omitempty"`
+	DKIMPublicKeyFile string `json:"dkim_public_key_file,omitempty"`
+
+	// DMARC policy (default "quarantine") and optional aggregate report address.
+	DMARCPolicy string `json:"dmarc_policy,omitempty"`
+	DMARCReport string `json:"dmarc_rua,omitempty"`
+
+	// Autodiscover adds autodiscover/autoconfig CNAMEs pointing at MailHost.
+	Autodiscover bool `json:"autodiscover,omitempty"`
+}
+
+// presetRecord is a record in ipv64 API terms.
+type presetRecord struct {
+	Prefix  string
+	Type    string
+	Content string
+}
+
+func (m *MailPreset) provision() error {
+	if m.Domain == "" {
+		return fmt.Errorf("mail_preset: domain is required")
+	}
+	m.Domain = strings.TrimSuffix(m.Domain, ".")
+	if m.MailHost == "" {
+		m.MailHost = "mail." + m.Domain
+	}
+	if m.MXPriority == nil {
+		prio := 10
+		m.MXPriority = &prio
+	}
+	if *m.MXPriority < 0 || *m.MXPriority > 65535 {
+		return fmt.Errorf("mail_preset: invalid mx_priority %d", *m.MXPriority)
+	}
+	if m.SPF == "" {
+		m.SPF = "v=spf1 mx -all"
+	}
+	if m.DKIMSelector == "" {
+		m.DKIMSelector = "mail"
+	}
+	if m.DKIMPublicKey == "" && m.DKIMPublicKeyFile != "" {
+		key, err := os.ReadFile(m.DKIMPublicKeyFile)
+		if err != nil {
+			return fmt.Errorf("mail_preset: reading DKIM key: %v", err)
+		}
+		m.DKIMPublicKey = cleanDKIMKey(string(key))
+	}
+	if m.DMARCPolicy == "" {
+		m.DMARCPolicy = "quarantine"
+	}
+	switch m.DMARCPolicy {
+	case "none", "quarantine", "reject":
+	default:
+		return fmt.Errorf("mail_preset: invalid dmarc_policy %q", m.DMARCPolicy)
+	}
+	return nil
+}
+
+// cleanDKIMKey accepts a PEM public key or a bare base64 value.
+func cleanDKIMKey(key string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(key, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "-----") {
+			continue
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// records returns the record set of the preset.
+func (m *MailPreset) records() []presetRecord {
+	recs := []presetRecord{
+		{Prefix: "@", Type: "MX", Content: fmt.Sprintf("%d %s", *m.MXPriority, m.MailHost)},
+		{Prefix: "@", Type: "TXT", Content: m.SPF},
+	}
+	if m.DKIMPublicKey != "" {
+		recs = append(recs, presetRecord{
+			Prefix:  m.DKIMSelector + "._domainkey",
+			Type:    "TXT",
+			Content: "v=DKIM1; k=rsa; p=" + m.DKIMPublicKey,
+		})
+	}
+	dmarc := "v=DMARC1; p=" + m.DMARCPolicy
+	if m.DMARCReport != "" {
+		dmarc += "; rua=" + m.DMARCReport
+	}
+	recs = append(recs, presetRecord{Prefix: "_dmarc", Type: "TXT", Content: dmarc})
+	if m.Autodiscover {
+		recs = append(recs,
+			presetRecord{Prefix: "autodiscover", Type: "CNAME", Content: m.MailHost},
+			presetRecord{Prefix: "autoconfig", Type: "CNAME", Content: m.MailHost})
+	}
+	return recs
+}
+
+// replaces reports whether the existing record rec is a version of r: a record
+// of the same name and type that is the same MX host, the same kind of TXT
+// policy (the v= tag, e.g. v=spf1 or v=DMARC1) or the CNAME of that name.
+func (r presetRecord) replaces(rec ipv64.Record) bool {
+	if !ipv64.Matches(rec, r.Prefix, libdns.RR{Type: r.Type}) {
+		return false
+	}
+	switch r.Type {
+	case "MX":
+		return strings.EqualFold(mxHost(rec.Content), mxHost(r.Content))
+	case "TXT":
+		return strings.EqualFold(txtTag(rec.Content), txtTag(r.Content))
+	}
+	return true
+}
+
+// mxHost returns the target of MX content "<priority> <host>".
+func mxHost(content string) string {
+	f := strings.Fields(content)
+	if len(f) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(f[len(f)-1], ".")
+}
+
+// txtTag returns the leading tag of a policy TXT record, e.g. "v=spf1".
+func txtTag(content string) string {
+	content = strings.TrimLeft(content, `"`)
+	if i := strings.IndexAny(content, " ;"); i >= 0 {
+		content = content[:i]
+	}
+	return content
+}
+
+// applyMailPresets maintains the preset records in the background. Failures
+// are logged.
+func (p *Provider) applyMailPresets(ctx context.Context) {
+	if len(p.MailPresets) == 0 || p.Mode == modeMock || p.Validate() != nil {
+		return
+	}
+	for _, preset := range p.MailPresets {
+		if err := p.applyMailPreset(ctx, preset); err != nil {
+			p.logger.Warn("ipv64: could not list records for mail preset",
+				zap.String("domain", preset.Domain), zap.Error(err))
+		}
+	}
+}
+
+// applyMailPreset adds the records of preset the zone doesn't have and then
+// deletes the earlier versions they replace, e.g. after spf, dmarc_policy or
+// mx_priority changed, as two SPF or DMARC policies would break mail.
+func (p *Provider) applyMailPreset(ctx context.Context, preset *MailPreset) error {
+	p.records.invalidate(preset.Domain)
+	existing, err := p.listRecords(ctx, preset.Domain)
+	if err != nil {
+		return err
+	}
+	defer p.records.invalidate(preset.Domain)
+	for _, rec := range preset.records() {
+		var current bool
+		var outdated []ipv64.Record
+		for _, e := range existing {
+			if !rec.replaces(e) {
+				continue
+			}
+			if !current && e.Content == rec.Content {
+				current = true
+				continue
+			}
+			outdated = append(outdated, e)
+		}
+		if !current {
+			err := p.api.AddRecord(ctx, preset.Domain, rec.Prefix, rec.Type, rec.Content)
+			p.audit.record("mail_preset", "add", preset.Domain, rec.Prefix, rec.Type, rec.Content, err)
+			if err != nil {
+				p.logger.Warn("ipv64: could not create mail preset record",
+					zap.String("domain", preset.Domain),
+					zap.String("prefix", rec.Prefix),
+					zap.String("type", rec.Type),
+					zap.Error(err))
+				// keep the earlier version rather than none
+				continue
+			}
+			p.logger.Info("ipv64: created mail preset record",
+				zap.String("domain", preset.Domain),
+				zap.String("prefix", rec.Prefix),
+				zap.String("type", rec.Type))
+		}
+		for _, e := range outdated {
+			err := p.api.DelRecordByID(ctx, preset.Domain, e.ID)
+			p.audit.record("mail_preset", "delete", preset.Domain, rec.Prefix, rec.Type, e.Content, err)
+			if err != nil {
+				p.logger.Warn("ipv64: could not delete outdated mail preset record",
+					zap.String("domain", preset.Domain),
+					zap.String("prefix", rec.Prefix),
+					zap.String("type", rec.Type),
+					zap.Error(err))
+				continue
+			}
+			p.logger.Info("ipv64: replaced mail preset record",
+				zap.String("domain", preset.Domain),
+				zap.String("prefix", rec.Prefix),
+				zap.String("type", rec.Type))
+		}
+	}
+	return nil
+}
+
+// unmarshalMailPreset parses:
+//
+//	mail_preset <domain> {
+//	    mail_host <host>
+//	    mx_priority <n>
+//	    spf <policy>
+//	    dkim_selector <selector>
+//	    dkim_public_key <base64>
+//	    dkim_public_key_file <path>
+//	    dmarc_policy none|quarantine|reject
+//	    dmarc_rua <mailto:...>
+//	    autodiscover
+//	}
+func unmarshalMailPreset(d *caddyfile.Dispenser) (*MailPreset, error) {
+	m := new(MailPreset)
+	if !d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	m.Domain = d.Val()
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		opt := d.Val()
+		switch opt {
+		case "autodiscover":
+			m.Autodiscover = true
+			continue
+		case "mx_priority":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			prio, err := strconv.Atoi(d.Val())
+			if err != nil || prio < 0 || prio > 65535 {
+				return nil, d.Errf("invalid mx_priority: %s", d.Val())
+			}
+			m.MXPriority = &prio
+			continue
+		}
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		switch opt {
+		case "mail_host":
+			m.MailHost = d.Val()
+		case "spf":
+			m.SPF = d.Val()
+		case "dkim_selector":
+			m.DKIMSelector = d.Val()
+		case "dkim_public_key":
+			m.DKIMPublicKey = d.Val()
+		case "dkim_public_key_file":
+			m.DKIMPublicKeyFile = d.Val()
+		case "dmarc_policy":
+			m.DMARCPolicy = d.Val()
+		case "dmarc_rua":
+			m.DMARCReport = d.Val()
+		default:
+			return nil, d.Errf("unrecognized mail_preset option: %s", opt)
+		}
+	}
+	return m, nil
+}
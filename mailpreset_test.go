@@ -0,0 +1,82 @@
+package caddyipv64
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/Sickjuicy/caddy-ipv64/internal/ipv64api"
+)
+
+func TestApplyMailPresetReplacesChangedValues(t *testing.T) {
+	ctx := context.Background()
+	const domain = "example.ipv64.de"
+	srv := newTestFakeServer(t, new(FakeServer), domain)
+	client := &ipv64api.Client{Endpoint: srv.URL, APIPath: "/"}
+
+	// an unrelated TXT record and a backup MX must survive
+	for _, rec := range [][3]string{{"@", "TXT", "google-site-verification=abc"}, {"@", "MX", "20 backup.example.net"}} {
+		if err := client.AddRecord(ctx, domain, rec[0], rec[1], rec[2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	apply := func(m *MailPreset) []string {
+		t.Helper()
+		if err := m.provision(); err != nil {
+			t.Fatal(err)
+		}
+		p := &Provider{
+			logger:  zap.NewNop(),
+			records: &recordsCache{entries: make(map[string]recordsCacheEntry)},
+		}
+		p.SetAPIClient(client)
+		if err := p.applyMailPreset(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+		recs, err := client.ListRecords(ctx, domain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, rec := range recs {
+			got = append(got, rec.Praefix+" "+rec.Type+" "+rec.Content)
+		}
+		slices.Sort(got)
+		return got
+	}
+
+	apply(&MailPreset{Domain: domain})
+	prio := 0
+	got := apply(&MailPreset{Domain: domain, MXPriority: &prio, SPF: "v=spf1 a mx -all", DMARCPolicy: "reject"})
+	want := []string{
+		"@ MX 0 mail.example.ipv64.de",
+		"@ MX 20 backup.example.net",
+		"@ TXT google-site-verification=abc",
+		"@ TXT v=spf1 a mx -all",
+		"_dmarc TXT v=DMARC1; p=reject",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("records after changing the preset:\n got %q\nwant %q", got, want)
+	}
+
+	// applying the same preset again changes nothing
+	if again := apply(&MailPreset{Domain: domain, MXPriority: &prio, SPF: "v=spf1 a mx -all", DMARCPolicy: "reject"}); !slices.Equal(again, want) {
+		t.Errorf("records after applying again:\n got %q\nwant %q", again, want)
+	}
+}
+
+func TestTXTTag(t *testing.T) {
+	for content, want := range map[string]string{
+		"v=spf1 mx -all":               "v=spf1",
+		"v=DMARC1; p=none":             "v=DMARC1",
+		`"v=DKIM1; k=rsa; p=abc"`:      "v=DKIM1",
+		"google-site-verification=abc": "google-site-verification=abc",
+	} {
+		if got := txtTag(content); got != want {
+			t.Errorf("txtTag(%q) = %q, want %q", content, got, want)
+		}
+	}
+}
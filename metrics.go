@@ -0,0 +1,137 @@
+package caddyipv64
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Sickjuicy/caddy-ipv64/internal/ipv64api"
+)
+
+// ipv64Metrics are the provider metrics. They are labeled with the managed
+// zone, which keeps the cardinality bounded by the domains of the account;
+// records whose zone could not be determined are counted as "unknown".
+//
+// The DynDNS metrics are labeled with the updated domain. The time of the
+// last successful update is exported as a timestamp, so that alerts can use
+// time() - caddy_ipv64_dyndns_last_success_timestamp_seconds.
+var ipv64Metrics = struct {
+	once        sync.Once
+	records     *prometheus.CounterVec
+	propagation *prometheus.HistogramVec
+
+	dynUpdates     *prometheus.CounterVec
+	dynLastSuccess *prometheus.GaugeVec
+	dynIPChanges   *prometheus.CounterVec
+	dynFailures    *prometheus.GaugeVec
+}{}
+
+func initMetrics(registry *prometheus.Registry) {
+	const ns, sub = "caddy", "ipv64"
+	ipv64Metrics.once.Do(func() {
+		ipv64Metrics.records = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "record_operations_total",
+			Help:      "Record creations and deletions by managed zone and result.",
+		}, []string{"zone", "operation", "result"})
+		ipv64Metrics.propagation = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "propagation_seconds",
+			Help:      "Time until a created TXT record was visible on all polled servers.",
+			Buckets:   []float64{1, 2, 5, 10, 20, 30, 60, 120, 240},
+		}, []string{"zone", "authoritative"})
+		ipv64Metrics.dynUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "dyndns_updates_total",
+			Help:      "DynDNS updates by domain and return code (\"error\" if there was none).",
+		}, []string{"domain", "result"})
+		ipv64Metrics.dynLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "dyndns_last_success_timestamp_seconds",
+			Help:      "Unix time of the last successful DynDNS update.",
+		}, []string{"domain"})
+		ipv64Metrics.dynIPChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "dyndns_ip_changes_total",
+			Help:      "DynDNS updates that changed the address of a domain.",
+		}, []string{"domain"})
+		ipv64Metrics.dynFailures = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "dyndns_consecutive_failures",
+			Help:      "DynDNS updates that failed since the last successful one.",
+		}, []string{"domain"})
+	})
+	if registry == nil {
+		return
+	}
+	// every provider of a config registers the same collectors
+	for _, c := range []prometheus.Collector{
+		ipv64Metrics.records, ipv64Metrics.propagation,
+		ipv64Metrics.dynUpdates, ipv64Metrics.dynLastSuccess, ipv64Metrics.dynIPChanges, ipv64Metrics.dynFailures,
+	} {
+		if err := registry.Register(c); err != nil && !errors.As(err, new(prometheus.AlreadyRegisteredError)) {
+			panic(err)
+		}
+	}
+}
+
+func zoneLabel(zone string) string {
+	if zone == "" {
+		return "unknown"
+	}
+	return zone
+}
+
+// observeRecordOp counts a record creation ("append") or deletion ("delete") in zone.
+func observeRecordOp(zone, operation string, err error) {
+	if ipv64Metrics.records == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	ipv64Metrics.records.WithLabelValues(zoneLabel(zone), operation, result).Inc()
+}
+
+// observePropagation records how long a record in zone took to propagate.
+func observePropagation(zone string, authoritative bool, seconds float64) {
+	if ipv64Metrics.propagation == nil {
+		return
+	}
+	label := "false"
+	if authoritative {
+		label = "true"
+	}
+	ipv64Metrics.propagation.WithLabelValues(zoneLabel(zone), label).Observe(seconds)
+}
+
+// observeDynUpdate records the outcome of a DynDNS update of domain.
+func observeDynUpdate(domain string, res *ipv64api.DynResult, err error) {
+	if ipv64Metrics.dynUpdates == nil {
+		return
+	}
+	if err != nil {
+		result := "error"
+		var dynErr *ipv64api.DynError
+		if errors.As(err, &dynErr) {
+			result = string(dynErr.Result.Status)
+		}
+		ipv64Metrics.dynUpdates.WithLabelValues(domain, result).Inc()
+		ipv64Metrics.dynFailures.WithLabelValues(domain).Inc()
+		return
+	}
+	ipv64Metrics.dynUpdates.WithLabelValues(domain, string(res.Status)).Inc()
+	ipv64Metrics.dynLastSuccess.WithLabelValues(domain).SetToCurrentTime()
+	ipv64Metrics.dynFailures.WithLabelValues(domain).Set(0)
+	if res.Changed() {
+		ipv64Metrics.dynIPChanges.WithLabelValues(domain).Inc()
+	}
+}
@@ -0,0 +1,119 @@
+package caddyipv64
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/libdns/libdns"
+
+	"github.com/Sickjuicy/caddy-ipv64/ipv64"
+)
+
+// Provider modes.
+const (
+	modeLive = "live"
+	modeMock = "mock"
+)
+
+// mockStore is an in-memory zone store backing mode "mock". It is shared by
+// all provider instances of the process so records survive config reloads.
+type mockStore struct {
+	mu    sync.Mutex
+	zones map[string][]libdns.RR
+}
+
+var mockZones = &mockStore{zones: make(map[string][]libdns.RR)}
+
+func (m *mockStore) get(zone string) []libdns.Record {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return toRecords(m.zones[mockZoneKey(zone)])
+}
+
+func (m *mockStore) append(zone string, recs []libdns.Record) []libdns.Record {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := mockZoneKey(zone)
+	for _, r := range recs {
+		m.zones[key] = append(m.zones[key], r.RR())
+	}
+	return recs
+}
+
+func (m *mockStore) set(zone string, recs []libdns.Record) []libdns.Record {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := mockZoneKey(zone)
+	replaced := make(map[[2]string]bool)
+	for _, r := range recs {
+		rr := r.RR()
+		replaced[[2]string{rr.Name, rr.Type}] = true
+	}
+	var kept []libdns.RR
+	for _, rr := range m.zones[key] {
+		if !replaced[[2]string{rr.Name, rr.Type}] {
+			kept = append(kept, rr)
+		}
+	}
+	for _, r := range recs {
+		kept = append(kept, r.RR())
+	}
+	m.zones[key] = kept
+	return recs
+}
+
+// delete removes records following libdns semantics: empty type, TTL or data
+// in the input act as wildcards, the name must always match.
+func (m *mockStore) delete(zone string, recs []libdns.Record) []libdns.Record {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := mockZoneKey(zone)
+	var deleted []libdns.RR
+	var kept []libdns.RR
+	for _, have := range m.zones[key] {
+		match := false
+		for _, r := range recs {
+			want := r.RR()
+			if want.Name == have.Name &&
+				(want.Type == "" || want.Type == have.Type) &&
+				(want.TTL == 0 || want.TTL == have.TTL) &&
+				(want.Data == "" || want.Data == have.Data) {
+				match = true
+				break
+			}
+		}
+		if match {
+			deleted = append(deleted, have)
+		} else {
+			kept = append(kept, have)
+		}
+	}
+	m.zones[key] = kept
+	return toRecords(deleted)
+}
+
+// list returns the zones that currently hold records.
+func (m *mockStore) list() []libdns.Zone {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var zones []libdns.Zone
+	for name, rrs := range m.zones {
+		if len(rrs) > 0 {
+			zones = append(zones, libdns.Zone{Name: name})
+		}
+	}
+	return zones
+}
+
+func mockZoneKey(zone string) string {
+	return strings.ToLower(normalizeZone(zone))
+}
+
+// toRecords converts RRs into their typed libdns representation where possible.
+func toRecords(rrs []libdns.RR) []libdns.Record {
+	recs := make([]libdns.Record, 0, len(rrs))
+	for _, rr := range rrs {
+		recs = append(recs, ipv64.Typed(rr))
+	}
+	return recs
+}
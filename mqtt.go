@@ -0,0 +1,320 @@
+package caddyipv64
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
+	"go.uber.org/zap"
+)
+
+// MQTTPublisher is an events handler that publishes Caddy events, such as
+// ipv64.ip_changed or cert_obtained, to an MQTT broker. Home automation
+// systems like Home Assistant can subscribe to the topic instead of polling.
+//
+//	{
+//	    events {
+//	        on ipv64.ip_changed ipv64_mqtt {
+//	            broker tcp://homeassistant.lan:1883
+//	            topic caddy/{event.name}
+//	        }
+//	    }
+//	}
+type MQTTPublisher struct {
+	// Broker URL: tcp://host:1883, tls://host:8883 (mqtt:// and mqtts:// are accepted too).
+	Broker string `json:"broker,omitempty"`
+
+	// Topic to publish to; event placeholders such as {event.name} are replaced.
+	// Default: caddy/{event.name}
+	Topic string `json:"topic,omitempty"`
+
+	ClientID string `json:"client_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// QoS is 0 (default) or 1.
+	QoS    int  `json:"qos,omitempty"`
+	Retain bool `json:"retain,omitempty"`
+
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+
+	brokerURL *url.URL
+	logger    *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (MQTTPublisher) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "events.handlers.ipv64_mqtt",
+		New: func() caddy.Module { return new(MQTTPublisher) },
+	}
+}
+
+// Provision sets defaults and parses the broker URL.
+func (m *MQTTPublisher) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger(m)
+	if m.Topic == "" {
+		m.Topic = "caddy/{event.name}"
+	}
+	if m.ClientID == "" {
+		m.ClientID = "caddy-ipv64"
+	}
+	if m.TimeoutSeconds <= 0 {
+		m.TimeoutSeconds = 10
+	}
+	u, err := url.Parse(m.Broker)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid MQTT broker %q", m.Broker)
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt", "tls", "ssl", "mqtts":
+	default:
+		return fmt.Errorf("unsupported MQTT broker scheme %q", u.Scheme)
+	}
+	if u.Port() == "" {
+		port := "1883"
+		if u.Scheme != "tcp" && u.Scheme != "mqtt" {
+			port = "8883"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	m.brokerURL = u
+	return nil
+}
+
+// Validate validates the handler config.
+func (m *MQTTPublisher) Validate() error {
+	if m.QoS != 0 && m.QoS != 1 {
+		return fmt.Errorf("qos must be 0 or 1")
+	}
+	return nil
+}
+
+// Handle publishes the event as a CloudEvents JSON document.
+func (m *MQTTPublisher) Handle(ctx context.Context, e caddy.Event) error {
+	topic := m.Topic
+	if repl, ok := ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		topic = repl.ReplaceAll(topic, "")
+	}
+	payload, err := json.Marshal(e.CloudEvent())
+	if err != nil {
+		return err
+	}
+	if err := m.publish(ctx, topic, payload); err != nil {
+		m.logger.Error("publishing event to MQTT broker",
+			zap.String("broker", m.brokerURL.Host),
+			zap.String("topic", topic),
+			zap.Error(err))
+		return err
+	}
+	m.logger.Debug("published event to MQTT broker", zap.String("topic", topic), zap.String("event", e.Name()))
+	return nil
+}
+
+// publish opens a short-lived MQTT 3.1.1 session and publishes one message.
+// Events are rare, so a persistent connection is not worth the complexity.
+func (m *MQTTPublisher) publish(ctx context.Context, topic string, payload []byte) error {
+	timeout := time.Duration(m.TimeoutSeconds) * time.Second
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if m.brokerURL.Scheme == "tcp" || m.brokerURL.Scheme == "mqtt" {
+		conn, err = dialer.DialContext(ctx, "tcp", m.brokerURL.Host)
+	} else {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: m.brokerURL.Hostname()}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", m.brokerURL.Host)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	r := bufio.NewReader(conn)
+
+	// CONNECT
+	var connFlags byte = 0x02 // clean session
+	var connPayload []byte
+	connPayload = appendMQTTString(connPayload, m.ClientID)
+	if m.Username != "" {
+		connFlags |= 0x80
+		connPayload = appendMQTTString(connPayload, m.Username)
+	}
+	if m.Password != "" {
+		connFlags |= 0x40
+		connPayload = appendMQTTString(connPayload, m.Password)
+	}
+	connect := appendMQTTString(nil, "MQTT")
+	connect = append(connect, 4, connFlags, 0, 30) // level 3.1.1, keepalive 30s
+	connect = append(connect, connPayload...)
+	if err := writeMQTTPacket(conn, 0x10, connect); err != nil {
+		return err
+	}
+	typ, body, err := readMQTTPacket(r)
+	if err != nil {
+		return fmt.Errorf("reading CONNACK: %v", err)
+	}
+	if typ != 0x20 || len(body) != 2 {
+		return fmt.Errorf("unexpected reply to CONNECT (packet type %#x)", typ)
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("broker refused connection (return code %d)", body[1])
+	}
+
+	// PUBLISH
+	header := byte(0x30) | byte(m.QoS<<1)
+	if m.Retain {
+		header |= 0x01
+	}
+	pub := appendMQTTString(nil, topic)
+	const packetID = 1
+	if m.QoS > 0 {
+		pub = binary.BigEndian.AppendUint16(pub, packetID)
+	}
+	pub = append(pub, payload...)
+	if err := writeMQTTPacket(conn, header, pub); err != nil {
+		return err
+	}
+	if m.QoS > 0 {
+		typ, body, err := readMQTTPacket(r)
+		if err != nil {
+			return fmt.Errorf("reading PUBACK: %v", err)
+		}
+		if typ != 0x40 || len(body) != 2 || binary.BigEndian.Uint16(body) != packetID {
+			return errors.New("broker did not acknowledge the message")
+		}
+	}
+
+	// DISCONNECT
+	return writeMQTTPacket(conn, 0xE0, nil)
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func writeMQTTPacket(w io.Writer, header byte, body []byte) error {
+	pkt := []byte{header}
+	// remaining length as variable byte integer
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		pkt = append(pkt, digit)
+		if n == 0 {
+			break
+		}
+	}
+	pkt = append(pkt, body...)
+	_, err := w.Write(pkt)
+	return err
+}
+
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; i < 4; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header & 0xF0, body, nil
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens. Syntax:
+//
+//	ipv64_mqtt {
+//	    broker <url>
+//	    topic <topic>
+//	    client_id <id>
+//	    username <user>
+//	    password <pass>
+//	    qos <0|1>
+//	    retain
+//	    timeout_seconds <n>
+//	}
+func (m *MQTTPublisher) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume handler name
+	if d.NextArg() {
+		m.Broker = d.Val()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "broker", "topic", "client_id", "username", "password":
+			opt := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			switch opt {
+			case "broker":
+				m.Broker = d.Val()
+			case "topic":
+				m.Topic = d.Val()
+			case "client_id":
+				m.ClientID = d.Val()
+			case "username":
+				m.Username = d.Val()
+			case "password":
+				m.Password = d.Val()
+			}
+		case "qos", "timeout_seconds":
+			opt := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			var v int
+			if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+				return d.Errf("invalid %s: %s", opt, d.Val())
+			}
+			if opt == "qos" {
+				m.QoS = v
+			} else {
+				m.TimeoutSeconds = v
+			}
+		case "retain":
+			m.Retain = true
+		default:
+			return d.Errf("unrecognized option: %s", d.Val())
+		}
+	}
+	return nil
+}
+
+func init() {
+	caddy.RegisterModule(MQTTPublisher{})
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*MQTTPublisher)(nil)
+	_ caddy.Validator       = (*MQTTPublisher)(nil)
+	_ caddyevents.Handler   = (*MQTTPublisher)(nil)
+	_ caddyfile.Unmarshaler = (*MQTTPublisher)(nil)
+)
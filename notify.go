@@ -0,0 +1,404 @@
+package caddyipv64
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
+	"go.uber.org/zap"
+)
+
+// Notifier sends a short push message. Backends are modules in the
+// ipv64.notifiers namespace.
+type Notifier interface {
+	Notify(ctx context.Context, title, message string) error
+}
+
+// Notify is an events handler that turns events such as ipv64.record_failed,
+// ipv64.dyndns_failing or cert_failed into push messages or emails, for setups that
+// don't run a full alerting stack.
+//
+//	{
+//	    events {
+//	        on ipv64.record_failed ipv64_notify {
+//	            ntfy https://ntfy.sh/my-caddy
+//	        }
+//	        on ipv64.dyndns_failing ipv64_notify {
+//	            telegram {env.TELEGRAM_BOT_TOKEN} 123456789
+//	            discord {env.DISCORD_WEBHOOK}
+//	        }
+//	        on ipv64.provider_failing ipv64_notify {
+//	            email smtp.example.com {
+//	                from caddy@example.com
+//	                to admin@example.com
+//	            }
+//	        }
+//	    }
+//	}
+type Notify struct {
+	// Backends receive every message.
+	BackendsRaw []json.RawMessage `json:"backends,omitempty" caddy:"namespace=ipv64.notifiers inline_key=backend"`
+
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+
+	backends []Notifier
+	logger   *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (Notify) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "events.handlers.ipv64_notify",
+		New: func() caddy.Module { return new(Notify) },
+	}
+}
+
+// Provision loads the backends.
+func (n *Notify) Provision(ctx caddy.Context) error {
+	n.logger = ctx.Logger(n)
+	if n.TimeoutSeconds <= 0 {
+		n.TimeoutSeconds = 10
+	}
+	mods, err := ctx.LoadModule(n, "BackendsRaw")
+	if err != nil {
+		return fmt.Errorf("loading notifier backends: %v", err)
+	}
+	for _, mod := range mods.([]any) {
+		n.backends = append(n.backends, mod.(Notifier))
+	}
+	return nil
+}
+
+// Validate ensures at least one backend is configured.
+func (n *Notify) Validate() error {
+	if len(n.backends) == 0 {
+		return fmt.Errorf("no notifier backends configured")
+	}
+	return nil
+}
+
+// Handle sends the event to all backends. A failing backend does not keep
+// the others from being notified.
+func (n *Notify) Handle(ctx context.Context, e caddy.Event) error {
+	title, message := notification(e.Name(), e.Data)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(n.TimeoutSeconds)*time.Second)
+	defer cancel()
+	var failed error
+	for _, b := range n.backends {
+		if err := b.Notify(ctx, title, message); err != nil {
+			n.logger.Error("sending notification",
+				zap.String("event", e.Name()),
+				zap.String("backend", caddy.GetModuleName(b)),
+				zap.Error(err))
+			failed = err
+		}
+	}
+	return failed
+}
+
+// notification renders an event as a title and a human-readable message.
+func notification(name string, data map[string]any) (string, string) {
+	str := func(key string) string { return fmt.Sprint(data[key]) }
+	switch name {
+	case "ipv64.record_failed":
+		return "ipv64: DNS record change failed",
+			fmt.Sprintf("%s of %s record %s failed: %s", str("operation"), str("type"), str("name"), str("error"))
+	case "ipv64.provider_failing":
+		return "ipv64: DNS record changes failing",
+			fmt.Sprintf("%s record changes failed in a row: %s", str("failures"), str("error"))
+	case "ipv64.provider_recovered":
+		return "ipv64: DNS record changes recovered",
+			fmt.Sprintf("a record change succeeded after %s failures", str("failures"))
+	case "ipv64.dyndns_failing":
+		return "ipv64: DynDNS updates failing",
+			fmt.Sprintf("%s failed %s times in a row: %s", str("domain"), str("failures"), str("error"))
+	case "ipv64.dyndns_recovered":
+		return "ipv64: DynDNS updates recovered",
+			fmt.Sprintf("%s updated successfully after %s failures", str("domain"), str("failures"))
+	case "ipv64.dyndns_halted":
+		return "ipv64: DynDNS updates halted",
+			fmt.Sprintf("%s returned %s; fix the update key and reload the config", str("domain"), str("status"))
+	case "cert_failed":
+		return "Certificate failed",
+			fmt.Sprintf("obtaining or renewing the certificate for %s failed: %s", str("identifier"), str("error"))
+	}
+	var b strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(data)) {
+		fmt.Fprintf(&b, "%s: %v\n", k, data[k])
+	}
+	return "caddy: " + name, strings.TrimSpace(b.String())
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens. Syntax:
+//
+//	ipv64_notify {
+//	    ntfy <topic_url> [<access_token>]
+//	    telegram <bot_token> <chat_id>
+//	    discord <webhook_url>
+//	    email <server> {
+//	        ...
+//	    }
+//	    timeout_seconds <n>
+//	}
+func (n *Notify) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume handler name
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "timeout_seconds":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			var v int
+			if _, err := fmt.Sscanf(d.Val(), "%d", &v); err != nil || v < 0 {
+				return d.Errf("invalid timeout_seconds: %s", d.Val())
+			}
+			n.TimeoutSeconds = v
+		default:
+			name := d.Val()
+			unm, err := caddyfile.UnmarshalModule(d, "ipv64.notifiers."+name)
+			if err != nil {
+				return err
+			}
+			n.BackendsRaw = append(n.BackendsRaw, caddyconfig.JSONModuleObject(unm, "backend", name, nil))
+		}
+	}
+	return nil
+}
+
+// sendNotification sends req and fails on a non-2xx status. The URL is left
+// out of errors, since some backends carry their credentials in it.
+func sendNotification(req *http.Request) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// postNotification POSTs v as JSON to endpoint.
+func postNotification(ctx context.Context, endpoint string, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return sendNotification(req)
+}
+
+// NtfyNotifier publishes messages to an ntfy topic.
+type NtfyNotifier struct {
+	// URL of the topic, e.g. https://ntfy.sh/my-caddy.
+	URL string `json:"url,omitempty"`
+
+	// Token is an optional access token for protected topics.
+	Token string `json:"token,omitempty"`
+
+	// Priority is the ntfy message priority, 1-5 (default 4, "high").
+	Priority int `json:"priority,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (NtfyNotifier) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "ipv64.notifiers.ntfy",
+		New: func() caddy.Module { return new(NtfyNotifier) },
+	}
+}
+
+// Provision sets defaults.
+func (n *NtfyNotifier) Provision(caddy.Context) error {
+	if n.Priority == 0 {
+		n.Priority = 4
+	}
+	return nil
+}
+
+// Validate validates the config.
+func (n *NtfyNotifier) Validate() error {
+	if n.URL == "" {
+		return fmt.Errorf("ntfy: url is required")
+	}
+	if n.Priority < 1 || n.Priority > 5 {
+		return fmt.Errorf("ntfy: invalid priority %d (must be 1-5)", n.Priority)
+	}
+	return nil
+}
+
+// Notify publishes the message.
+func (n *NtfyNotifier) Notify(ctx context.Context, title, message string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, strings.NewReader(message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", title)
+	req.Header.Set("Priority", fmt.Sprint(n.Priority))
+	req.Header.Set("Tags", "warning")
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	return sendNotification(req)
+}
+
+// UnmarshalCaddyfile parses:
+//
+//	ntfy <url> [<token>] {
+//	    priority <1-5>
+//	}
+func (n *NtfyNotifier) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume backend name
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	n.URL = d.Val()
+	if d.NextArg() {
+		n.Token = d.Val()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "priority":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			if _, err := fmt.Sscanf(d.Val(), "%d", &n.Priority); err != nil {
+				return d.Errf("invalid priority: %s", d.Val())
+			}
+		default:
+			return d.Errf("unrecognized ntfy option: %s", d.Val())
+		}
+	}
+	return nil
+}
+
+// TelegramNotifier sends messages through a Telegram bot.
+type TelegramNotifier struct {
+	BotToken string `json:"bot_token,omitempty"`
+	ChatID   string `json:"chat_id,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (TelegramNotifier) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "ipv64.notifiers.telegram",
+		New: func() caddy.Module { return new(TelegramNotifier) },
+	}
+}
+
+// Validate validates the config.
+func (t *TelegramNotifier) Validate() error {
+	if t.BotToken == "" || t.ChatID == "" {
+		return fmt.Errorf("telegram: bot_token and chat_id are required")
+	}
+	return nil
+}
+
+// Notify sends the message to the chat.
+func (t *TelegramNotifier) Notify(ctx context.Context, title, message string) error {
+	return postNotification(ctx, "https://api.telegram.org/bot"+t.BotToken+"/sendMessage", map[string]string{
+		"chat_id": t.ChatID,
+		"text":    title + "\n" + message,
+	})
+}
+
+// UnmarshalCaddyfile parses:
+//
+//	telegram <bot_token> <chat_id>
+func (t *TelegramNotifier) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume backend name
+	if !d.Args(&t.BotToken, &t.ChatID) || d.NextArg() {
+		return d.ArgErr()
+	}
+	return nil
+}
+
+// DiscordNotifier posts messages to a Discord channel webhook.
+type DiscordNotifier struct {
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (DiscordNotifier) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "ipv64.notifiers.discord",
+		New: func() caddy.Module { return new(DiscordNotifier) },
+	}
+}
+
+// Validate validates the config.
+func (dn *DiscordNotifier) Validate() error {
+	if dn.WebhookURL == "" {
+		return fmt.Errorf("discord: webhook_url is required")
+	}
+	return nil
+}
+
+// Notify posts the message to the webhook.
+func (dn *DiscordNotifier) Notify(ctx context.Context, title, message string) error {
+	return postNotification(ctx, dn.WebhookURL, map[string]string{
+		"content": "**" + title + "**\n" + message,
+	})
+}
+
+// UnmarshalCaddyfile parses:
+//
+//	discord <webhook_url>
+func (dn *DiscordNotifier) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume backend name
+	if !d.Args(&dn.WebhookURL) || d.NextArg() {
+		return d.ArgErr()
+	}
+	return nil
+}
+
+func init() {
+	caddy.RegisterModule(Notify{})
+	caddy.RegisterModule(NtfyNotifier{})
+	caddy.RegisterModule(TelegramNotifier{})
+	caddy.RegisterModule(DiscordNotifier{})
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*Notify)(nil)
+	_ caddy.Validator       = (*Notify)(nil)
+	_ caddyevents.Handler   = (*Notify)(nil)
+	_ caddyfile.Unmarshaler = (*Notify)(nil)
+
+	_ Notifier              = (*NtfyNotifier)(nil)
+	_ caddy.Provisioner     = (*NtfyNotifier)(nil)
+	_ caddy.Validator       = (*NtfyNotifier)(nil)
+	_ caddyfile.Unmarshaler = (*NtfyNotifier)(nil)
+
+	_ Notifier              = (*TelegramNotifier)(nil)
+	_ caddy.Validator       = (*TelegramNotifier)(nil)
+	_ caddyfile.Unmarshaler = (*TelegramNotifier)(nil)
+
+	_ Notifier              = (*DiscordNotifier)(nil)
+	_ caddy.Validator       = (*DiscordNotifier)(nil)
+	_ caddyfile.Unmarshaler = (*DiscordNotifier)(nil)
+)
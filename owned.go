@@ -0,0 +1,110 @@
+package caddyipv64
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(MatchOwnedHost{})
+}
+
+// ownsHost reports whether host is one of the account's domains or below
+// one of them, using the provider's cached domain list.
+func (p *Provider) ownsHost(ctx context.Context, host string) (bool, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return false, nil
+	}
+	zones, err := p.ListZones(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, z := range zones {
+		d := strings.ToLower(strings.TrimSuffix(z.Name, "."))
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MatchOwnedHost matches requests whose Host is a domain of the ipv64
+// account, or a subdomain of one. The domain list is cached like the DNS
+// provider's (domains_cache_ttl, default 1h).
+//
+//	@owned ipv64_owned_host {
+//	    api_token {env.IPV64_API_TOKEN}
+//	}
+type MatchOwnedHost struct {
+	// Provider is the account whose domains are matched; it takes the
+	// options of the ipv64 DNS provider, of which api_token is required.
+	Provider *Provider `json:"provider,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (MatchOwnedHost) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.matchers.ipv64_owned_host",
+		New: func() caddy.Module { return new(MatchOwnedHost) },
+	}
+}
+
+// Provision sets up the account's provider.
+func (m *MatchOwnedHost) Provision(ctx caddy.Context) error {
+	if m.Provider == nil {
+		m.Provider = new(Provider)
+	}
+	if err := m.Provider.Provision(ctx); err != nil {
+		return err
+	}
+	return m.Provider.Validate()
+}
+
+// Cleanup releases the provider's resources.
+func (m *MatchOwnedHost) Cleanup() error {
+	if m.Provider == nil {
+		return nil
+	}
+	return m.Provider.Cleanup()
+}
+
+// Match returns true if the request's host is owned by the account.
+func (m MatchOwnedHost) Match(r *http.Request) bool {
+	match, _ := m.MatchWithError(r)
+	return match
+}
+
+// MatchWithError returns true if the request's host is owned by the
+// account, and an error if the domain list is unavailable.
+func (m MatchOwnedHost) MatchWithError(r *http.Request) (bool, error) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	return m.Provider.ownsHost(r.Context(), host)
+}
+
+// UnmarshalCaddyfile sets up the matcher from Caddyfile tokens:
+//
+//	ipv64_owned_host {
+//	    <ipv64 DNS provider options>
+//	}
+func (m *MatchOwnedHost) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	m.Provider = new(Provider)
+	return m.Provider.UnmarshalCaddyfile(d)
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner                 = (*MatchOwnedHost)(nil)
+	_ caddy.CleanerUpper                = (*MatchOwnedHost)(nil)
+	_ caddyhttp.RequestMatcherWithError = (*MatchOwnedHost)(nil)
+	_ caddyfile.Unmarshaler             = (*MatchOwnedHost)(nil)
+)
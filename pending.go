@@ -0,0 +1,64 @@
+package caddyipv64
+
+import (
+	"context"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+
+	"github.com/Sickjuicy/caddy-ipv64/ipv64"
+)
+
+// pendingPool holds the registries of challenge records that were created but
+// not yet deleted. It lives in a usage pool keyed by API token, so a reload in
+// the middle of an issuance hands the registry to the new provider instance,
+// which can then clean up records created by the old one.
+var pendingPool = caddy.NewUsagePool()
+
+// pendingRecord and pendingRegistry are the challenge record refcounting
+// shared with the standalone ipv64.Provider.
+type (
+	pendingRecord   = ipv64.PendingRecord
+	pendingRegistry = ipv64.Pending
+)
+
+// pendingEntry puts a registry into pendingPool.
+type pendingEntry struct{ *pendingRegistry }
+
+// Destruct implements caddy.Destructor.
+func (pendingEntry) Destruct() error { return nil }
+
+// waitMinLifetime blocks until the pending record t has existed for
+// MinRecordLifetime. Records of unknown age are not delayed.
+func (p *Provider) waitMinLifetime(ctx context.Context, t pendingRecord) error {
+	if p.MinRecordLifetime <= 0 || t.Created.IsZero() {
+		return nil
+	}
+	wait := time.Until(t.Created.Add(time.Duration(p.MinRecordLifetime)))
+	if wait <= 0 {
+		return nil
+	}
+	if p.logger != nil {
+		p.logger.Debug("ipv64: keeping record for its minimum lifetime",
+			zap.String("prefix", t.Prefix), zap.String("zone", t.Managed), zap.Duration("wait", wait))
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// loadPendingRegistry returns the registry shared by all providers using token.
+// Callers must release it with pendingPool.Delete(token).
+func loadPendingRegistry(token string) (*pendingRegistry, error) {
+	val, _, err := pendingPool.LoadOrNew(token, func() (caddy.Destructor, error) {
+		return pendingEntry{new(pendingRegistry)}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.(pendingEntry).pendingRegistry, nil
+}
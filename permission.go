@@ -0,0 +1,87 @@
+package caddyipv64
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddytls"
+)
+
+func init() {
+	caddy.RegisterModule(Permission{})
+}
+
+// Permission allows on-demand TLS certificates only for the domains of the
+// ipv64 account and their subdomains, without an external "ask" service.
+//
+//	{
+//	    on_demand_tls {
+//	        permission ipv64 {
+//	            api_token {env.IPV64_API_TOKEN}
+//	        }
+//	    }
+//	}
+type Permission struct {
+	// Provider is the account whose domains are allowed; it takes the
+	// options of the ipv64 DNS provider, of which api_token is required.
+	Provider *Provider `json:"provider,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (Permission) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tls.permission.ipv64",
+		New: func() caddy.Module { return new(Permission) },
+	}
+}
+
+// Provision sets up the account's provider.
+func (p *Permission) Provision(ctx caddy.Context) error {
+	if p.Provider == nil {
+		p.Provider = new(Provider)
+	}
+	if err := p.Provider.Provision(ctx); err != nil {
+		return err
+	}
+	return p.Provider.Validate()
+}
+
+// Cleanup releases the provider's resources.
+func (p *Permission) Cleanup() error {
+	if p.Provider == nil {
+		return nil
+	}
+	return p.Provider.Cleanup()
+}
+
+// CertificateAllowed returns nil if name is owned by the account.
+func (p *Permission) CertificateAllowed(ctx context.Context, name string) error {
+	owned, err := p.Provider.ownsHost(ctx, name)
+	if err != nil {
+		return fmt.Errorf("%s: checking ipv64 domains: %v", name, err)
+	}
+	if !owned {
+		return fmt.Errorf("%s: %w: not a domain of the ipv64 account", name, caddytls.ErrPermissionDenied)
+	}
+	return nil
+}
+
+// UnmarshalCaddyfile sets up the permission module from Caddyfile tokens:
+//
+//	permission ipv64 {
+//	    <ipv64 DNS provider options>
+//	}
+func (p *Permission) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	p.Provider = new(Provider)
+	return p.Provider.UnmarshalCaddyfile(d)
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner           = (*Permission)(nil)
+	_ caddy.CleanerUpper          = (*Permission)(nil)
+	_ caddytls.OnDemandPermission = (*Permission)(nil)
+	_ caddyfile.Unmarshaler       = (*Permission)(nil)
+)
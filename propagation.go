@@ -0,0 +1,306 @@
+package caddyipv64
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// Propagation modes.
+const (
+	propagationOff    = "off"
+	propagationDelay  = "delay"
+	propagationVerify = "verify"
+)
+
+// propagationTarget is a created record whose propagation is awaited. fqdn is
+// the name the CA queries and name the one the record was created at, which
+// differ if challenge_label or challenge_suffix delegate the challenge.
+type propagationTarget struct {
+	fqdn    string
+	name    string
+	managed string
+	rtype   string
+	value   string
+	created time.Time // when add_record succeeded
+}
+
+// propagationMode returns how to wait for a record at fqdn: the mode of the
+// most specific matching PropagationModes domain, else "verify" if resolver
+// or authoritative checks are enabled and "delay" otherwise.
+func (p *Provider) propagationMode(fqdn string) string {
+	var best, mode string
+	for domain, m := range p.PropagationModes {
+		if domainMatches(fqdn, domain) && len(domain) > len(best) {
+			best, mode = domain, m
+		}
+	}
+	if mode != "" {
+		return mode
+	}
+	if p.VerifyPropagation || p.CheckAuthoritative {
+		return propagationVerify
+	}
+	return propagationDelay
+}
+
+// awaitPropagation waits for created records according to their propagation
+// mode. Only TXT records can be verified; other types fall back to the delay.
+func (p *Provider) awaitPropagation(ctx context.Context, created []propagationTarget, changed map[string]bool) error {
+	var verify []propagationTarget
+	var delay bool
+	for _, t := range created {
+		switch mode := p.propagationMode(t.fqdn); {
+		case mode == propagationVerify && t.rtype == "TXT":
+			verify = append(verify, t)
+		case mode != propagationOff:
+			delay = true
+		}
+	}
+	if len(verify) == 0 && !delay {
+		return nil
+	}
+
+	if p.WaitForZoneSync {
+		for managed := range changed {
+			if err := p.waitForZoneSync(ctx, managed); err != nil {
+				return err
+			}
+		}
+	}
+	if len(verify) > 0 {
+		if p.CheckAuthoritative {
+			if err := p.waitForAuthoritative(ctx, verify); err != nil {
+				return err
+			}
+		}
+		if p.VerifyPropagation || !p.CheckAuthoritative {
+			if err := p.waitForPropagation(ctx, verify); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Wait for DNS propagation after creating records
+	if delay && p.CreateDelaySeconds > 0 {
+		if p.logger != nil {
+			p.logger.Debug("ipv64: waiting for DNS propagation after record creation",
+				zap.Int("delay_seconds", p.CreateDelaySeconds))
+		}
+		_, span := startSpan(ctx, "ipv64.propagation_delay", attribute.Int("seconds", p.CreateDelaySeconds))
+		select {
+		case <-time.After(time.Duration(p.CreateDelaySeconds) * time.Second):
+			endSpan(span, nil)
+		case <-ctx.Done():
+			endSpan(span, ctx.Err())
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// waitForPropagation polls the configured resolvers until every target is
+// visible on the quorum of them, or PropagationTimeout has passed.
+func (p *Provider) waitForPropagation(ctx context.Context, targets []propagationTarget) error {
+	return p.waitVisible(ctx, targets, p.Resolvers, p.propagationQuorum(), false)
+}
+
+// waitForAuthoritative polls the ipv64 nameservers directly, without
+// recursion, until every target is served by all of them.
+func (p *Provider) waitForAuthoritative(ctx context.Context, targets []propagationTarget) error {
+	return p.waitVisible(ctx, targets, p.AuthoritativeNameservers, len(p.AuthoritativeNameservers), true)
+}
+
+// waitVisible polls servers until every target is visible on quorum of them,
+// or PropagationTimeout has passed. Authoritative servers are asked for the
+// name the record was created at, resolvers for the name the CA looks up.
+func (p *Provider) waitVisible(ctx context.Context, targets []propagationTarget, servers []string, quorum int, authoritative bool) error {
+	deadline := time.Now().Add(time.Duration(p.PropagationTimeout))
+	for _, t := range targets {
+		name := t.fqdn
+		if authoritative {
+			name = t.name
+		}
+		if err := p.waitVisibleTarget(ctx, t, name, servers, quorum, authoritative, deadline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitVisibleTarget polls servers until the TXT record of t is visible at
+// name on quorum of them, or deadline has passed.
+func (p *Provider) waitVisibleTarget(ctx context.Context, t propagationTarget, name string, servers []string, quorum int, authoritative bool, deadline time.Time) (err error) {
+	ctx, span := startSpan(ctx, "ipv64.propagation",
+		attribute.String("name", name), attribute.Bool("authoritative", authoritative), attribute.Int("servers", len(servers)))
+	defer func() { endSpan(span, err) }()
+	seen := make(map[string]time.Duration) // server -> time until visible
+	for {
+		for _, server := range servers {
+			if _, ok := seen[server]; ok {
+				continue
+			}
+			values, err := lookupTXT(ctx, server, name, !authoritative)
+			if err != nil {
+				if p.logger != nil {
+					p.logger.Debug("ipv64: propagation check failed",
+						zap.String("fqdn", name), zap.String("server", server), zap.Error(err))
+				}
+				continue
+			}
+			for _, v := range values {
+				if v == t.value {
+					seen[server] = time.Since(t.created)
+					break
+				}
+			}
+		}
+		if len(seen) >= quorum {
+			p.reportPropagation(t.managed, name, seen, authoritative)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("TXT record %s visible on %d of %d servers after %s, %d required",
+				name, len(seen), len(servers), time.Duration(p.PropagationTimeout), quorum)
+		}
+		select {
+		case <-time.After(time.Duration(p.PropagationInterval)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// waitForZoneSync polls the SOA serial of managed on the authoritative
+// nameservers until all of them report the same serial, so that a change is
+// not validated against a lagging secondary.
+func (p *Provider) waitForZoneSync(ctx context.Context, managed string) error {
+	deadline := time.Now().Add(time.Duration(p.PropagationTimeout))
+	for {
+		serials := make(map[string]uint32)
+		for _, ns := range p.AuthoritativeNameservers {
+			serial, err := lookupSOASerial(ctx, ns, managed)
+			if err != nil {
+				if p.logger != nil {
+					p.logger.Debug("ipv64: SOA query failed", zap.String("zone", managed), zap.String("server", ns), zap.Error(err))
+				}
+				continue
+			}
+			serials[ns] = serial
+		}
+		if len(serials) == len(p.AuthoritativeNameservers) && sameSerial(serials) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("SOA serials of %s not in sync after %s: %v", managed, time.Duration(p.PropagationTimeout), serials)
+		}
+		select {
+		case <-time.After(time.Duration(p.PropagationInterval)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func sameSerial(serials map[string]uint32) bool {
+	var first uint32
+	i := 0
+	for _, s := range serials {
+		if i > 0 && s != first {
+			return false
+		}
+		first = s
+		i++
+	}
+	return true
+}
+
+// lookupSOASerial asks server (host:port) non-recursively for the SOA serial of zone.
+func lookupSOASerial(ctx context.Context, server, zone string) (uint32, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(zone), dns.TypeSOA)
+	m.RecursionDesired = false
+	in, err := exchange(ctx, server, m)
+	if err != nil {
+		return 0, err
+	}
+	for _, rr := range in.Answer {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial, nil
+		}
+	}
+	for _, rr := range in.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial, nil
+		}
+	}
+	return 0, fmt.Errorf("%s returned no SOA for %s", server, zone)
+}
+
+// reportPropagation logs and emits how long a record took to become visible
+// on each server, to help tuning propagation delays.
+func (p *Provider) reportPropagation(managed, name string, seen map[string]time.Duration, authoritative bool) {
+	var slowest time.Duration
+	fields := []zap.Field{zap.String("fqdn", name), zap.Bool("authoritative", authoritative)}
+	timings := make(map[string]any, len(seen))
+	for server, d := range seen {
+		fields = append(fields, zap.Duration(server, d))
+		timings[server] = d.Seconds()
+		slowest = max(slowest, d)
+	}
+	if p.logger != nil {
+		p.logger.Info("ipv64: TXT record propagated", append(fields, zap.Duration("after", slowest))...)
+	}
+	observePropagation(managed, authoritative, slowest.Seconds())
+	p.events.emit("ipv64.propagated", map[string]any{
+		"name":          name,
+		"authoritative": authoritative,
+		"seconds":       slowest.Seconds(),
+		"servers":       timings,
+	})
+}
+
+// prefixedName returns the name of the record with praefix prefix in managed.
+func prefixedName(prefix, managed string) string {
+	managed = strings.TrimSuffix(managed, ".")
+	if prefix == "@" || prefix == "" {
+		return managed
+	}
+	return prefix + "." + managed
+}
+
+// propagationQuorum returns how many resolvers must see a record: all of them
+// unless PropagationQuorum is set.
+func (p *Provider) propagationQuorum() int {
+	if p.PropagationQuorum > 0 && p.PropagationQuorum < len(p.Resolvers) {
+		return p.PropagationQuorum
+	}
+	return len(p.Resolvers)
+}
+
+// lookupTXT queries resolver for the TXT records of fqdn; see exchange for
+// the supported resolver formats.
+func lookupTXT(ctx context.Context, resolver, fqdn string, recursive bool) ([]string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(fqdn), dns.TypeTXT)
+	m.RecursionDesired = recursive
+	in, err := exchange(ctx, resolver, m)
+	if err != nil {
+		return nil, err
+	}
+	if in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("%s answered %s", resolver, dns.RcodeToString[in.Rcode])
+	}
+	var values []string
+	for _, rr := range in.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			values = append(values, strings.Join(txt.Txt, ""))
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,58 @@
+package caddyipv64
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// TestProviderRegisteredOnce guards against a second implementation
+// registering the DNS provider module ID.
+func TestProviderRegisteredOnce(t *testing.T) {
+	var n int
+	for _, id := range caddy.Modules() {
+		if id == "dns.providers.ipv64" {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Fatalf("dns.providers.ipv64 registered %d times, want 1", n)
+	}
+	info, err := caddy.GetModule("dns.providers.ipv64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := info.New().(*Provider); !ok {
+		t.Errorf("dns.providers.ipv64 is %T, want *Provider", info.New())
+	}
+}
+
+func TestIsBaseDomainCustomDomain(t *testing.T) {
+	// the account holds a custom domain besides an ipv64 one
+	p := &Provider{domainsMu: new(sync.Mutex), cachedDomains: []string{"example.com", "home.ipv64.de"}}
+	configured := &Provider{domainsMu: new(sync.Mutex), cachedDomains: p.cachedDomains, BaseDomains: []string{"dyn.example.org"}}
+	for _, tc := range []struct {
+		p      *Provider
+		domain string
+		want   bool
+	}{
+		{p, "com", false},
+		{p, "ipv64.de", true},
+		{p, "any64.net.", true},
+		{p, "example.com", false},
+		{configured, "dyn.example.org", true},
+		{configured, "ipv64.de", false},
+		{configured, "com", false},
+	} {
+		if got := tc.p.isBaseDomain(tc.domain); got != tc.want {
+			t.Errorf("isBaseDomain(%q) with base_domains %v = %v, want %v", tc.domain, tc.p.BaseDomains, got, tc.want)
+		}
+	}
+	if p.isRootIpv64Domain("example.com") {
+		t.Error("custom domain example.com taken for a name below an ipv64 base domain")
+	}
+	if p.isIpv64Domain("www.example.com") {
+		t.Error("name below a custom domain taken for an ipv64 name")
+	}
+}
@@ -0,0 +1,236 @@
+package caddyipv64
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// IP source types.
+const (
+	ipSourceIPv64     = "ipv64"     // ipv64.net's "what is my IP" service
+	ipSourceIpify     = "ipify"     // api.ipify.org / api6.ipify.org
+	ipSourceURL       = "url"       // any URL answering with the address as plain text
+	ipSourceInterface = "interface" // the public addresses of a network interface
+	ipSourceStatic    = "static"    // a fixed address
+	ipSourceSTUN      = "stun"      // a STUN server's view of this host
+	ipSourceUPnP      = "upnp"      // the WAN address of the router via UPnP IGD
+	ipSourceDNS       = "dns"       // a "what is my IP" DNS service
+)
+
+// ipSourceURLs are the IPv4 and IPv6 lookup URLs of the built-in services.
+var ipSourceURLs = map[string][2]string{
+	ipSourceIPv64: {"https://ipv4.ipv64.net/", "https://ipv6.ipv64.net/"},
+	ipSourceIpify: {"https://api.ipify.org/", "https://api6.ipify.org/"},
+}
+
+// IPSource is one way to determine the public address of this server.
+type IPSource struct {
+	// Type is "ipv64", "ipify", "url", "interface", "static", "stun", "upnp"
+	// or "dns".
+	Type string `json:"type"`
+
+	// Value is the URL, interface name or address for the types that need
+	// one. For stun, it is an optional STUN server (host:port); Google's and
+	// Cloudflare's are used if empty. For upnp, it is an optional URL of the
+	// router's device description; the router is discovered if empty. For
+	// dns, it is the service: "opendns" (default), "google" or "akamai".
+	Value string `json:"value,omitempty"`
+}
+
+func (s IPSource) validate() error {
+	switch s.Type {
+	case ipSourceIPv64, ipSourceIpify, ipSourceUPnP:
+		return nil
+	case ipSourceDNS:
+		if _, ok := dnsIPServices[s.Value]; s.Value != "" && !ok {
+			return fmt.Errorf("invalid dns ip source %q (must be opendns, google or akamai)", s.Value)
+		}
+		return nil
+	case ipSourceSTUN:
+		if s.Value != "" {
+			if _, _, err := net.SplitHostPort(s.Value); err != nil {
+				return fmt.Errorf("ip source stun: %v", err)
+			}
+		}
+		return nil
+	case ipSourceURL, ipSourceInterface:
+		if s.Value == "" {
+			return fmt.Errorf("ip source %s needs a value", s.Type)
+		}
+		return nil
+	case ipSourceStatic:
+		if _, err := netip.ParseAddr(s.Value); err != nil {
+			return fmt.Errorf("ip source static: %v", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown ip source %q", s.Type)
+	}
+}
+
+// lookup returns the addresses the source reports; either may be invalid.
+func (s IPSource) lookup(ctx context.Context) (ip4, ip6 netip.Addr, err error) {
+	switch s.Type {
+	case ipSourceIPv64, ipSourceIpify:
+		urls := ipSourceURLs[s.Type]
+		var err4, err6 error
+		ip4, err4 = fetchIP(ctx, urls[0])
+		ip6, err6 = fetchIP(ctx, urls[1])
+		if !ip4.Is4() {
+			ip4 = netip.Addr{}
+		}
+		if !ip6.Is6() {
+			ip6 = netip.Addr{}
+		}
+		if !ip4.IsValid() && !ip6.IsValid() {
+			return ip4, ip6, fmt.Errorf("%s: %v; %v", s.Type, err4, err6)
+		}
+		return ip4, ip6, nil
+	case ipSourceURL:
+		ip, err := fetchIP(ctx, s.Value)
+		if err != nil {
+			return ip4, ip6, err
+		}
+		if ip.Is4() {
+			return ip, ip6, nil
+		}
+		return ip4, ip, nil
+	case ipSourceInterface:
+		addrs, err := interfaceAddrs(s.Value)
+		if err != nil {
+			return ip4, ip6, err
+		}
+		for _, ip := range addrs {
+			if ip.Is4() && !ip4.IsValid() {
+				ip4 = ip
+			}
+			if ip.Is6() && !ip6.IsValid() {
+				ip6 = ip
+			}
+		}
+		if !ip4.IsValid() && !ip6.IsValid() {
+			return ip4, ip6, fmt.Errorf("no public address on %s", s.Value)
+		}
+		return ip4, ip6, nil
+	case ipSourceSTUN:
+		var servers []string
+		if s.Value != "" {
+			servers = []string{s.Value}
+		}
+		return lookupSTUN(ctx, servers)
+	case ipSourceDNS:
+		return lookupDNSIP(ctx, s.Value)
+	case ipSourceUPnP:
+		ip, err := lookupUPnP(ctx, s.Value)
+		if err != nil {
+			return ip4, ip6, err
+		}
+		if ip.Is4() {
+			return ip, ip6, nil
+		}
+		return ip4, ip, nil
+	case ipSourceStatic:
+		ip, err := netip.ParseAddr(s.Value)
+		if err != nil {
+			return ip4, ip6, err
+		}
+		if ip.Is4() {
+			return ip, ip6, nil
+		}
+		return ip4, ip, nil
+	}
+	return ip4, ip6, fmt.Errorf("unknown ip source %q", s.Type)
+}
+
+// fetchIP gets a URL that answers with an IP address as plain text.
+func fetchIP(ctx context.Context, u string) (netip.Addr, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	ip, err := netip.ParseAddr(strings.TrimSpace(string(body)))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%s: %v", u, err)
+	}
+	return ip.Unmap(), nil
+}
+
+// withInterface puts an interface source for iface, if set, in front of sources.
+func withInterface(iface string, sources []IPSource) []IPSource {
+	if iface == "" {
+		return sources
+	}
+	return append([]IPSource{{Type: ipSourceInterface, Value: iface}}, sources...)
+}
+
+// lookupPublicIP tries sources in order; the first source reporting an
+// address of a family wins for that family. Errors are only returned if no
+// source reported any address.
+func lookupPublicIP(ctx context.Context, sources []IPSource) (ip4, ip6 netip.Addr, err error) {
+	var errs []string
+	for _, s := range sources {
+		if ip4.IsValid() && ip6.IsValid() {
+			break
+		}
+		s4, s6, err := s.lookup(ctx)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if !ip4.IsValid() {
+			ip4 = s4
+		}
+		if !ip6.IsValid() {
+			ip6 = s6
+		}
+	}
+	if !ip4.IsValid() && !ip6.IsValid() && len(errs) > 0 {
+		return ip4, ip6, fmt.Errorf("no public IP found: %s", strings.Join(errs, "; "))
+	}
+	if ip4.IsValid() {
+		setCurrentIP(ip4.String())
+	} else if ip6.IsValid() {
+		setCurrentIP(ip6.String())
+	}
+	return ip4, ip6, nil
+}
+
+// unmarshalIPSource parses the arguments of an ip_source line:
+//
+//	ip_source <type> [<value>]
+func unmarshalIPSource(d *caddyfile.Dispenser) (IPSource, error) {
+	var s IPSource
+	if !d.NextArg() {
+		return s, d.ArgErr()
+	}
+	s.Type = d.Val()
+	if d.NextArg() {
+		s.Value = d.Val()
+	}
+	if err := s.validate(); err != nil {
+		return s, d.Err(err.Error())
+	}
+	return s, nil
+}
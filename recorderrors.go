@@ -0,0 +1,42 @@
+package caddyipv64
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/libdns/libdns"
+)
+
+// RecordError is the failure of a single record of a batch operation.
+type RecordError struct {
+	Record libdns.Record
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	rr := e.Record.RR()
+	return fmt.Sprintf("%s record %s: %v", rr.Type, rr.Name, e.Err)
+}
+
+func (e *RecordError) Unwrap() error { return e.Err }
+
+// RecordErrors lists the records of AppendRecords or DeleteRecords that
+// failed. The records returned along with it succeeded, so only these need
+// to be retried.
+type RecordErrors []*RecordError
+
+func (e RecordErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("ipv64: %d record(s) failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+func (e RecordErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
@@ -0,0 +1,79 @@
+package caddyipv64
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sickjuicy/caddy-ipv64/ipv64"
+)
+
+// recordsCache caches list_records results per managed zone.
+type recordsCache struct {
+	mu      sync.Mutex
+	entries map[string]recordsCacheEntry
+}
+
+type recordsCacheEntry struct {
+	records []ipv64.Record
+	fetched time.Time
+}
+
+func (c *recordsCache) get(managed string, ttl time.Duration) ([]ipv64.Record, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[managed]
+	if !ok || time.Since(e.fetched) > ttl {
+		return nil, false
+	}
+	return e.records, true
+}
+
+func (c *recordsCache) put(managed string, records []ipv64.Record) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[managed] = recordsCacheEntry{records: records, fetched: time.Now()}
+}
+
+// flush drops the cached records of all zones.
+func (c *recordsCache) flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+}
+
+// invalidate drops the cached records of a zone after a change.
+func (c *recordsCache) invalidate(managed string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, strings.ToLower(strings.TrimSuffix(managed, ".")))
+}
+
+// listRecords returns the records of a managed zone, from cache if fresh.
+func (p *Provider) listRecords(ctx context.Context, managed string) ([]ipv64.Record, error) {
+	managed = strings.ToLower(strings.TrimSuffix(managed, "."))
+	ttl := time.Duration(p.RecordsCacheSeconds) * time.Second
+	if recs, ok := p.records.get(managed, ttl); ok {
+		return recs, nil
+	}
+
+	recs, err := p.api.ListRecords(ctx, managed)
+	if err != nil {
+		return nil, err
+	}
+	p.records.put(managed, recs)
+	return recs, nil
+}
@@ -0,0 +1,59 @@
+package caddyipv64
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/Sickjuicy/caddy-ipv64/internal/ipv64api"
+)
+
+// redactLogger returns logger with the secrets of r masked in every
+// message and field, so tokens can't leak through error strings, response
+// bodies or form data that end up in the logs.
+func redactLogger(logger *zap.Logger, r *ipv64api.Redactor) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return redactCore{Core: core, r: r}
+	}))
+}
+
+// redactCore masks secrets before entries reach the wrapped core.
+type redactCore struct {
+	zapcore.Core
+	r *ipv64api.Redactor
+}
+
+func (c redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactCore{Core: c.Core.With(c.fields(fields)), r: c.r}
+}
+
+func (c redactCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c redactCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	e.Message = c.r.String(e.Message)
+	return c.Core.Write(e, c.fields(fields))
+}
+
+// fields returns a copy of fields with secrets masked in string, byte
+// string and error values.
+func (c redactCore) fields(fields []zapcore.Field) []zapcore.Field {
+	out := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		switch f.Type {
+		case zapcore.StringType:
+			f.String = c.r.String(f.String)
+		case zapcore.ByteStringType:
+			f = zap.String(f.Key, c.r.String(string(f.Interface.([]byte))))
+		case zapcore.ErrorType:
+			if err, ok := f.Interface.(error); ok {
+				f.Interface = c.r.Error(err)
+			}
+		}
+		out[i] = f
+	}
+	return out
+}
@@ -0,0 +1,257 @@
+package caddyipv64
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+
+	"github.com/Sickjuicy/caddy-ipv64/internal/ipv64api"
+)
+
+// defaultRelayTemplate mirrors the update URL ipv64.net documents for Fritz!Box
+// routers, so existing router entries only need a different host.
+const defaultRelayTemplate = "/nic/update?key=<pass>&domain=<domain>&ip=<ipaddr>&ip6=<ip6addr>"
+
+// relayPlaceholders maps router placeholders to ipv64 update parameters.
+// Placeholders without a parameter are used for authentication only.
+var relayPlaceholders = map[string]string{
+	"ipaddr":       "ip",
+	"ip6addr":      "ip6",
+	"ip6lanprefix": "ip6lanprefix",
+	"domain":       "domain",
+	"username":     "",
+	"pass":         "",
+}
+
+// Relay is an HTTP handler that accepts DynDNS updates from routers (e.g. a
+// Fritz!Box "custom DynDNS" entry) and relays them to ipv64.net, so the router
+// never needs to know the real ipv64 update key.
+type Relay struct {
+	// Token is the ipv64 DynDNS key used for the relayed updates.
+	Token string `json:"token,omitempty"`
+
+	// Domains lists the hostnames routers may update through this relay.
+	Domains []string `json:"domains,omitempty"`
+
+	// Username and Password authenticate the router (<username>/<pass>).
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// Endpoint overrides the base URL of the ipv64 API.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// UpdateURLTemplate is the update URL as configured in the router, with
+	// placeholders such as <ipaddr>, <ip6addr>, <ip6lanprefix>, <domain>, <username> and <pass>.
+	UpdateURLTemplate string `json:"update_url_template,omitempty"`
+
+	params map[string]string // placeholder -> query parameter name
+	logger *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (Relay) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ipv64_relay",
+		New: func() caddy.Module { return new(Relay) },
+	}
+}
+
+// Provision parses the update URL template.
+func (r *Relay) Provision(ctx caddy.Context) error {
+	r.logger = redactLogger(ctx.Logger(r), ipv64api.NewRedactor(r.Token, r.Password))
+	if r.UpdateURLTemplate == "" {
+		r.UpdateURLTemplate = defaultRelayTemplate
+	}
+	params, err := parseRelayTemplate(r.UpdateURLTemplate)
+	if err != nil {
+		return err
+	}
+	r.params = params
+	return nil
+}
+
+// Validate validates the relay config.
+func (r *Relay) Validate() error {
+	if r.Token == "" || len(r.Domains) == 0 {
+		return fmt.Errorf("token and domains must be set")
+	}
+	if r.Password == "" {
+		return fmt.Errorf("password must be set to authenticate routers")
+	}
+	return nil
+}
+
+// parseRelayTemplate returns which query parameter carries which placeholder.
+func parseRelayTemplate(tpl string) (map[string]string, error) {
+	_, rawQuery, _ := strings.Cut(tpl, "?")
+	params := make(map[string]string)
+	for _, pair := range strings.Split(rawQuery, "&") {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || !strings.HasPrefix(value, "<") || !strings.HasSuffix(value, ">") {
+			continue
+		}
+		placeholder := strings.ToLower(strings.Trim(value, "<>"))
+		if placeholder == "passwd" || placeholder == "password" {
+			placeholder = "pass"
+		}
+		if _, known := relayPlaceholders[placeholder]; !known {
+			return nil, fmt.Errorf("update_url_template: unknown placeholder <%s>", placeholder)
+		}
+		params[placeholder] = name
+	}
+	if _, ok := params["pass"]; !ok {
+		return nil, fmt.Errorf("update_url_template must contain <pass>")
+	}
+	return params, nil
+}
+
+// value returns the request value for a placeholder.
+func (r *Relay) value(q url.Values, placeholder string) string {
+	name, ok := r.params[placeholder]
+	if !ok {
+		return ""
+	}
+	return q.Get(name)
+}
+
+// ServeHTTP relays a router update request to ipv64.net and answers with the
+// DynDNS2 response, which routers understand.
+func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request, _ caddyhttp.Handler) error {
+	q := req.URL.Query()
+	user, pass := r.value(q, "username"), r.value(q, "pass")
+	if basicUser, basicPass, ok := req.BasicAuth(); ok && pass == "" {
+		user, pass = basicUser, basicPass
+	}
+	if subtle.ConstantTimeCompare([]byte(pass), []byte(r.Password)) != 1 ||
+		(r.Username != "" && subtle.ConstantTimeCompare([]byte(user), []byte(r.Username)) != 1) {
+		r.logger.Warn("ipv64 relay: rejected update with bad credentials", zap.String("remote", req.RemoteAddr))
+		http.Error(w, "badauth", http.StatusUnauthorized)
+		return nil
+	}
+
+	domains := strings.Split(r.value(q, "domain"), ",")
+	if len(domains) == 1 && domains[0] == "" {
+		domains = r.Domains
+	}
+	for _, domain := range domains {
+		if !r.allowed(domain) {
+			http.Error(w, "nohost", http.StatusForbidden)
+			return nil
+		}
+	}
+
+	params := url.Values{}
+	params.Set("domain", strings.Join(domains, ","))
+	for placeholder, param := range relayPlaceholders {
+		if param == "" || param == "domain" {
+			continue
+		}
+		if v := r.value(q, placeholder); v != "" {
+			params.Set(param, v)
+		}
+	}
+
+	res, err := dynDNSUpdate(req.Context(), r.Endpoint, dynAuth{key: r.Token}, params)
+	if err != nil && !errors.As(err, new(*ipv64api.DynError)) {
+		r.logger.Error("ipv64 relay: update failed", zap.Strings("domains", domains), zap.Error(err))
+		http.Error(w, "911", http.StatusBadGateway)
+		return nil
+	}
+	// return codes such as badauth are passed on to the client
+	r.logger.Info("ipv64 relay: update relayed",
+		zap.Strings("domains", domains),
+		zap.String("ip", params.Get("ip")),
+		zap.String("ip6", params.Get("ip6")),
+		zap.String("response", res.Raw))
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err = w.Write([]byte(res.Raw))
+	return err
+}
+
+func (r *Relay) allowed(domain string) bool {
+	for _, d := range r.Domains {
+		if strings.EqualFold(strings.TrimSuffix(domain, "."), strings.TrimSuffix(d, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalCaddyfile configures the relay from Caddyfile:
+//
+//	ipv64_relay {
+//	    token <key>
+//	    domains <domain...>
+//	    username <user>
+//	    password <pass>
+//	    update_url_template <template>
+//	}
+func (r *Relay) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "token":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				r.Token = d.Val()
+			case "domains":
+				r.Domains = append(r.Domains, d.RemainingArgs()...)
+				if len(r.Domains) == 0 {
+					return d.ArgErr()
+				}
+			case "username":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				r.Username = d.Val()
+			case "password":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				r.Password = d.Val()
+			case "endpoint":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				r.Endpoint = d.Val()
+			case "update_url_template":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				r.UpdateURLTemplate = d.Val()
+			default:
+				return d.Errf("unrecognized option: %s", d.Val())
+			}
+		}
+	}
+	return nil
+}
+
+func parseRelayCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var r Relay
+	err := r.UnmarshalCaddyfile(h.Dispenser)
+	return &r, err
+}
+
+func init() {
+	caddy.RegisterModule(Relay{})
+	httpcaddyfile.RegisterHandlerDirective("ipv64_relay", parseRelayCaddyfile)
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner           = (*Relay)(nil)
+	_ caddy.Validator             = (*Relay)(nil)
+	_ caddyhttp.MiddlewareHandler = (*Relay)(nil)
+	_ caddyfile.Unmarshaler       = (*Relay)(nil)
+)
@@ -0,0 +1,100 @@
+package caddyipv64
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// normalizeResolver adds the default port to a resolver address: 53 for
+// plain DNS and 853 for tls:// resolvers. IPv6 literals may be given with or
+// without brackets; https:// resolvers are used as given.
+func normalizeResolver(r string) string {
+	if strings.HasPrefix(r, "https://") {
+		return r
+	}
+	if addr, ok := strings.CutPrefix(r, "tls://"); ok {
+		return "tls://" + withDefaultPort(addr, "853")
+	}
+	return withDefaultPort(r, "53")
+}
+
+func withDefaultPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	return net.JoinHostPort(host, port)
+}
+
+// exchange sends m to server and returns the response. server is host:port
+// for plain DNS, tls://host[:port] for DNS-over-TLS or an https:// URL for
+// DNS-over-HTTPS.
+func exchange(ctx context.Context, server string, m *dns.Msg) (*dns.Msg, error) {
+	switch {
+	case strings.HasPrefix(server, "https://"):
+		return exchangeDoH(ctx, server, m)
+	case strings.HasPrefix(server, "tls://"):
+		addr := withDefaultPort(strings.TrimPrefix(server, "tls://"), "853")
+		host, _, _ := net.SplitHostPort(addr)
+		c := &dns.Client{
+			Net:       "tcp-tls",
+			Timeout:   5 * time.Second,
+			TLSConfig: &tls.Config{ServerName: host},
+		}
+		in, _, err := c.ExchangeContext(ctx, m, addr)
+		return in, err
+	}
+	c := &dns.Client{Timeout: 5 * time.Second}
+	in, _, err := c.ExchangeContext(ctx, m, server)
+	if err == nil && in.Truncated {
+		c.Net = "tcp"
+		in, _, err = c.ExchangeContext(ctx, m, server)
+	}
+	return in, err
+}
+
+// exchangeDoH performs an RFC 8484 DNS-over-HTTPS POST request.
+func exchangeDoH(ctx context.Context, url string, m *dns.Msg) (*dns.Msg, error) {
+	// DoH requests should use ID 0 to be cache friendly
+	q := m.Copy()
+	q.Id = 0
+	packed, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: HTTP %d", url, resp.StatusCode)
+	}
+	in := new(dns.Msg)
+	if err := in.Unpack(body); err != nil {
+		return nil, fmt.Errorf("%s: invalid DNS response: %v", url, err)
+	}
+	in.Id = m.Id
+	return in, nil
+}
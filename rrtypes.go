@@ -0,0 +1,71 @@
+package caddyipv64
+
+import (
+	"context"
+	"strings"
+
+	"github.com/libdns/libdns"
+	"go.uber.org/zap"
+
+	"github.com/Sickjuicy/caddy-ipv64/ipv64"
+)
+
+// recordID looks up the ID of the record just created with the given
+// praefix, type and content. It returns 0 if the record is not in the zone;
+// listed is false if the zone could not be listed at all.
+func (p *Provider) recordID(ctx context.Context, managed, prefix, rtype, content string) (id int, listed bool) {
+	p.records.invalidate(managed)
+	existing, err := p.listRecords(ctx, managed)
+	if err != nil {
+		if p.logger != nil {
+			p.logger.Debug("ipv64: looking up record ID failed", zap.String("zone", managed), zap.Error(err))
+		}
+		return 0, false
+	}
+	for _, rec := range existing {
+		// the newest record wins if the same value was added more than once
+		if ipv64.Matches(rec, prefix, libdns.RR{Type: rtype}) && rec.Content == content && rec.ID > id {
+			id = rec.ID
+		}
+	}
+	return id, true
+}
+
+// deleteTargets resolves a record to delete into the concrete records of the
+// zone, with their record IDs. Records with empty type or data match any type
+// or data, as defined by libdns. If the zone cannot be listed, a fully
+// specified record is still deleted by its content.
+func (p *Provider) deleteTargets(ctx context.Context, r libdns.Record, fqdn, managed, prefix string) ([]pendingRecord, error) {
+	rr := r.RR()
+	existing, err := p.listRecords(ctx, managed)
+	if err != nil {
+		if rr.Type == "" || rr.Data == "" {
+			return nil, err
+		}
+		value, err := ipv64.Content(rr)
+		if err != nil {
+			return nil, err
+		}
+		return []pendingRecord{{Managed: managed, Prefix: prefix, Type: ipv64.RecordType(rr), Value: value}}, nil
+	}
+	wantID := ipv64.ID(r)
+	var targets []pendingRecord
+	for _, rec := range existing {
+		if !ipv64.Matches(rec, prefix, rr) {
+			continue
+		}
+		if wantID != 0 && rec.ID != wantID {
+			continue
+		}
+		targets = append(targets, pendingRecord{ID: rec.ID, Managed: managed, Prefix: rec.Praefix, Type: strings.ToUpper(rec.Type), Value: rec.Content})
+	}
+	// An exact value that exists more than once belongs to several challenges
+	// (e.g. another instance sharing the account); remove only one of them.
+	if rr.Type != "" && rr.Data != "" && len(targets) > 1 {
+		targets = targets[len(targets)-1:]
+	}
+	if p.logger != nil && len(targets) == 0 {
+		p.logger.Debug("ipv64: no records match delete request", zap.String("fqdn", fqdn))
+	}
+	return targets, nil
+}
@@ -0,0 +1,89 @@
+package caddyipv64
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/libdns/libdns"
+)
+
+// errZoneNotHandled is returned for zones excluded by only_domains/ignore_domains
+// (or their aliases allowed_domains/denied_domains), so that another issuer or
+// provider can take over quickly instead of records being written elsewhere.
+var errZoneNotHandled = errors.New("zone not handled by this ipv64 provider")
+
+// checkScope returns errZoneNotHandled (wrapped) if name is outside of the
+// configured OnlyDomains or inside one of the IgnoreDomains.
+func (p *Provider) checkScope(name string) error {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, d := range p.IgnoreDomains {
+		if domainMatches(name, d) {
+			return fmt.Errorf("%w: %s is denied by %s", errZoneNotHandled, name, d)
+		}
+	}
+	if len(p.OnlyDomains) == 0 {
+		return nil
+	}
+	for _, d := range p.OnlyDomains {
+		if domainMatches(name, d) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not one of the allowed domains %v", errZoneNotHandled, name, p.OnlyDomains)
+}
+
+// checkZoneScope is checkScope for a zone, which is handled as long as some
+// names in it are: a subdomain in OnlyDomains admits its parent zone, and
+// only an IgnoreDomains entry covering the whole zone excludes it.
+func (p *Provider) checkZoneScope(zone string) error {
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	for _, d := range p.IgnoreDomains {
+		if domainMatches(zone, d) {
+			return fmt.Errorf("%w: %s is denied by %s", errZoneNotHandled, zone, d)
+		}
+	}
+	if len(p.OnlyDomains) == 0 {
+		return nil
+	}
+	for _, d := range p.OnlyDomains {
+		if domainMatches(zone, d) || domainMatches(d, zone) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not one of the allowed domains %v", errZoneNotHandled, zone, p.OnlyDomains)
+}
+
+// checkRecordsScope checks the zone and the name of every record in it.
+func (p *Provider) checkRecordsScope(zone string, recs []libdns.Record) error {
+	if err := p.checkZoneScope(zone); err != nil {
+		return err
+	}
+	for _, r := range recs {
+		if err := p.checkScope(libdns.AbsoluteName(r.RR().Name, zone)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inScope returns the records of zone whose names are in scope.
+func (p *Provider) inScope(zone string, recs []libdns.Record) []libdns.Record {
+	if len(p.OnlyDomains) == 0 && len(p.IgnoreDomains) == 0 {
+		return recs
+	}
+	var kept []libdns.Record
+	for _, r := range recs {
+		if p.checkScope(libdns.AbsoluteName(r.RR().Name, zone)) == nil {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// domainMatches reports whether name equals domain or is a subdomain of it.
+func domainMatches(name, domain string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
@@ -0,0 +1,115 @@
+package caddyipv64
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/libdns/libdns"
+)
+
+func TestCheckRecordsScope(t *testing.T) {
+	challenge := func(name string) []libdns.Record {
+		return []libdns.Record{libdns.TXT{Name: name, Text: "token"}}
+	}
+	for _, tc := range []struct {
+		name    string
+		p       Provider
+		zone    string
+		recs    []libdns.Record
+		handled bool
+	}{
+		{
+			name:    "ignored subdomain in parent zone",
+			p:       Provider{IgnoreDomains: []string{"sub.example.ipv64.de"}},
+			zone:    "example.ipv64.de.",
+			recs:    challenge("_acme-challenge.sub"),
+			handled: false,
+		},
+		{
+			name:    "sibling of ignored subdomain",
+			p:       Provider{IgnoreDomains: []string{"sub.example.ipv64.de"}},
+			zone:    "example.ipv64.de.",
+			recs:    challenge("_acme-challenge.www"),
+			handled: true,
+		},
+		{
+			name:    "ignored zone",
+			p:       Provider{IgnoreDomains: []string{"example.ipv64.de"}},
+			zone:    "example.ipv64.de.",
+			recs:    challenge("_acme-challenge"),
+			handled: false,
+		},
+		{
+			name:    "only subdomain admits parent zone",
+			p:       Provider{OnlyDomains: []string{"sub.example.ipv64.de"}},
+			zone:    "example.ipv64.de.",
+			recs:    challenge("_acme-challenge.sub"),
+			handled: true,
+		},
+		{
+			name:    "only subdomain rejects other names of parent zone",
+			p:       Provider{OnlyDomains: []string{"sub.example.ipv64.de"}},
+			zone:    "example.ipv64.de.",
+			recs:    challenge("_acme-challenge"),
+			handled: false,
+		},
+		{
+			name:    "only domain rejects other zone",
+			p:       Provider{OnlyDomains: []string{"example.ipv64.de"}},
+			zone:    "other.ipv64.de.",
+			recs:    challenge("_acme-challenge"),
+			handled: false,
+		},
+		{
+			name:    "any record out of scope rejects the batch",
+			p:       Provider{IgnoreDomains: []string{"sub.example.ipv64.de"}},
+			zone:    "example.ipv64.de.",
+			recs:    append(challenge("_acme-challenge"), challenge("_acme-challenge.sub")...),
+			handled: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.p.checkRecordsScope(tc.zone, tc.recs)
+			if tc.handled && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tc.handled && !errors.Is(err, errZoneNotHandled) {
+				t.Fatalf("got %v, want errZoneNotHandled", err)
+			}
+		})
+	}
+}
+
+func TestAppendRecordsScope(t *testing.T) {
+	ctx := context.Background()
+	p := &Provider{Mode: modeMock, IgnoreDomains: []string{"sub.scope-test.ipv64.de"}}
+	const zone = "scope-test.ipv64.de."
+
+	if _, err := p.AppendRecords(ctx, zone, []libdns.Record{libdns.TXT{Name: "_acme-challenge.sub", Text: "a"}}); !errors.Is(err, errZoneNotHandled) {
+		t.Fatalf("append below ignored subdomain: got %v, want errZoneNotHandled", err)
+	}
+	if _, err := p.AppendRecords(ctx, zone, []libdns.Record{libdns.TXT{Name: "_acme-challenge", Text: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// records of the ignored subdomain, e.g. written by another provider, are hidden
+	other := &Provider{Mode: modeMock}
+	if _, err := other.AppendRecords(ctx, zone, []libdns.Record{libdns.TXT{Name: "_acme-challenge.sub", Text: "c"}}); err != nil {
+		t.Fatal(err)
+	}
+	all, err := other.GetRecords(ctx, zone)
+	if err != nil || len(all) != 2 {
+		t.Fatalf("mock zone has %d records (%v), want 2", len(all), err)
+	}
+	recs, err := p.GetRecords(ctx, zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].RR().Name != "_acme-challenge" {
+		t.Errorf("GetRecords = %v, want only the record outside of the ignored subdomain", recs)
+	}
+	if _, err := p.DeleteRecords(ctx, zone, []libdns.Record{libdns.TXT{Name: "_acme-challenge.sub", Text: "c"}}); !errors.Is(err, errZoneNotHandled) {
+		t.Fatalf("delete below ignored subdomain: got %v, want errZoneNotHandled", err)
+	}
+}
@@ -0,0 +1,240 @@
+package caddyipv64
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// SMTP security modes of the email notifier.
+const (
+	smtpStartTLS = "starttls" // plain connection upgraded with STARTTLS (port 587)
+	smtpTLS      = "tls"      // implicit TLS (port 465)
+	smtpNone     = "none"     // no encryption, for relays on localhost or the LAN
+)
+
+// EmailNotifier sends messages by email through an SMTP server. Pair it with
+// ipv64.provider_failing and ipv64.dyndns_failing, which are only emitted
+// after a number of consecutive failures; Cooldown additionally limits how
+// often mails with the same subject are sent.
+type EmailNotifier struct {
+	// Server is the SMTP server as host:port (default port 587, or 465
+	// with Security "tls").
+	Server string `json:"server,omitempty"`
+
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	From string   `json:"from,omitempty"`
+	To   []string `json:"to,omitempty"`
+
+	// Security is "starttls" (default), "tls" or "none".
+	Security string `json:"security,omitempty"`
+
+	// Cooldown is the minimum time between two mails with the same subject
+	// (default 1h); messages within the cool-down are dropped.
+	Cooldown caddy.Duration `json:"cooldown,omitempty"`
+
+	mu   *sync.Mutex
+	sent map[string]time.Time // subject -> last mail
+}
+
+// CaddyModule returns the Caddy module information.
+func (EmailNotifier) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "ipv64.notifiers.email",
+		New: func() caddy.Module { return new(EmailNotifier) },
+	}
+}
+
+// Provision sets defaults.
+func (e *EmailNotifier) Provision(caddy.Context) error {
+	if e.Security == "" {
+		e.Security = smtpStartTLS
+	}
+	if _, _, err := net.SplitHostPort(e.Server); err != nil && e.Server != "" {
+		port := "587"
+		if e.Security == smtpTLS {
+			port = "465"
+		}
+		e.Server = net.JoinHostPort(e.Server, port)
+	}
+	if e.Cooldown <= 0 {
+		e.Cooldown = caddy.Duration(time.Hour)
+	}
+	e.mu = new(sync.Mutex)
+	e.sent = make(map[string]time.Time)
+	return nil
+}
+
+// Validate validates the config.
+func (e *EmailNotifier) Validate() error {
+	if e.Server == "" || e.From == "" || len(e.To) == 0 {
+		return fmt.Errorf("email: server, from and to are required")
+	}
+	if e.Security != smtpStartTLS && e.Security != smtpTLS && e.Security != smtpNone {
+		return fmt.Errorf("email: invalid security %q (must be %q, %q or %q)", e.Security, smtpStartTLS, smtpTLS, smtpNone)
+	}
+	return nil
+}
+
+// Notify sends the message, unless a mail with the same subject was sent
+// within the cool-down.
+func (e *EmailNotifier) Notify(ctx context.Context, title, message string) error {
+	e.mu.Lock()
+	if last, ok := e.sent[title]; ok && time.Since(last) < time.Duration(e.Cooldown) {
+		e.mu.Unlock()
+		return nil
+	}
+	e.sent[title] = time.Now()
+	e.mu.Unlock()
+
+	err := e.send(ctx, title, message)
+	if err != nil {
+		// let the next failure try again
+		e.mu.Lock()
+		delete(e.sent, title)
+		e.mu.Unlock()
+	}
+	return err
+}
+
+// send delivers one mail.
+func (e *EmailNotifier) send(ctx context.Context, subject, body string) error {
+	host, _, _ := net.SplitHostPort(e.Server)
+	tlsConfig := &tls.Config{ServerName: host}
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if e.Security == smtpTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", e.Server)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", e.Server)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if e.Security == smtpStartTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS: %v", err)
+		}
+	}
+	if e.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.Username, e.Password, host)); err != nil {
+			return fmt.Errorf("SMTP auth: %v", err)
+		}
+	}
+	if err := c.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s: %v", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// UnmarshalCaddyfile parses:
+//
+//	email <server> {
+//	    username <username>
+//	    password <password>
+//	    from <address>
+//	    to <addresses...>
+//	    security starttls|tls|none
+//	    cooldown <duration>
+//	}
+func (e *EmailNotifier) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume backend name
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	e.Server = d.Val()
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "username", "password", "from", "security":
+			opt := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			switch opt {
+			case "username":
+				e.Username = d.Val()
+			case "password":
+				e.Password = d.Val()
+			case "from":
+				e.From = d.Val()
+			case "security":
+				e.Security = d.Val()
+			}
+		case "to":
+			e.To = append(e.To, d.RemainingArgs()...)
+			if len(e.To) == 0 {
+				return d.ArgErr()
+			}
+		case "cooldown":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid cooldown: %v", err)
+			}
+			e.Cooldown = caddy.Duration(dur)
+		default:
+			return d.Errf("unrecognized email option: %s", d.Val())
+		}
+	}
+	return nil
+}
+
+func init() {
+	caddy.RegisterModule(EmailNotifier{})
+}
+
+// Interface guards
+var (
+	_ Notifier              = (*EmailNotifier)(nil)
+	_ caddy.Provisioner     = (*EmailNotifier)(nil)
+	_ caddy.Validator       = (*EmailNotifier)(nil)
+	_ caddyfile.Unmarshaler = (*EmailNotifier)(nil)
+)
@@ -0,0 +1,86 @@
+package caddyipv64
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxRecentErrors is how many errors a status document lists.
+const maxRecentErrors = 10
+
+// statusError is an error of a DynDNS update as listed in a status document.
+type statusError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// dynStatusDoc is the JSON status document of a DynDNS updater.
+type dynStatusDoc struct {
+	Domain       string        `json:"domain,omitempty"`
+	LastUpdate   *time.Time    `json:"last_update,omitempty"` // last attempt
+	LastResult   string        `json:"last_result,omitempty"` // response of the API, or the error
+	LastSuccess  *time.Time    `json:"last_success,omitempty"`
+	CurrentIP    string        `json:"current_ip,omitempty"`
+	Halted       string        `json:"halted,omitempty"` // return code that halted the updates
+	RecentErrors []statusError `json:"recent_errors"`
+}
+
+// dynStatus tracks the outcome of DynDNS updates for a status document.
+type dynStatus struct {
+	mu          sync.Mutex
+	lastUpdate  time.Time
+	lastResult  string
+	lastSuccess time.Time
+	errors      []statusError
+}
+
+// record notes the outcome of an update: the response body or the error.
+func (s *dynStatus) record(result string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	s.lastUpdate = now
+	if err != nil {
+		s.lastResult = err.Error()
+		s.errors = append(s.errors, statusError{Time: now, Error: err.Error()})
+		if len(s.errors) > maxRecentErrors {
+			s.errors = s.errors[len(s.errors)-maxRecentErrors:]
+		}
+		return
+	}
+	s.lastResult = result
+	s.lastSuccess = now
+}
+
+// doc returns the status document.
+func (s *dynStatus) doc(domain string, health *dynHealth) dynStatusDoc {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := dynStatusDoc{
+		Domain:       domain,
+		LastResult:   s.lastResult,
+		RecentErrors: append([]statusError{}, s.errors...),
+	}
+	if !s.lastUpdate.IsZero() {
+		t := s.lastUpdate
+		d.LastUpdate = &t
+	}
+	if !s.lastSuccess.IsZero() {
+		t := s.lastSuccess
+		d.LastSuccess = &t
+	}
+	d.CurrentIP, _ = currentIP.Load().(string)
+	if status, halted := health.degraded(); halted {
+		d.Halted = string(status)
+	}
+	return d
+}
+
+// serveStatus writes the status document as JSON.
+func serveStatus(w http.ResponseWriter, doc dynStatusDoc) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	return json.NewEncoder(w).Encode(doc)
+}
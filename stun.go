@@ -0,0 +1,171 @@
+package caddyipv64
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// defaultSTUNServers are asked by a stun IP source without a server.
+var defaultSTUNServers = []string{"stun.l.google.com:19302", "stun.cloudflare.com:3478"}
+
+// STUN message constants (RFC 5389).
+const (
+	stunBindingRequest   = 0x0001
+	stunBindingSuccess   = 0x0101
+	stunMagicCookie      = 0x2112A442
+	stunMappedAddress    = 0x0001
+	stunXORMappedAddress = 0x0020
+)
+
+// lookupSTUN asks the STUN servers for the reflexive address of this host,
+// over IPv4 and IPv6 separately. Unlike HTTP echo services, this works from
+// behind a CGNAT for the IPv6 address.
+func lookupSTUN(ctx context.Context, servers []string) (ip4, ip6 netip.Addr, err error) {
+	if len(servers) == 0 {
+		servers = defaultSTUNServers
+	}
+	var errs []error
+	for _, server := range servers {
+		if !ip4.IsValid() {
+			ip, err := stunBinding(ctx, "udp4", server)
+			if err == nil && ip.Is4() {
+				ip4 = ip
+			} else if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if !ip6.IsValid() {
+			ip, err := stunBinding(ctx, "udp6", server)
+			if err == nil && ip.Is6() {
+				ip6 = ip
+			} else if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if ip4.IsValid() && ip6.IsValid() {
+			break
+		}
+	}
+	if !ip4.IsValid() && !ip6.IsValid() {
+		return ip4, ip6, fmt.Errorf("stun: %w", errors.Join(errs...))
+	}
+	return ip4, ip6, nil
+}
+
+// stunBinding sends a binding request to server and returns the mapped address.
+func stunBinding(ctx context.Context, network, server string) (netip.Addr, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	req := make([]byte, 20)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	txID := req[8:20]
+	if _, err := rand.Read(txID); err != nil {
+		return netip.Addr{}, err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return netip.Addr{}, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("%s: %v", server, err)
+		}
+		msg := buf[:n]
+		if len(msg) < 20 || binary.BigEndian.Uint16(msg[0:]) != stunBindingSuccess || !bytes.Equal(msg[8:20], txID) {
+			continue // not our answer
+		}
+		ip, err := parseSTUNAddress(msg)
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("%s: %v", server, err)
+		}
+		return ip, nil
+	}
+}
+
+// parseSTUNAddress returns the (XOR-)MAPPED-ADDRESS of a binding response.
+func parseSTUNAddress(msg []byte) (netip.Addr, error) {
+	if len(msg) < 20 {
+		return netip.Addr{}, errors.New("short STUN response")
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	attrs := msg[20:]
+	if length < len(attrs) {
+		attrs = attrs[:length]
+	}
+	var mapped netip.Addr
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:])
+		alen := int(binary.BigEndian.Uint16(attrs[2:]))
+		if len(attrs) < 4+alen {
+			break
+		}
+		val := attrs[4 : 4+alen]
+		switch typ {
+		case stunXORMappedAddress:
+			if ip, ok := stunAddr(val, msg[4:20]); ok {
+				return ip, nil
+			}
+		case stunMappedAddress:
+			if ip, ok := stunAddr(val, nil); ok {
+				mapped = ip
+			}
+		}
+		// attributes are padded to 4 bytes, except possibly the last one
+		next := 4 + (alen+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	if mapped.IsValid() {
+		return mapped, nil
+	}
+	return netip.Addr{}, errors.New("no mapped address in STUN response")
+}
+
+// stunAddr decodes an address attribute; xor is the magic cookie followed by
+// the transaction ID for XOR-MAPPED-ADDRESS and nil for MAPPED-ADDRESS.
+func stunAddr(val, xor []byte) (netip.Addr, bool) {
+	if len(val) < 4 {
+		return netip.Addr{}, false
+	}
+	var size int
+	switch val[1] {
+	case 0x01:
+		size = 4
+	case 0x02:
+		size = 16
+	default:
+		return netip.Addr{}, false
+	}
+	if len(val) < 4+size {
+		return netip.Addr{}, false
+	}
+	raw := bytes.Clone(val[4 : 4+size])
+	for i := range raw {
+		if xor != nil {
+			raw[i] ^= xor[i]
+		}
+	}
+	ip, ok := netip.AddrFromSlice(raw)
+	return ip.Unmap(), ok
+}
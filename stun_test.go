@@ -0,0 +1,151 @@
+package caddyipv64
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+var stunTestTxID = []byte("0123456789ab")
+
+// stunTestMessage builds a binding response with the given attributes; the
+// header length is their total size.
+func stunTestMessage(attrs ...[]byte) []byte {
+	msg := make([]byte, 20)
+	binary.BigEndian.PutUint16(msg[0:], stunBindingSuccess)
+	binary.BigEndian.PutUint32(msg[4:], stunMagicCookie)
+	copy(msg[8:], stunTestTxID)
+	for _, a := range attrs {
+		msg = append(msg, a...)
+	}
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)-20))
+	return msg
+}
+
+// stunTestAttr encodes an attribute, padded to 4 bytes if pad is set.
+func stunTestAttr(typ uint16, val []byte, pad bool) []byte {
+	a := binary.BigEndian.AppendUint16(nil, typ)
+	a = binary.BigEndian.AppendUint16(a, uint16(len(val)))
+	a = append(a, val...)
+	for pad && len(a)%4 != 0 {
+		a = append(a, 0)
+	}
+	return a
+}
+
+// stunTestAddr encodes ip as (XOR-)MAPPED-ADDRESS value.
+func stunTestAddr(ip netip.Addr, xor bool) []byte {
+	family := byte(0x01)
+	if ip.Is6() {
+		family = 0x02
+	}
+	raw := ip.AsSlice()
+	if xor {
+		key := binary.BigEndian.AppendUint32(nil, stunMagicCookie)
+		key = append(key, stunTestTxID...)
+		for i := range raw {
+			raw[i] ^= key[i]
+		}
+	}
+	return append([]byte{0, family, 0x12, 0x34}, raw...)
+}
+
+func TestParseSTUNAddress(t *testing.T) {
+	v4 := netip.MustParseAddr("203.0.113.7")
+	v6 := netip.MustParseAddr("2001:db8::42")
+	other := netip.MustParseAddr("198.51.100.1")
+	software := stunTestAttr(0x8022, []byte("caddy"), true)
+
+	for _, tc := range []struct {
+		name    string
+		msg     []byte
+		want    netip.Addr
+		wantErr bool
+	}{
+		{
+			name: "xor-mapped ipv4",
+			msg:  stunTestMessage(stunTestAttr(stunXORMappedAddress, stunTestAddr(v4, true), true)),
+			want: v4,
+		},
+		{
+			name: "xor-mapped ipv6",
+			msg:  stunTestMessage(stunTestAttr(stunXORMappedAddress, stunTestAddr(v6, true), true)),
+			want: v6,
+		},
+		{
+			name: "mapped only",
+			msg:  stunTestMessage(software, stunTestAttr(stunMappedAddress, stunTestAddr(v4, false), true)),
+			want: v4,
+		},
+		{
+			name: "xor-mapped preferred",
+			msg: stunTestMessage(
+				stunTestAttr(stunMappedAddress, stunTestAddr(other, false), true),
+				software,
+				stunTestAttr(stunXORMappedAddress, stunTestAddr(v4, true), true),
+			),
+			want: v4,
+		},
+		{
+			name: "unpadded last attribute",
+			msg: stunTestMessage(
+				stunTestAttr(stunMappedAddress, stunTestAddr(v4, false), true),
+				stunTestAttr(0x8022, []byte("abc"), false),
+			),
+			want: v4,
+		},
+		{
+			name: "unpadded last address attribute",
+			msg:  stunTestMessage(stunTestAttr(stunXORMappedAddress, append(stunTestAddr(v4, true), 0xff), false)),
+			want: v4,
+		},
+		{
+			name: "truncated attribute",
+			msg: stunTestMessage(
+				stunTestAttr(stunMappedAddress, stunTestAddr(v4, false), true),
+				stunTestAttr(stunXORMappedAddress, stunTestAddr(other, true), true)[:10],
+			),
+			want: v4,
+		},
+		{
+			name:    "truncated address",
+			msg:     stunTestMessage(stunTestAttr(stunXORMappedAddress, stunTestAddr(v4, true), true)[:6]),
+			wantErr: true,
+		},
+		{
+			name:    "no address",
+			msg:     stunTestMessage(software),
+			wantErr: true,
+		},
+		{
+			name:    "short message",
+			msg:     stunTestMessage()[:12],
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseSTUNAddress(tc.msg)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseSTUNAddressLengthBeyondMessage(t *testing.T) {
+	msg := stunTestMessage(stunTestAttr(stunXORMappedAddress, stunTestAddr(netip.MustParseAddr("203.0.113.7"), true), true))
+	binary.BigEndian.PutUint16(msg[2:], 0xffff) // header claims more than was received
+	msg = msg[:len(msg)-2]
+	if _, err := parseSTUNAddress(msg); err == nil {
+		t.Fatal("want error for a truncated response")
+	}
+}
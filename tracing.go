@@ -0,0 +1,33 @@
+package caddyipv64
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/Sickjuicy/caddy-ipv64"
+
+// startSpan starts a span as child of the span in ctx. Caddy's tracing
+// handler does not install a global tracer provider, so the provider of the
+// parent span is used to join its trace; without one, e.g. for challenges
+// solved in the background, the global provider is used.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tp := otel.GetTracerProvider()
+	if parent := trace.SpanFromContext(ctx); parent.SpanContext().IsValid() {
+		tp = parent.TracerProvider()
+	}
+	return tp.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err, if any, and ends span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,178 @@
+package caddyipv64
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// upnpWANServices are the IGD services that can report the WAN address.
+var upnpWANServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// lookupUPnP asks the router for its WAN IPv4 address via UPnP IGD. If
+// location is empty, the router's device description is discovered with
+// SSDP on the local network.
+func lookupUPnP(ctx context.Context, location string) (netip.Addr, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if location == "" {
+		var err error
+		if location, err = discoverIGD(ctx); err != nil {
+			return netip.Addr{}, err
+		}
+	}
+	service, controlURL, err := igdControlURL(ctx, location)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return igdExternalIP(ctx, service, controlURL)
+}
+
+// discoverIGD sends an SSDP M-SEARCH for internet gateway devices and
+// returns the description URL of the first one that answers.
+func discoverIGD(ctx context.Context) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(3 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+	ssdp := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+	msg := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(msg), ssdp); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", fmt.Errorf("upnp: no internet gateway device found: %v", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if loc := resp.Header.Get("Location"); loc != "" {
+			return loc, nil
+		}
+	}
+}
+
+// igdDevice is the part of a UPnP device description that is searched for
+// the WAN connection service.
+type igdDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []igdDevice `xml:"deviceList>device"`
+}
+
+// igdControlURL fetches the device description at location and returns the
+// type and absolute control URL of its WAN connection service.
+func igdControlURL(ctx context.Context, location string) (service, controlURL string, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	var desc struct {
+		URLBase string    `xml:"URLBase"`
+		Device  igdDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&desc); err != nil {
+		return "", "", fmt.Errorf("upnp: device description: %v", err)
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return "", "", err
+	}
+	if desc.URLBase != "" {
+		if b, err := url.Parse(desc.URLBase); err == nil {
+			base = b
+		}
+	}
+	for _, want := range upnpWANServices {
+		if ctrl := findIGDService(desc.Device, want); ctrl != "" {
+			u, err := base.Parse(ctrl)
+			if err != nil {
+				return "", "", err
+			}
+			return want, u.String(), nil
+		}
+	}
+	return "", "", errors.New("upnp: router has no WAN connection service")
+}
+
+func findIGDService(d igdDevice, serviceType string) string {
+	for _, s := range d.Services {
+		if s.ServiceType == serviceType {
+			return strings.TrimSpace(s.ControlURL)
+		}
+	}
+	for _, sub := range d.Devices {
+		if ctrl := findIGDService(sub, serviceType); ctrl != "" {
+			return ctrl
+		}
+	}
+	return ""
+}
+
+// igdExternalIP calls GetExternalIPAddress of the WAN connection service.
+func igdExternalIP(ctx context.Context, service, controlURL string) (netip.Addr, error) {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:GetExternalIPAddress xmlns:u="` + service + `"/></s:Body></s:Envelope>`
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, controlURL, strings.NewReader(body))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+service+`#GetExternalIPAddress"`)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("upnp: GetExternalIPAddress: %s", resp.Status)
+	}
+	var env struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&env); err != nil {
+		return netip.Addr{}, fmt.Errorf("upnp: GetExternalIPAddress: %v", err)
+	}
+	ip, err := netip.ParseAddr(strings.TrimSpace(env.IP))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("upnp: router reported %q as WAN address", env.IP)
+	}
+	return ip.Unmap(), nil
+}
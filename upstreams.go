@@ -0,0 +1,244 @@
+package caddyipv64
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(Upstreams{})
+}
+
+// Upstreams is a dynamic upstream source for reverse_proxy that resolves an
+// ipv64 hostname through the ipv64 nameservers (or the given resolvers) and
+// caches the addresses for their record TTL, so proxying to another DynDNS
+// host follows its address changes right away.
+//
+//	reverse_proxy {
+//	    dynamic ipv64 home.example.ipv64.de 8080
+//	}
+type Upstreams struct {
+	// Name is the hostname to resolve.
+	Name string `json:"name,omitempty"`
+
+	// Port of the upstreams (default 80).
+	Port string `json:"port,omitempty"`
+
+	// Resolvers to query, in order (default ns1/ns2.ipv64.net).
+	Resolvers []string `json:"resolvers,omitempty"`
+
+	// Versions are the IP versions to resolve: "ipv4" (A) and/or "ipv6"
+	// (AAAA); default both.
+	Versions []string `json:"versions,omitempty"`
+
+	// MinTTL and MaxTTL bound how long resolved addresses are cached
+	// (default 10s and 5m).
+	MinTTL caddy.Duration `json:"min_ttl,omitempty"`
+	MaxTTL caddy.Duration `json:"max_ttl,omitempty"`
+
+	logger    *zap.Logger
+	mu        *sync.Mutex
+	addresses []string // host:port of the last lookup
+	expires   time.Time
+}
+
+// CaddyModule returns the Caddy module information.
+func (Upstreams) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.reverse_proxy.upstreams.ipv64",
+		New: func() caddy.Module { return new(Upstreams) },
+	}
+}
+
+// Provision sets defaults.
+func (u *Upstreams) Provision(ctx caddy.Context) error {
+	u.logger = ctx.Logger(u)
+	u.mu = new(sync.Mutex)
+	if u.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if u.Port == "" {
+		u.Port = "80"
+	}
+	if len(u.Resolvers) == 0 {
+		u.Resolvers = []string{"ns1.ipv64.net:53", "ns2.ipv64.net:53"}
+	}
+	for i, r := range u.Resolvers {
+		u.Resolvers[i] = normalizeResolver(r)
+	}
+	if len(u.Versions) == 0 {
+		u.Versions = []string{"ipv4", "ipv6"}
+	}
+	for _, v := range u.Versions {
+		if v != "ipv4" && v != "ipv6" {
+			return fmt.Errorf("invalid version %q (must be %q or %q)", v, "ipv4", "ipv6")
+		}
+	}
+	if u.MinTTL <= 0 {
+		u.MinTTL = caddy.Duration(10 * time.Second)
+	}
+	if u.MaxTTL <= 0 {
+		u.MaxTTL = caddy.Duration(5 * time.Minute)
+	}
+	if u.MaxTTL < u.MinTTL {
+		return fmt.Errorf("max_ttl %s is below min_ttl %s", time.Duration(u.MaxTTL), time.Duration(u.MinTTL))
+	}
+	return nil
+}
+
+// GetUpstreams returns the current addresses of the hostname. If a refresh
+// fails, the previous addresses are used until a lookup succeeds.
+func (u *Upstreams) GetUpstreams(r *http.Request) ([]*reverseproxy.Upstream, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if time.Now().After(u.expires) {
+		addrs, ttl, err := u.lookup(r.Context())
+		switch {
+		case err == nil:
+			u.addresses = addrs
+			u.expires = time.Now().Add(ttl)
+		case u.addresses != nil:
+			u.logger.Warn("ipv64: refreshing upstreams failed, using previous addresses",
+				zap.String("name", u.Name), zap.Error(err))
+			u.expires = time.Now().Add(time.Duration(u.MinTTL))
+		default:
+			return nil, err
+		}
+	}
+	upstreams := make([]*reverseproxy.Upstream, len(u.addresses))
+	for i, a := range u.addresses {
+		upstreams[i] = &reverseproxy.Upstream{Dial: a}
+	}
+	return upstreams, nil
+}
+
+// lookup resolves the hostname and returns its addresses with the port and
+// the time to cache them.
+func (u *Upstreams) lookup(ctx context.Context) ([]string, time.Duration, error) {
+	var addrs []string
+	ttl := time.Duration(u.MaxTTL)
+	for _, v := range u.Versions {
+		qtype := dns.TypeA
+		if v == "ipv6" {
+			qtype = dns.TypeAAAA
+		}
+		ips, recTTL, err := u.query(ctx, qtype)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip, u.Port))
+		}
+		if len(ips) > 0 {
+			ttl = min(ttl, recTTL)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, 0, fmt.Errorf("no addresses for %s", u.Name)
+	}
+	u.logger.Debug("ipv64: resolved upstreams", zap.String("name", u.Name), zap.Strings("addresses", addrs))
+	return addrs, max(ttl, time.Duration(u.MinTTL)), nil
+}
+
+// query asks the resolvers in order until one answers.
+func (u *Upstreams) query(ctx context.Context, qtype uint16) ([]string, time.Duration, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(u.Name), qtype)
+	var lastErr error
+	for _, server := range u.Resolvers {
+		in, err := exchange(ctx, server, m)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if in.Rcode != dns.RcodeSuccess {
+			lastErr = fmt.Errorf("%s: %s", server, dns.RcodeToString[in.Rcode])
+			continue
+		}
+		var ips []string
+		ttl := time.Duration(u.MaxTTL)
+		for _, rr := range in.Answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				ips = append(ips, rr.A.String())
+			case *dns.AAAA:
+				ips = append(ips, rr.AAAA.String())
+			default:
+				continue
+			}
+			ttl = min(ttl, time.Duration(rr.Header().Ttl)*time.Second)
+		}
+		return ips, ttl, nil
+	}
+	return nil, 0, fmt.Errorf("resolving %s: %v", u.Name, lastErr)
+}
+
+// UnmarshalCaddyfile sets up the upstream source from Caddyfile tokens:
+//
+//	dynamic ipv64 <name> [<port>] {
+//	    resolvers <addresses...>
+//	    versions ipv4|ipv6...
+//	    min_ttl <duration>
+//	    max_ttl <duration>
+//	}
+func (u *Upstreams) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume upstream source name
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	u.Name = d.Val()
+	if d.NextArg() {
+		u.Port = d.Val()
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "resolvers":
+			u.Resolvers = append(u.Resolvers, d.RemainingArgs()...)
+			if len(u.Resolvers) == 0 {
+				return d.ArgErr()
+			}
+		case "versions":
+			u.Versions = append(u.Versions, d.RemainingArgs()...)
+			if len(u.Versions) == 0 {
+				return d.ArgErr()
+			}
+		case "min_ttl", "max_ttl":
+			opt := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid %s: %v", opt, err)
+			}
+			if opt == "min_ttl" {
+				u.MinTTL = caddy.Duration(dur)
+			} else {
+				u.MaxTTL = caddy.Duration(dur)
+			}
+		default:
+			return d.Errf("unrecognized option: %s", d.Val())
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner           = (*Upstreams)(nil)
+	_ reverseproxy.UpstreamSource = (*Upstreams)(nil)
+	_ caddyfile.Unmarshaler       = (*Upstreams)(nil)
+)
@@ -0,0 +1,69 @@
+package caddyipv64
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ipChange is the JSON payload POSTed to the IP change webhook.
+type ipChange struct {
+	Domain    string    `json:"domain"`
+	OldIP     string    `json:"old_ip,omitempty"` // empty for the first change seen
+	NewIP     string    `json:"new_ip"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// postIPChange sends change to the webhook URL.
+func postIPChange(ctx context.Context, webhook string, change ipChange) error {
+	return postJSON(ctx, webhook, change)
+}
+
+// postJSON POSTs v as JSON to the webhook URL.
+func postJSON(ctx context.Context, webhook string, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s: %s", webhook, resp.Status)
+	}
+	return nil
+}
+
+// pingHeartbeat GETs an ipv64 healthcheck URL to signal that updates work.
+func pingHeartbeat(ctx context.Context, heartbeat string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, heartbeat, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("heartbeat: %s", resp.Status)
+	}
+	return nil
+}
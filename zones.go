@@ -0,0 +1,215 @@
+package caddyipv64
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"go.uber.org/zap"
+
+	"github.com/Sickjuicy/caddy-ipv64/ipv64"
+)
+
+// Praefix styles.
+const (
+	praefixFull       = "full"
+	praefixFirstLabel = "first_label"
+)
+
+// ListZones returns the domains of the account using the get_domains API.
+func (p *Provider) ListZones(ctx context.Context) (_ []libdns.Zone, err error) {
+	defer func() { err = p.redact.Error(err) }()
+	ctx = p.debugContext(ctx)
+	if p.Mode == modeMock {
+		return mockZones.list(), nil
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	domains, err := p.domains(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var zones []libdns.Zone
+	for _, d := range domains {
+		if p.checkZoneScope(d) != nil {
+			continue
+		}
+		zones = append(zones, libdns.Zone{Name: normalizeZone(d)})
+	}
+	return zones, nil
+}
+
+// domains returns the domains managed by the account. The list is fetched
+// with get_domains on first use and cached for DomainsCacheTTL; failures are
+// not cached, and an expired list is still used if refreshing it fails.
+func (p *Provider) domains(ctx context.Context) ([]string, error) {
+	p.domainsMu.Lock()
+	defer p.domainsMu.Unlock()
+	if p.domainsCached && time.Since(p.domainsFetched) < time.Duration(p.DomainsCacheTTL) {
+		return p.cachedDomains, nil
+	}
+	domains, err := p.fetchDomains(ctx)
+	if err != nil && p.domainsCached {
+		if p.logger != nil {
+			p.logger.Warn("ipv64: refreshing domain list failed, using expired list", zap.Error(err))
+		}
+		return p.cachedDomains, nil
+	}
+	return domains, err
+}
+
+// RefreshDomains fetches the domain list of the account again, regardless of
+// the cache, e.g. after a domain was added in the ipv64 dashboard.
+func (p *Provider) RefreshDomains(ctx context.Context) ([]string, error) {
+	p.domainsMu.Lock()
+	defer p.domainsMu.Unlock()
+	return p.fetchDomains(ctx)
+}
+
+// fetchDomains calls get_domains and updates the cache; callers must hold
+// domainsMu.
+func (p *Provider) fetchDomains(ctx context.Context) ([]string, error) {
+	domains, err := p.api.GetDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if p.logger != nil {
+		p.logger.Debug("ipv64: discovered account domains", zap.Strings("domains", domains))
+	}
+
+	p.cachedDomains = domains
+	p.domainsCached = true
+	p.domainsFetched = time.Now()
+	p.storeDomains(ctx)
+	return domains, nil
+}
+
+// storedDomains is the domain list of an account as kept in Caddy storage.
+type storedDomains struct {
+	Domains []string  `json:"domains"`
+	Fetched time.Time `json:"fetched"`
+}
+
+// domainsStorageKey returns the storage key of the domain list of token;
+// the token itself is not part of the key.
+func domainsStorageKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return path.Join("ipv64", "domains", hex.EncodeToString(sum[:8])+".json")
+}
+
+// loadDomains rehydrates the domain cache from storage, so that a config
+// reload does not trigger another get_domains call.
+func (p *Provider) loadDomains(ctx context.Context) {
+	if p.storage == nil || p.Token == "" {
+		return
+	}
+	data, err := p.storage.Load(ctx, domainsStorageKey(p.Token))
+	if err != nil {
+		return
+	}
+	var stored storedDomains
+	if err := json.Unmarshal(data, &stored); err != nil {
+		p.logger.Debug("ipv64: ignoring invalid stored domain list", zap.Error(err))
+		return
+	}
+	p.domainsMu.Lock()
+	defer p.domainsMu.Unlock()
+	p.cachedDomains = stored.Domains
+	p.domainsCached = true
+	p.domainsFetched = stored.Fetched
+}
+
+// storeDomains saves the domain cache; callers must hold domainsMu.
+func (p *Provider) storeDomains(ctx context.Context) {
+	if p.storage == nil {
+		return
+	}
+	data, err := json.Marshal(storedDomains{Domains: p.cachedDomains, Fetched: p.domainsFetched})
+	if err != nil {
+		return
+	}
+	if err := p.storage.Store(ctx, domainsStorageKey(p.Token), data); err != nil && p.logger != nil {
+		p.logger.Warn("ipv64: storing domain list failed", zap.Error(err))
+	}
+}
+
+// flushDomains forgets the cached domain list, in memory and in storage, so
+// that the next lookup fetches it again and picks up domains added in the
+// ipv64 dashboard.
+func (p *Provider) flushDomains(ctx context.Context) error {
+	p.domainsMu.Lock()
+	defer p.domainsMu.Unlock()
+	p.cachedDomains = nil
+	p.domainsCached = false
+	p.domainsFetched = time.Time{}
+	if p.storage == nil || p.Token == "" {
+		return nil
+	}
+	err := p.storage.Delete(ctx, domainsStorageKey(p.Token))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// managedZone returns the ipv64 domain that fqdn is managed under. Unless
+// the domain or zone_depth is configured explicitly, the longest matching
+// domain of the account wins. If the domain list cannot be fetched, the
+// get_domains error is returned, or with AllowAnyZone the naming heuristic
+// is used. Names outside of the managed domain are rejected unless
+// AllowAnyZone is set.
+func (p *Provider) managedZone(ctx context.Context, fqdn, zone string) (string, error) {
+	managed, err := p.lookupManagedZone(ctx, fqdn, zone)
+	if err != nil {
+		return "", err
+	}
+	if p.AllowAnyZone {
+		if managed == "" {
+			managed = p.deriveManagedZone(fqdn, zone)
+		}
+		return managed, nil
+	}
+	if managed == "" {
+		return "", fmt.Errorf("%s is not in any domain of the ipv64 account (set allow_any_zone to override)", strings.TrimSuffix(fqdn, "."))
+	}
+	if !domainMatches(fqdn, managed) {
+		return "", fmt.Errorf("%s is not below the ipv64 domain %s (set allow_any_zone to override)", strings.TrimSuffix(fqdn, "."), managed)
+	}
+	return managed, nil
+}
+
+func (p *Provider) lookupManagedZone(ctx context.Context, fqdn, zone string) (string, error) {
+	if p.Domain != "" {
+		return strings.TrimSuffix(p.Domain, "."), nil
+	}
+	if p.ZoneDepth > 0 {
+		labels := strings.Split(strings.TrimSuffix(fqdn, "."), ".")
+		if len(labels) < p.ZoneDepth {
+			return "", nil
+		}
+		return strings.Join(labels[len(labels)-p.ZoneDepth:], "."), nil
+	}
+	domains, err := p.domains(ctx)
+	if err != nil {
+		if !p.AllowAnyZone {
+			return "", fmt.Errorf("looking up the ipv64 domain of %s: get_domains: %w", strings.TrimSuffix(fqdn, "."), err)
+		}
+		if p.logger != nil {
+			p.logger.Warn("ipv64: get_domains failed, guessing managed zone", zap.String("fqdn", fqdn), zap.Error(err))
+		}
+		return p.deriveManagedZone(fqdn, zone), nil
+	}
+	return ipv64.ManagedZone(fqdn, domains), nil
+}
+
+// Interface guards
+var _ libdns.ZoneLister = (*Provider)(nil)
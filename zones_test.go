@@ -0,0 +1,53 @@
+package caddyipv64
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Sickjuicy/caddy-ipv64/internal/ipv64api"
+)
+
+func TestManagedZoneGetDomainsFails(t *testing.T) {
+	ctx := context.Background()
+	f := new(FakeServer)
+	newTestFakeServer(t, f, "example.ipv64.de")
+	// answer get_domains with a server error, everything else normally
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fakeParams(r).Has("get_domains") {
+			writeFakeJSON(w, http.StatusInternalServerError, map[string]any{"info": "error", "status": "500 Internal Server Error"})
+			return
+		}
+		f.serveAPI(w, r)
+	}))
+	defer srv.Close()
+
+	const fqdn, zone = "_acme-challenge.example.ipv64.de.", "example.ipv64.de."
+	for _, tc := range []struct {
+		name         string
+		allowAnyZone bool
+		want         string
+	}{
+		{"strict", false, ""},
+		{"allow_any_zone", true, "example.ipv64.de"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Provider{AllowAnyZone: tc.allowAnyZone, domainsMu: new(sync.Mutex)}
+			p.SetAPIClient(&ipv64api.Client{Endpoint: srv.URL, APIPath: "/"})
+			managed, err := p.managedZone(ctx, fqdn, zone)
+			if tc.allowAnyZone {
+				if err != nil || managed != tc.want {
+					t.Fatalf("managedZone = %q, %v; want the guessed %q", managed, err, tc.want)
+				}
+				return
+			}
+			var httpErr *ipv64api.HTTPError
+			if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusInternalServerError {
+				t.Fatalf("managedZone = %q, %v; want the get_domains error", managed, err)
+			}
+		})
+	}
+}